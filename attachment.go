@@ -0,0 +1,470 @@
+package pluggo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	// attachmentHeader names the input field of an attachment sent as a raw request body.
+	attachmentHeader = "X-Pluggo-Attachment"
+	// inputHeader carries the base64-encoded input of a request whose body is an attachment.
+	inputHeader = "X-Pluggo-Input"
+	// inputTypeHeader carries the content type of the input of requests with attachments.
+	inputTypeHeader = "X-Pluggo-Input-Type"
+
+	// maxHeaderInputSize is the size of the largest input sent in inputHeader;
+	// requests with larger inputs carry them in a multipart part instead, so
+	// that their headers stay well below the limits of the servers.
+	maxHeaderInputSize = 4 << 10
+
+	// inputPart and outputPart name the multipart parts carrying the function input and output.
+	inputPart  = "_input"
+	outputPart = "_output"
+
+	// DefaultMaxAttachmentSize is the default maximum size of the attachments of a request.
+	DefaultMaxAttachmentSize = 100 << 20
+	// DefaultAttachmentMemory is the default number of bytes of attachments kept
+	// in memory; larger attachments are spilled to temporary files.
+	DefaultAttachmentMemory = 10 << 20
+)
+
+var attachmentType = reflect.TypeOf((*Attachment)(nil))
+
+// Attachment is a binary payload, such as a file, passed to or returned by a
+// plugin function next to its JSON input or output. Declare attachments as
+// *Attachment fields of the input or output struct: they are described in
+// the payload by their metadata, and their content is streamed as a separate
+// part of the request or response.
+//
+// An attachment is an io.ReadCloser. Received attachments must be read
+// within the call that received them; Close releases their temporary files.
+type Attachment struct {
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	// Size is the length of the content in bytes, or zero when unknown, as
+	// for attachments received in chunked requests.
+	Size int64 `json:"size,omitempty"`
+
+	reader io.Reader
+}
+
+// NewAttachment creates an attachment streaming the content of r.
+// If r is an io.Closer, it is closed once the attachment has been sent.
+func NewAttachment(filename, contentType string, r io.Reader) *Attachment {
+	a := &Attachment{Filename: filename, ContentType: contentType, reader: r}
+
+	switch r := r.(type) {
+	case *bytes.Reader:
+		a.Size = int64(r.Len())
+	case *bytes.Buffer:
+		a.Size = int64(r.Len())
+	case *strings.Reader:
+		a.Size = int64(r.Len())
+	case *os.File:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			a.Size = info.Size()
+		}
+	}
+
+	return a
+}
+
+// Read implements io.Reader.
+func (a *Attachment) Read(p []byte) (int, error) {
+	if a.reader == nil {
+		return 0, io.EOF
+	}
+	return a.reader.Read(p)
+}
+
+// Close implements io.Closer.
+func (a *Attachment) Close() error {
+	if closer, ok := a.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// contentType returns the content type of the attachment, defaulting to application/octet-stream.
+func (a *Attachment) contentType() string {
+	if a.ContentType == "" {
+		return "application/octet-stream"
+	}
+	return a.ContentType
+}
+
+// namedAttachment is an attachment with the name of the field holding it.
+type namedAttachment struct {
+	name       string
+	attachment *Attachment
+}
+
+// attachmentFields returns the *Attachment fields of the struct pointed to by
+// v, keyed by their JSON name.
+func attachmentFields(v any) map[string]reflect.Value {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	value = value.Elem()
+
+	var fields map[string]reflect.Value
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() || field.Type != attachmentType {
+			continue
+		}
+
+		name := field.Name
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		if fields == nil {
+			fields = make(map[string]reflect.Value)
+		}
+		fields[name] = value.Field(i)
+	}
+
+	return fields
+}
+
+// attachmentsOf returns the non-nil attachments of the struct pointed to by v.
+func attachmentsOf(v any) []namedAttachment {
+	var attachments []namedAttachment
+	for name, field := range attachmentFields(v) {
+		if attachment, _ := field.Interface().(*Attachment); attachment != nil {
+			attachments = append(attachments, namedAttachment{name: name, attachment: attachment})
+		}
+	}
+	return attachments
+}
+
+// hasAttachments reports whether a request carries attachments, as a raw
+// body or as a multipart body.
+func hasAttachments(r *http.Request) bool {
+	return r.Header.Get(attachmentHeader) != "" || isMultipart(r.Header.Get("Content-Type"))
+}
+
+// isMultipart reports whether a Content-Type header denotes a multipart/form-data body.
+func isMultipart(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "multipart/form-data"
+}
+
+// inputContentType returns the content type of the function input of a request.
+func inputContentType(r *http.Request) string {
+	if hasAttachments(r) {
+		return r.Header.Get(inputTypeHeader)
+	}
+	return r.Header.Get("Content-Type")
+}
+
+// rawInput returns the input of a request whose body is a raw attachment.
+func rawInput(r *http.Request) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(r.Header.Get(inputHeader))
+}
+
+// attachmentLimits bounds the attachments accepted by a function handler.
+type attachmentLimits struct {
+	maxSize   int64
+	maxMemory int64
+}
+
+// WithAttachmentLimits sets the maximum total size of the attachments of a
+// request, and how many bytes of them are kept in memory before spilling to
+// temporary files. Larger requests fail with 413 Request Entity Too Large.
+// Defaults to DefaultMaxAttachmentSize and DefaultAttachmentMemory.
+func WithAttachmentLimits(maxSize, maxMemory int64) HandlerOption {
+	return func(o *handlerOptions) {
+		o.attachments = attachmentLimits{maxSize: maxSize, maxMemory: maxMemory}
+	}
+}
+
+// decodeAttachments reads the input and attachments of a request into req,
+// which must be a pointer to the input struct. The returned function releases
+// the temporary files of the attachments; the report is set when the
+// validator normalizes inputs.
+func decodeAttachments(w http.ResponseWriter, r *http.Request, codec Codec, validator inputValidator, req any, limits attachmentLimits) (func(), *NormalizationReport, error) {
+	fields := attachmentFields(req)
+	if r.ContentLength > limits.maxSize {
+		return func() {}, nil, &http.MaxBytesError{Limit: limits.maxSize}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limits.maxSize)
+
+	if name := r.Header.Get(attachmentHeader); name != "" {
+		input, err := rawInput(r)
+		if err != nil {
+			return func() {}, nil, fmt.Errorf("invalid %s header: %w", inputHeader, err)
+		}
+		report, err := decodeData(input, codec, validator, req)
+		if err != nil {
+			return func() {}, nil, err
+		}
+
+		field, ok := fields[name]
+		if !ok {
+			return func() {}, nil, fmt.Errorf("unknown attachment %q", name)
+		}
+		for _, other := range fields {
+			other.Set(reflect.Zero(attachmentType))
+		}
+
+		attachment := &Attachment{ContentType: r.Header.Get("Content-Type"), Size: max(r.ContentLength, 0), reader: r.Body}
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+			attachment.Filename = params["filename"]
+		}
+		field.Set(reflect.ValueOf(attachment))
+
+		return func() { _ = r.Body.Close() }, report, nil
+	}
+
+	if err := r.ParseMultipartForm(limits.maxMemory); err != nil {
+		return func() {}, nil, err
+	}
+	form := r.MultipartForm
+	cleanup := func() { _ = form.RemoveAll() }
+
+	var input []byte
+	if values := form.Value[inputPart]; len(values) > 0 {
+		input = []byte(values[0])
+	}
+	report, err := decodeData(input, codec, validator, req)
+	if err != nil {
+		return cleanup, nil, err
+	}
+
+	var files []multipart.File
+	for name, field := range fields {
+		headers := form.File[name]
+		if len(headers) == 0 {
+			field.Set(reflect.Zero(attachmentType))
+			continue
+		}
+
+		file, err := headers[0].Open()
+		if err != nil {
+			return cleanup, nil, err
+		}
+		files = append(files, file)
+
+		field.Set(reflect.ValueOf(&Attachment{
+			Filename:    headers[0].Filename,
+			ContentType: headers[0].Header.Get("Content-Type"),
+			Size:        headers[0].Size,
+			reader:      file,
+		}))
+	}
+
+	return func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+		cleanup()
+	}, report, nil
+}
+
+// writeAttachments writes the output of a function and its attachments as a
+// multipart response.
+func writeAttachments(w http.ResponseWriter, codec Codec, v any, attachments []namedAttachment) error {
+	b, err := codec.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return err
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", mw.FormDataContentType())
+	w.WriteHeader(http.StatusOK)
+
+	if err := writePart(mw, outputPart, "", codec.ContentType(), bytes.NewReader(b)); err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		err := writePart(mw, a.name, a.attachment.Filename, a.attachment.contentType(), a.attachment)
+		_ = a.attachment.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// writePart writes a multipart part with the content of r.
+func writePart(mw *multipart.Writer, name, filename, contentType string, r io.Reader) error {
+	disposition := map[string]string{"name": name}
+	if filename != "" || name != inputPart && name != outputPart {
+		disposition["filename"] = filename
+		if filename == "" {
+			disposition["filename"] = name
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", disposition))
+	header.Set("Content-Type", contentType)
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(part, r)
+	return err
+}
+
+// setAttachmentBody replaces the body of a function call request with its
+// input and attachments: a single attachment next to a small input is
+// streamed as the raw body with the input in a header, other attachments are
+// streamed as a multipart body.
+func setAttachmentBody(req *http.Request, codec Codec, input []byte, attachments []namedAttachment) {
+	req.GetBody = nil
+	req.Header.Set(inputTypeHeader, codec.ContentType())
+
+	if len(attachments) == 1 && len(input) <= maxHeaderInputSize {
+		a := attachments[0]
+		req.Body = io.NopCloser(a.attachment)
+		req.ContentLength = a.attachment.Size
+		req.Header.Set("Content-Type", a.attachment.contentType())
+		req.Header.Set(attachmentHeader, a.name)
+		req.Header.Set(inputHeader, base64.StdEncoding.EncodeToString(input))
+		if a.attachment.Filename != "" {
+			req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.attachment.Filename}))
+		}
+		return
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		err := writePart(mw, inputPart, "", codec.ContentType(), bytes.NewReader(input))
+		for _, a := range attachments {
+			if err == nil {
+				err = writePart(mw, a.name, a.attachment.Filename, a.attachment.contentType(), a.attachment)
+			}
+			_ = a.attachment.Close()
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	req.Body = pr
+	req.ContentLength = 0
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+}
+
+// decodeAttachmentOutput reads a multipart function response into output,
+// which must be a pointer to the output struct. The encoded output part is
+// passed to decode with its codec. Attachments are kept in memory up to
+// DefaultAttachmentMemory bytes and spilled to temporary files beyond.
+func decodeAttachmentOutput(resp *http.Response, codecs []Codec, decode func(codec Codec, out []byte) error, output any) error {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	fields := attachmentFields(output)
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if part.FormName() == outputPart {
+			codec := codecFor(codecs, part.Header.Get("Content-Type"))
+			if codec == nil {
+				return fmt.Errorf("unsupported content type %q", part.Header.Get("Content-Type"))
+			}
+
+			b, err := io.ReadAll(part)
+			if err != nil {
+				return err
+			}
+			if err := decode(codec, b); err != nil {
+				return err
+			}
+			continue
+		}
+
+		field, ok := fields[part.FormName()]
+		if !ok {
+			continue
+		}
+
+		attachment, err := spool(part, DefaultAttachmentMemory)
+		if err != nil {
+			return err
+		}
+		attachment.Filename = part.FileName()
+		attachment.ContentType = part.Header.Get("Content-Type")
+		field.Set(reflect.ValueOf(attachment))
+	}
+}
+
+// spool reads r into memory up to maxMemory bytes, and into a temporary file
+// beyond. Closing the returned attachment removes the temporary file.
+func spool(r io.Reader, maxMemory int64) (*Attachment, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, maxMemory+1)
+	if errors.Is(err, io.EOF) {
+		return &Attachment{Size: n, reader: bytes.NewReader(buf.Bytes())}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp("", "pluggo-attachment-")
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := io.Copy(file, io.MultiReader(&buf, r))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &Attachment{Size: size, reader: &tempFile{File: file}}, nil
+}
+
+// tempFile is a temporary file removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+// Close closes and removes the file.
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
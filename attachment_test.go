@@ -0,0 +1,250 @@
+package pluggo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type uploadInput struct {
+	Note  string      `json:"note"`
+	File  *Attachment `json:"file"`
+	Extra *Attachment `json:"extra,omitempty"`
+}
+
+type uploadOutput struct {
+	Note     string            `json:"note"`
+	Received map[string]string `json:"received"`
+	Echo     *Attachment       `json:"echo"`
+}
+
+// upload returns the filename, content type and content of the attachments
+// it received, and echoes the content of the file attachment back.
+func upload(_ context.Context, in *uploadInput) (*uploadOutput, error) {
+	out := &uploadOutput{Note: in.Note, Received: make(map[string]string)}
+
+	var echo []byte
+	for name, attachment := range map[string]*Attachment{"file": in.File, "extra": in.Extra} {
+		if attachment == nil {
+			continue
+		}
+		b, err := io.ReadAll(attachment)
+		if err != nil {
+			return nil, err
+		}
+		out.Received[name] = attachment.Filename + " " + attachment.ContentType + " " + string(b)
+		if name == "file" {
+			echo = b
+		}
+	}
+
+	out.Echo = NewAttachment("echo.bin", "application/octet-stream", bytes.NewReader(echo))
+	return out, nil
+}
+
+// serveUpload serves the upload function, reporting the Content-Type header
+// of every request it receives.
+func serveUpload(t *testing.T) (*Function[uploadInput, uploadOutput], func() string) {
+	t.Helper()
+
+	var (
+		mu          sync.Mutex
+		contentType string
+	)
+	handler := NewFunctionHandler(upload, nil).Handler()
+	next := handler.HTTPHandler
+	handler.HTTPHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		contentType = r.Header.Get("Content-Type")
+		mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+
+	p := newTestPlugin(t)
+	if err := p.AddFunction("upload", handler); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestFunction[uploadInput, uploadOutput](t, "upload", serve(t, p, ProtocolREST))
+	return f, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return contentType
+	}
+}
+
+func TestAttachmentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         func() *uploadInput
+		wantMultipart bool
+		wantReceived  map[string]string
+	}{
+		{
+			name: "single attachment",
+			input: func() *uploadInput {
+				return &uploadInput{Note: "small", File: NewAttachment("a.txt", "text/plain", strings.NewReader("alpha"))}
+			},
+			wantReceived: map[string]string{"file": "a.txt text/plain alpha"},
+		},
+		{
+			name: "multiple attachments",
+			input: func() *uploadInput {
+				return &uploadInput{
+					Note:  "small",
+					File:  NewAttachment("a.txt", "text/plain", strings.NewReader("alpha")),
+					Extra: NewAttachment("b.csv", "text/csv", strings.NewReader("beta")),
+				}
+			},
+			wantMultipart: true,
+			wantReceived:  map[string]string{"file": "a.txt text/plain alpha", "extra": "b.csv text/csv beta"},
+		},
+		{
+			name: "large input",
+			input: func() *uploadInput {
+				return &uploadInput{Note: strings.Repeat("n", maxHeaderInputSize), File: NewAttachment("a.txt", "text/plain", strings.NewReader("alpha"))}
+			},
+			wantMultipart: true,
+			wantReceived:  map[string]string{"file": "a.txt text/plain alpha"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, contentType := serveUpload(t)
+			input := tt.input()
+
+			out, err := f.Call(input)
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if got := isMultipart(contentType()); got != tt.wantMultipart {
+				t.Errorf("multipart request = %t, want %t (Content-Type %q)", got, tt.wantMultipart, contentType())
+			}
+			if out.Note != input.Note {
+				t.Errorf("note of %d bytes, want %d", len(out.Note), len(input.Note))
+			}
+			if len(out.Received) != len(tt.wantReceived) {
+				t.Errorf("received %v, want %v", out.Received, tt.wantReceived)
+			}
+			for name, want := range tt.wantReceived {
+				if got := out.Received[name]; got != want {
+					t.Errorf("attachment %s = %q, want %q", name, got, want)
+				}
+			}
+
+			if out.Echo == nil {
+				t.Fatal("no output attachment")
+			}
+			defer func() {
+				_ = out.Echo.Close()
+			}()
+			echo, err := io.ReadAll(out.Echo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(echo) != "alpha" || out.Echo.Filename != "echo.bin" || out.Echo.ContentType != "application/octet-stream" {
+				t.Errorf("output attachment = %s %s %q", out.Echo.Filename, out.Echo.ContentType, echo)
+			}
+		})
+	}
+}
+
+func TestAttachmentSpilledToFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<10)
+
+	attachment, err := spool(bytes.NewReader(content), 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, ok := attachment.reader.(*tempFile)
+	if !ok {
+		t.Fatalf("attachment of %d bytes kept in memory past the limit", len(content))
+	}
+
+	got, err := io.ReadAll(attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) || attachment.Size != int64(len(content)) {
+		t.Errorf("spilled attachment = %d bytes (size %d), want %d", len(got), attachment.Size, len(content))
+	}
+
+	if err := attachment.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file.Name()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("temporary file after Close: %v, want it removed", err)
+	}
+}
+
+type signedOutput struct {
+	Greeting string      `json:"greeting" jsonschema:"minLength=1"`
+	File     *Attachment `json:"file"`
+}
+
+func TestAttachmentOutputValidation(t *testing.T) {
+	handler := NewFunctionHandler(func(_ context.Context, in *greetInput) (*signedOutput, error) {
+		return &signedOutput{Greeting: in.Name, File: NewAttachment("a.txt", "text/plain", strings.NewReader("alpha"))}, nil
+	}, nil).Handler()
+	p := newTestPlugin(t)
+	if err := p.AddFunction("sign", handler); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := NewMetricsRegistry()
+	connection := serve(t, p, ProtocolREST)
+	connection.Plugin = "test"
+	connection.Metrics = metrics
+	f := newTestFunction[greetInput, signedOutput](t, "sign", connection)
+	f.SetOutputValidation(true)
+
+	var violation *ContractViolationError
+	if _, err := f.Call(&greetInput{}); !errors.As(err, &violation) {
+		t.Errorf("invalid output with an attachment = %v, want a ContractViolationError", err)
+	}
+
+	out, err := f.Call(&greetInput{Name: "ada"})
+	if err != nil {
+		t.Fatalf("valid output with an attachment: %v", err)
+	}
+	defer func() {
+		_ = out.File.Close()
+	}()
+	if got := metrics.Value(MetricClientResponseBytes, Labels{"plugin": "test", "function": "sign"}); got == 0 {
+		t.Error("response bytes of outputs with attachments were not recorded")
+	}
+}
+
+func TestAttachmentUnknownSize(t *testing.T) {
+	handler := NewFunctionHandler(func(_ context.Context, in *uploadInput) (*uploadOutput, error) {
+		b, err := io.ReadAll(in.File)
+		if err != nil {
+			return nil, err
+		}
+		return &uploadOutput{Received: map[string]string{"file": string(b)}, Note: strconv.FormatInt(in.File.Size, 10)}, nil
+	}, nil).Handler()
+	p := newTestPlugin(t)
+	if err := p.AddFunction("size", handler); err != nil {
+		t.Fatal(err)
+	}
+	f := newTestFunction[uploadInput, uploadOutput](t, "size", serve(t, p, ProtocolREST))
+
+	// A reader of unknown length is sent in a chunked request
+	file := NewAttachment("a.txt", "text/plain", io.MultiReader(strings.NewReader("alpha")))
+	out, err := f.Call(&uploadInput{File: file})
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if out.Received["file"] != "alpha" || out.Note != "0" {
+		t.Errorf("attachment of unknown size = %q with size %s, want alpha with size 0", out.Received["file"], out.Note)
+	}
+}
@@ -0,0 +1,303 @@
+package pluggo
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults applied to the zero fields of CircuitBreakerSettings.
+const (
+	defaultFailureRateThreshold = 0.5
+	defaultMinimumCalls         = 10
+	defaultBreakerWindow        = time.Minute
+	defaultCoolDown             = 30 * time.Second
+	defaultHalfOpenCalls        = 1
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets calls through and counts their failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails calls immediately with a CircuitOpenError.
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial calls through to probe the plugin.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerSettings configures a CircuitBreaker. Zero fields take their default.
+type CircuitBreakerSettings struct {
+	// FailureRateThreshold is the failure rate, from 0 to 1, that opens the
+	// circuit. Defaults to 0.5.
+	FailureRateThreshold float64
+	// MinimumCalls is the number of calls within Window needed before the
+	// failure rate is evaluated. Defaults to 10.
+	MinimumCalls int
+	// Window is the period over which calls are counted. Defaults to 1 minute.
+	Window time.Duration
+	// CoolDown is how long the circuit stays open before letting trial calls
+	// through. Defaults to 30 seconds.
+	CoolDown time.Duration
+	// HalfOpenCalls is the number of trial calls let through while half-open;
+	// the circuit closes once they all succeed. Defaults to 1.
+	HalfOpenCalls int
+	// OnStateChange is called with the name of the breaker whenever its state
+	// changes, e.g. to export it to a dashboard. Calls are never concurrent
+	// and follow the order of the changes.
+	OnStateChange func(name string, from, to CircuitState)
+}
+
+// CircuitBreaker stops calling a degraded plugin: once the failure rate of
+// the calls exceeds a threshold the circuit opens and calls fail immediately
+// with a CircuitOpenError, until a cool-down elapsed and trial calls succeed.
+// Transport errors, timeouts, 5xx responses, busy plugins and panics count as failures;
+// invalid inputs, unknown functions and calls cancelled by the caller don't.
+//
+// A breaker is shared by all the functions of a client with WithCircuitBreaker,
+// or dedicated to a function with Function.SetCircuitBreaker.
+type CircuitBreaker struct {
+	name     string
+	settings CircuitBreakerSettings
+
+	mu          sync.Mutex
+	state       CircuitState
+	generation  uint64
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	trials      int
+	successes   int
+
+	// changes are the state changes not yet passed to OnStateChange, in
+	// order; notifying is set while a goroutine is passing them.
+	changes   []stateChange
+	notifying bool
+}
+
+// stateChange is a transition of a CircuitBreaker.
+type stateChange struct {
+	from, to CircuitState
+}
+
+// NewCircuitBreaker creates a closed circuit breaker. name identifies the
+// breaker in state-change callbacks and errors.
+func NewCircuitBreaker(name string, settings CircuitBreakerSettings) *CircuitBreaker {
+	if settings.FailureRateThreshold <= 0 {
+		settings.FailureRateThreshold = defaultFailureRateThreshold
+	}
+	if settings.MinimumCalls <= 0 {
+		settings.MinimumCalls = defaultMinimumCalls
+	}
+	if settings.Window <= 0 {
+		settings.Window = defaultBreakerWindow
+	}
+	if settings.CoolDown <= 0 {
+		settings.CoolDown = defaultCoolDown
+	}
+	if settings.HalfOpenCalls <= 0 {
+		settings.HalfOpenCalls = defaultHalfOpenCalls
+	}
+
+	return &CircuitBreaker{name: name, settings: settings, windowStart: time.Now()}
+}
+
+// WithCircuitBreaker sets a circuit breaker shared by all the functions
+// called through the client.
+func WithCircuitBreaker(breaker *CircuitBreaker) ClientOption {
+	return func(p *Client) {
+		p.circuitBreaker = breaker
+	}
+}
+
+// SetCircuitBreaker sets the circuit breaker of this function, overriding
+// the breaker of the connection.
+func (f *Function[T, R]) SetCircuitBreaker(breaker *CircuitBreaker) {
+	f.circuitBreaker = breaker
+}
+
+// Name returns the name of the breaker.
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	state := b.refresh(time.Now())
+	b.mu.Unlock()
+
+	b.notify()
+	return state
+}
+
+// allow reports whether a call may proceed. It returns the generation to pass
+// to record, or the time the circuit may let calls through again.
+func (b *CircuitBreaker) allow() (uint64, time.Time, bool) {
+	now := time.Now()
+
+	b.mu.Lock()
+	state := b.refresh(now)
+
+	allowed := true
+	switch state {
+	case CircuitOpen:
+		allowed = false
+	case CircuitHalfOpen:
+		if b.trials >= b.settings.HalfOpenCalls {
+			allowed = false
+		} else {
+			b.trials++
+		}
+	}
+	generation, retryAt := b.generation, b.openedAt.Add(b.settings.CoolDown)
+	b.mu.Unlock()
+
+	b.notify()
+	return generation, retryAt, allowed
+}
+
+// record records the outcome of a call allowed in the given generation.
+// Outcomes of calls started before the last state change are ignored.
+func (b *CircuitBreaker) record(generation uint64, failed bool) {
+	now := time.Now()
+
+	b.mu.Lock()
+	if generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
+
+	switch b.state {
+	case CircuitClosed:
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if b.calls >= b.settings.MinimumCalls && float64(b.failures)/float64(b.calls) >= b.settings.FailureRateThreshold {
+			b.setState(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			b.setState(CircuitOpen, now)
+			break
+		}
+		b.successes++
+		if b.successes >= b.settings.HalfOpenCalls {
+			b.setState(CircuitClosed, now)
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify()
+}
+
+// release gives back the trial slot of a call allowed in the given
+// generation whose outcome doesn't count, e.g. because the caller cancelled it.
+func (b *CircuitBreaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == CircuitHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// refresh moves the breaker to half-open once the cool-down elapsed and
+// resets the counts of an expired window. The caller must hold b.mu.
+func (b *CircuitBreaker) refresh(now time.Time) CircuitState {
+	switch b.state {
+	case CircuitClosed:
+		if now.Sub(b.windowStart) >= b.settings.Window {
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+	case CircuitOpen:
+		if now.Sub(b.openedAt) >= b.settings.CoolDown {
+			b.setState(CircuitHalfOpen, now)
+		}
+	}
+
+	return b.state
+}
+
+// setState moves the breaker to a new state, starting a new generation, and
+// queues the change for notify. The caller must hold b.mu.
+func (b *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	if b.settings.OnStateChange != nil {
+		b.changes = append(b.changes, stateChange{from: b.state, to: state})
+	}
+	b.state = state
+	b.generation++
+	b.windowStart, b.calls, b.failures = now, 0, 0
+	b.trials, b.successes = 0, 0
+	if state == CircuitOpen {
+		b.openedAt = now
+	}
+}
+
+// notify passes the queued state changes to the state-change callback,
+// without holding b.mu. Changes are passed one at a time and in order: when
+// another goroutine is already passing them, it passes the new ones too.
+func (b *CircuitBreaker) notify() {
+	b.mu.Lock()
+	if b.notifying {
+		b.mu.Unlock()
+		return
+	}
+	b.notifying = true
+
+	for len(b.changes) > 0 {
+		changes := b.changes
+		b.changes = nil
+		b.mu.Unlock()
+		b.deliver(changes)
+		b.mu.Lock()
+	}
+
+	b.notifying = false
+	b.mu.Unlock()
+}
+
+// deliver calls the state-change callback with changes. If the callback
+// panics, the next call of notify takes over.
+func (b *CircuitBreaker) deliver(changes []stateChange) {
+	delivered := false
+	defer func() {
+		if !delivered {
+			b.mu.Lock()
+			b.notifying = false
+			b.mu.Unlock()
+		}
+	}()
+
+	for _, change := range changes {
+		b.settings.OnStateChange(b.name, change.from, change.to)
+	}
+	delivered = true
+}
+
+// breakerFailure reports whether a call that failed with an error of class
+// errClass counts as a failure for circuit breakers.
+func breakerFailure(errClass string) bool {
+	switch errClass {
+	case ErrorClassTransport, ErrorClassTimeout, ErrorClassServer, ErrorClassBusy, ErrorClassPanic:
+		return true
+	default:
+		return false
+	}
+}
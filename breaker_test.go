@@ -0,0 +1,220 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// transitionRecorder records the state changes of a breaker.
+type transitionRecorder struct {
+	mu          sync.Mutex
+	transitions []string
+}
+
+func (r *transitionRecorder) record(_ string, from, to CircuitState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.transitions = append(r.transitions, from.String()+" -> "+to.String())
+}
+
+func (r *transitionRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.transitions)
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var (
+		failing    atomic.Bool
+		executions atomic.Int32
+	)
+	p := newTestPlugin(t)
+	handler := NewFunctionHandler(func(_ context.Context, in *greetInput) (*greetOutput, error) {
+		executions.Add(1)
+		if failing.Load() {
+			return nil, errors.New("degraded")
+		}
+		return &greetOutput{Greeting: "hello " + in.Name}, nil
+	}, nil).Handler()
+	if err := p.AddFunction("unstable", handler); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &transitionRecorder{}
+	breaker := NewCircuitBreaker("unstable", CircuitBreakerSettings{
+		MinimumCalls:  2,
+		CoolDown:      50 * time.Millisecond,
+		OnStateChange: recorder.record,
+	})
+	f := newTestFunction[greetInput, greetOutput](t, "unstable", serve(t, p, ProtocolREST))
+	f.SetCircuitBreaker(breaker)
+
+	call := func() error {
+		_, err := f.Call(&greetInput{Name: "ada"})
+		return err
+	}
+	coolDown := func() {
+		time.Sleep(60 * time.Millisecond)
+		if state := breaker.State(); state != CircuitHalfOpen {
+			t.Fatalf("state after the cool-down = %s, want %s", state, CircuitHalfOpen)
+		}
+	}
+
+	failing.Store(true)
+	for range 2 {
+		var executionErr *FunctionExecutionError
+		if err := call(); !errors.As(err, &executionErr) {
+			t.Fatalf("failing call = %v, want a FunctionExecutionError", err)
+		}
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state after failures = %s, want %s", state, CircuitOpen)
+	}
+
+	var openErr *CircuitOpenError
+	if err := call(); !errors.As(err, &openErr) || openErr.Breaker != "unstable" || openErr.RetryAt.IsZero() {
+		t.Fatalf("call while open = %v, want a CircuitOpenError", err)
+	}
+	if got := executions.Load(); got != 2 {
+		t.Errorf("executions = %d, want the call rejected while open", got)
+	}
+
+	coolDown()
+	if err := call(); errors.As(err, &openErr) || err == nil {
+		t.Fatalf("failing trial call = %v, want the error of the function", err)
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state after a failed trial = %s, want %s", state, CircuitOpen)
+	}
+
+	coolDown()
+	failing.Store(false)
+	if err := call(); err != nil {
+		t.Fatalf("trial call: %v", err)
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("state after a successful trial = %s, want %s", state, CircuitClosed)
+	}
+
+	want := []string{
+		"closed -> open",
+		"open -> half-open",
+		"half-open -> open",
+		"open -> half-open",
+		"half-open -> closed",
+	}
+	if got := recorder.get(); !slices.Equal(got, want) {
+		t.Errorf("transitions = %q, want %q", got, want)
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	breaker := NewCircuitBreaker("greet", CircuitBreakerSettings{MinimumCalls: 2})
+	f := newTestFunction[greetInput, greetOutput](t, "greet", serve(t, newTestPlugin(t), ProtocolREST))
+	f.SetCircuitBreaker(breaker)
+
+	for range 3 {
+		var validation *ValidationError
+		if _, err := f.Call(&greetInput{}); !errors.As(err, &validation) {
+			t.Fatalf("error = %v, want a ValidationError", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range 3 {
+		if _, err := f.CallContext(ctx, &greetInput{Name: "ada"}); err == nil {
+			t.Fatal("cancelled call succeeded")
+		}
+	}
+
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("state = %s, want %s", state, CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerHalfOpenTrials(t *testing.T) {
+	breaker := NewCircuitBreaker("trials", CircuitBreakerSettings{MinimumCalls: 1, CoolDown: time.Millisecond, HalfOpenCalls: 2})
+
+	generation, _, _ := breaker.allow()
+	breaker.record(generation, true)
+	time.Sleep(5 * time.Millisecond)
+
+	first, _, ok := breaker.allow()
+	if !ok {
+		t.Fatal("first trial call rejected")
+	}
+	if _, _, ok := breaker.allow(); !ok {
+		t.Fatal("second trial call rejected")
+	}
+	if _, _, ok := breaker.allow(); ok {
+		t.Fatal("trial call past HalfOpenCalls allowed")
+	}
+
+	breaker.release(first)
+	third, _, ok := breaker.allow()
+	if !ok {
+		t.Fatal("released trial slot not given back")
+	}
+
+	breaker.record(generation, true)
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Errorf("state after an outcome of a previous generation = %s, want %s", state, CircuitHalfOpen)
+	}
+
+	breaker.record(first, false)
+	breaker.record(third, false)
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("state after successful trials = %s, want %s", state, CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerNotificationOrder(t *testing.T) {
+	var (
+		active atomic.Int32
+		last   = CircuitClosed
+		broken atomic.Bool
+	)
+	breaker := NewCircuitBreaker("busy", CircuitBreakerSettings{
+		MinimumCalls: 1,
+		CoolDown:     time.Nanosecond,
+		OnStateChange: func(_ string, from, to CircuitState) {
+			if active.Add(1) != 1 || from != last {
+				broken.Store(true)
+			}
+			runtime.Gosched()
+			last = to
+			active.Add(-1)
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 500 {
+				if generation, _, ok := breaker.allow(); ok {
+					breaker.record(generation, (i+j)%2 == 0)
+				}
+				_ = breaker.State()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if broken.Load() {
+		t.Error("state changes were notified concurrently or out of order")
+	}
+	if state := breaker.State(); last != state {
+		t.Errorf("last notified state = %s, want %s", last, state)
+	}
+}
@@ -0,0 +1,19 @@
+package pluggo
+
+import "context"
+
+// Caller is the host-side view of a typed plugin function.
+// *Function implements it, so host code can depend on Caller and swap in a
+// fake (see the pluggotest package) when testing without a running plugin.
+type Caller[T, R any] interface {
+	// Name returns the name of the function as registered with the plugin.
+	Name() string
+	// Call executes the function with the provided input and returns the result.
+	Call(input *T) (*R, error)
+	// CallContext is like Call but carries ctx to the plugin.
+	CallContext(ctx context.Context, input *T) (*R, error)
+	// Schema retrieves the JSON schema definition for the function's input and output types.
+	Schema() (*Schema, error)
+}
+
+var _ Caller[struct{}, struct{}] = (*Function[struct{}, struct{}])(nil)
@@ -17,11 +17,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,6 +33,7 @@ const (
 	defaultHost   = "127.0.0.1"
 	schemasPath   = "/_schemas"
 	healthPath    = "/_healthz"
+	metricsPath   = "/_metrics"
 
 	// DefaultFunctionExecutionTimeout is the HTTP timeout for requests the launcher makes to the plugin (health + exec)
 	DefaultFunctionExecutionTimeout = 2 * time.Minute
@@ -41,22 +45,91 @@ const (
 
 // Connection represents an active HTTP connection to a plugin server.
 // It contains the base URL and configuration for communication with the plugin.
+// Transport is optional and defaults to http.DefaultTransport. Tracer and
+// Metrics are optional; Propagator defaults to W3CPropagator. Plugin is the
+// name used to label metrics. Protocol defaults to ProtocolREST and Codec to JSONCodec.
+// RetryPolicy and CircuitBreaker are optional; without them calls are neither
+// retried nor short-circuited.
 type Connection struct {
 	FunctionExecutionTimeout time.Duration
 	BaseURL                  string
+	Plugin                   string
+	Protocol                 Protocol
+	Codec                    Codec
+	Transport                http.RoundTripper
+	Tracer                   Tracer
+	Propagator               Propagator
+	Metrics                  Metrics
+	RetryPolicy              *RetryPolicy
+	CircuitBreaker           *CircuitBreaker
+	// MaxResponseSize bounds the size of the responses read from the plugin.
+	// Zero means DefaultMaxResponseSize and negative values disable the limit.
+	MaxResponseSize int64
+	// ValidateOutput validates the outputs of the functions against the
+	// output schemas advertised by the plugin.
+	ValidateOutput bool
+
+	mu      sync.RWMutex
+	onPanic func()
 }
 
+// baseURL returns the current base URL of the plugin, which changes when the
+// plugin is restarted.
+func (c *Connection) baseURL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.BaseURL
+}
+
+// setBaseURL updates the base URL of the plugin after a restart.
+func (c *Connection) setBaseURL(baseURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.BaseURL = baseURL
+}
+
+// PanicPolicy decides how a client reacts when a plugin function panics.
+type PanicPolicy int
+
+const (
+	// PanicPolicyReport only reports the panic to the caller as a PluginPanicError.
+	PanicPolicyReport PanicPolicy = iota
+	// PanicPolicyRestart also restarts the plugin process in the background.
+	PanicPolicyRestart
+)
+
 // Client manages the lifecycle and communication with a plugin process.
 // It handles launching the plugin executable, establishing HTTP communication,
 // health checking, and graceful shutdown.
 type Client struct {
 	path                     string
+	name                     string
 	functionExecutionTimeout time.Duration
 	healthCheckTimeout       time.Duration
 	healthCheckInterval      time.Duration
 	heartbeatInterval        time.Duration
 	heartbeatChan            chan struct{}
-
+	recorder                 *Recorder
+	replayer                 *Replayer
+	tracer                   Tracer
+	propagator               Propagator
+	metrics                  Metrics
+	panicPolicy              PanicPolicy
+	protocol                 Protocol
+	codec                    Codec
+	retryPolicy              *RetryPolicy
+	circuitBreaker           *CircuitBreaker
+	maxResponseSize          int64
+	validateOutput           bool
+	stdio                    bool
+	stdioTransport           *stdioTransport
+	opened                   bool
+	restarting               atomic.Bool
+
+	mu             sync.Mutex
+	ctx            context.Context
 	httpClient     *http.Client
 	connection     *Connection
 	commandContext *exec.Cmd
@@ -94,16 +167,99 @@ func WithHeartbeatInterval(interval time.Duration) ClientOption {
 	}
 }
 
+// WithRecorder records the traffic between the client and the plugin into the
+// recorder's cassette, including the results of Schemas.
+func WithRecorder(recorder *Recorder) ClientOption {
+	return func(p *Client) {
+		p.recorder = recorder
+	}
+}
+
+// WithReplayer serves every request from the replayer's cassette instead of
+// launching the plugin. The plugin path is ignored by Open.
+func WithReplayer(replayer *Replayer) ClientOption {
+	return func(p *Client) {
+		p.replayer = replayer
+	}
+}
+
+// WithTracer sets the tracer used to create a client span around every function call.
+func WithTracer(tracer Tracer) ClientOption {
+	return func(p *Client) {
+		p.tracer = tracer
+	}
+}
+
+// WithPropagator sets the propagator used to inject trace context into function calls.
+// Defaults to W3CPropagator.
+func WithPropagator(propagator Propagator) ClientOption {
+	return func(p *Client) {
+		p.propagator = propagator
+	}
+}
+
+// WithMetrics sets the metrics hook used to instrument function calls and the plugin lifecycle.
+func WithMetrics(metrics Metrics) ClientOption {
+	return func(p *Client) {
+		p.metrics = metrics
+	}
+}
+
+// WithName sets the name identifying the plugin, e.g. in metrics labels.
+// Defaults to the base name of the plugin path.
+func WithName(name string) ClientOption {
+	return func(p *Client) {
+		p.name = name
+	}
+}
+
+// WithPanicPolicy sets how the client reacts when a plugin function panics.
+// Defaults to PanicPolicyReport.
+func WithPanicPolicy(policy PanicPolicy) ClientOption {
+	return func(p *Client) {
+		p.panicPolicy = policy
+	}
+}
+
+// WithProtocol requests the protocol used to call the plugin functions during
+// the handshake. Plugins that don't support protocol negotiation are called
+// with ProtocolREST. Defaults to ProtocolREST.
+func WithProtocol(protocol Protocol) ClientOption {
+	return func(p *Client) {
+		p.protocol = protocol
+	}
+}
+
+// WithCodec sets the codec used to encode function inputs and outputs,
+// e.g. MessagePackCodec for large numeric arrays or binary data.
+// Defaults to JSONCodec.
+func WithCodec(codec Codec) ClientOption {
+	return func(p *Client) {
+		p.codec = codec
+	}
+}
+
+// WithStdio asks the plugin to serve requests over its stdin and stdout
+// instead of a TCP socket. Plugins that don't support the stdio transport
+// are reached over TCP.
+func WithStdio() ClientOption {
+	return func(p *Client) {
+		p.stdio = true
+	}
+}
+
 // New creates a new Client instance with the specified plugin path and optional configuration.
 // The path should point to an executable file that implements the plugin protocol.
 // Options can be provided to customize timeouts and other behavior.
 func New(path string, opts ...ClientOption) *Client {
 	p := &Client{
 		path:                     path,
+		name:                     filepath.Base(path),
 		functionExecutionTimeout: DefaultFunctionExecutionTimeout,
 		healthCheckTimeout:       DefaultHealthCheckTimeout,
 		healthCheckInterval:      DefaultHealthCheckInterval,
 		heartbeatInterval:        0,
+		stdioTransport:           &stdioTransport{},
 	}
 
 	for _, opt := range opts {
@@ -117,16 +273,51 @@ func New(path string, opts ...ClientOption) *Client {
 // It performs the following steps:
 // 1. Validates that the plugin file exists and is executable
 // 2. Starts the plugin process
-// 3. Reads the HTTP port from the plugin's stdout
+// 3. Reads the HTTP port, or "stdio" for the stdio transport, from the plugin's stdout
 // 4. Establishes HTTP connection and waits for the plugin to become healthy
 //
 // Returns an error if any step fails. The plugin process will be terminated
-// automatically if initialization fails.
+// automatically if initialization fails. When a replayer is configured no
+// process is launched and requests are served from its cassette.
 func (c *Client) Open(ctx context.Context) error {
-	if c.commandContext != nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.open(ctx)
+}
+
+// open implements Open. The caller must hold c.mu.
+func (c *Client) open(ctx context.Context) error {
+	if c.commandContext != nil || c.connection != nil {
 		return errors.New("plugin is already running")
 	}
 
+	if c.opened && c.metrics != nil {
+		c.metrics.IncCounter(MetricClientRestarts, Labels{"plugin": c.name}, 1)
+	}
+	c.opened = true
+	c.ctx = ctx
+
+	if c.replayer != nil {
+		c.connection = c.replayer.Connection()
+		c.connection.FunctionExecutionTimeout = c.functionExecutionTimeout
+		if c.protocol != "" {
+			c.connection.Protocol = c.protocol
+		}
+		c.connection.Plugin = c.name
+		c.connection.Codec = c.codec
+		c.connection.Tracer = c.tracer
+		c.connection.Propagator = c.propagator
+		c.connection.Metrics = c.metrics
+		c.connection.RetryPolicy = c.retryPolicy
+		c.connection.CircuitBreaker = c.circuitBreaker
+		c.connection.MaxResponseSize = c.maxResponseSize
+		c.connection.ValidateOutput = c.validateOutput
+		c.connection.onPanic = c.handlePanic
+		c.httpClient = &http.Client{Timeout: c.functionExecutionTimeout, Transport: c.replayer}
+		return nil
+	}
+
 	fileInfo, err := os.Stat(c.path)
 	if err != nil || fileInfo.IsDir() {
 		return &PluginNotFoundError{Err: err}
@@ -142,11 +333,19 @@ func (c *Client) Open(ctx context.Context) error {
 	c.cancel = cancel
 
 	commandContext := exec.CommandContext(cancelCtx, c.path)
+	commandContext.Env = os.Environ()
+	if c.protocol != "" {
+		commandContext.Env = append(commandContext.Env, protocolEnv+"="+string(c.protocol))
+	}
+	if c.stdio {
+		commandContext.Env = append(commandContext.Env, transportEnv+"="+stdioHandshake)
+	}
+	stdin, _ := commandContext.StdinPipe()
 	stdout, _ := commandContext.StdoutPipe()
 	commandContext.Stderr = os.Stderr
 
 	if err := commandContext.Start(); err != nil {
-		_ = c.Close()
+		_ = c.close()
 		return &PluginExecutionError{Err: err}
 	}
 	c.commandContext = commandContext
@@ -155,55 +354,157 @@ func (c *Client) Open(ctx context.Context) error {
 	reader := bufio.NewReader(stdout)
 	line, err := reader.ReadString('\n')
 	if err != nil {
-		_ = c.Close()
+		_ = c.close()
 		return &PluginExecutionError{Err: err}
 	}
 
-	pluginPort := strings.TrimSpace(line)
-	_, err = strconv.Atoi(pluginPort)
-	if err != nil {
-		_ = c.Close()
-		return &PluginExecutionError{Err: fmt.Errorf("invalid port received from plugin: %s", pluginPort)}
+	pluginPort, protocol := parseHandshake(line)
+
+	var (
+		baseURL   string
+		transport http.RoundTripper
+	)
+
+	if pluginPort == stdioHandshake {
+		c.stdioTransport.attach(stdin, reader)
+		baseURL = stdioBaseURL
+		transport = c.stdioTransport
+		if c.recorder != nil {
+			transport = recordingTransport{recorder: c.recorder, next: c.stdioTransport}
+		}
+	} else {
+		_, err = strconv.Atoi(pluginPort)
+		if err != nil {
+			_ = c.close()
+			return &PluginExecutionError{Err: fmt.Errorf("invalid port received from plugin: %s", pluginPort)}
+		}
+
+		// Plugins served over TCP read nothing from stdin
+		_ = stdin.Close()
+
+		baseURL = fmt.Sprintf("%s%s:%s", defaultSchema, defaultHost, pluginPort)
+		if c.recorder != nil {
+			transport = c.recorder
+		}
 	}
 
 	c.connection = &Connection{
-		BaseURL: fmt.Sprintf("%s%s:%s", defaultSchema, defaultHost, pluginPort),
+		FunctionExecutionTimeout: c.functionExecutionTimeout,
+		BaseURL:                  baseURL,
+		Plugin:                   c.name,
+		Protocol:                 protocol,
+		Codec:                    c.codec,
+		Transport:                transport,
+		Tracer:                   c.tracer,
+		Propagator:               c.propagator,
+		Metrics:                  c.metrics,
+		RetryPolicy:              c.retryPolicy,
+		CircuitBreaker:           c.circuitBreaker,
+		MaxResponseSize:          c.maxResponseSize,
+		ValidateOutput:           c.validateOutput,
+		onPanic:                  c.handlePanic,
 	}
 
-	c.httpClient = &http.Client{Timeout: c.functionExecutionTimeout}
-	if err := c.waitForHealth(); err != nil {
-		_ = c.Close()
+	c.httpClient = &http.Client{Timeout: c.functionExecutionTimeout, Transport: transport}
+	if err := c.waitForHealth(c.httpClient, c.connection.BaseURL); err != nil {
+		_ = c.close()
 		return &PluginExecutionError{Err: err}
 	}
 
 	if c.heartbeatInterval > 0 {
 		c.heartbeatChan = make(chan struct{})
-		go func() {
-			ticker := time.NewTicker(c.heartbeatInterval)
-			defer ticker.Stop()
-
-			for range ticker.C {
-				err := c.waitForHealth()
-				if err != nil {
-					_ = c.Close()
-					return
-				}
-			}
-		}()
+		go c.heartbeat(c.heartbeatChan, c.httpClient, c.connection.BaseURL)
 	}
 
 	return nil
 }
 
+// heartbeat periodically checks the plugin's health until done is closed,
+// closing the client as soon as the plugin becomes unhealthy.
+func (c *Client) heartbeat(done chan struct{}, httpClient *http.Client, baseURL string) {
+	ticker := time.NewTicker(c.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.waitForHealth(httpClient, baseURL); err != nil {
+				c.mu.Lock()
+				if c.heartbeatChan == done {
+					_ = c.close()
+				}
+				c.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
 // Done returns a channel that signals the health status of the plugin.
+// The channel is closed when the plugin is closed, becomes unhealthy or is restarted.
 func (c *Client) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.heartbeatChan
 }
 
+// Restart closes the plugin process and launches it again. Connections
+// previously returned by Connection, and the functions created from them,
+// keep working with the new process.
+func (c *Client) Restart(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	connection := c.connection
+	_ = c.close()
+
+	if err := c.open(ctx); err != nil {
+		return err
+	}
+
+	if connection != nil {
+		connection.setBaseURL(c.connection.BaseURL)
+		c.connection = connection
+	}
+
+	return nil
+}
+
+// handlePanic applies the panic policy after a plugin function panicked.
+// Concurrent panics trigger a single restart.
+func (c *Client) handlePanic() {
+	if c.panicPolicy != PanicPolicyRestart || !c.restarting.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer c.restarting.Store(false)
+
+		c.mu.Lock()
+		ctx := c.ctx
+		c.mu.Unlock()
+
+		if err := c.Restart(ctx); err != nil {
+			slog.Error("failed to restart plugin", "plugin", c.name, "error", err)
+		}
+	}()
+}
+
 // Close gracefully shuts down the plugin process and cleans up resources.
 // It cancels the plugin's context and kills the process if it's still running.
 // This method is safe to call multiple times.
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.close()
+}
+
+// close implements Close. The caller must hold c.mu.
+func (c *Client) close() error {
 	defer func() {
 		c.commandContext = nil
 		c.cancel = nil
@@ -219,6 +520,8 @@ func (c *Client) Close() error {
 		c.cancel()
 	}
 
+	c.stdioTransport.detach()
+
 	if c.commandContext != nil && c.commandContext.Process != nil {
 		return c.commandContext.Process.Kill()
 	}
@@ -226,9 +529,17 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// Name returns the name identifying the plugin.
+func (c *Client) Name() string {
+	return c.name
+}
+
 // Connection returns the current HTTP connection details for the plugin.
 // Returns nil if the plugin is not currently running or connected.
 func (c *Client) Connection() *Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.connection
 }
 
@@ -236,11 +547,16 @@ func (c *Client) Connection() *Connection {
 // from the plugin. This provides introspection capabilities to understand what
 // functions are available and their expected data structures.
 func (c *Client) Schemas() (Schemas, error) {
-	if c.connection == nil {
+	c.mu.Lock()
+	connection, httpClient := c.connection, c.httpClient
+	c.mu.Unlock()
+
+	if connection == nil {
 		return nil, errors.New("plugin is not connected")
 	}
 
-	resp, err := c.httpClient.Get(c.connection.BaseURL + schemasPath)
+	httpClient = &http.Client{Timeout: httpClient.Timeout, Transport: connection.transport()}
+	resp, err := httpClient.Get(connection.baseURL() + schemasPath)
 	if err != nil {
 		return nil, &PluginExecutionError{Err: err}
 	}
@@ -261,17 +577,47 @@ func (c *Client) Schemas() (Schemas, error) {
 	return schemas, nil
 }
 
+// Invoke calls a function by name with raw JSON input and returns its raw JSON
+// output. It is the dynamic counterpart of Function.CallContext, for callers
+// that only know functions from their schemas.
+func (c *Client) Invoke(ctx context.Context, function string, input json.RawMessage) (json.RawMessage, error) {
+	connection := c.Connection()
+	if connection == nil {
+		return nil, errors.New("plugin is not connected")
+	}
+
+	fn, err := NewFunction[json.RawMessage, json.RawMessage](function, connection)
+	if err != nil {
+		return nil, err
+	}
+	fn.SetCodec(JSONCodec)
+
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+
+	output, err := fn.CallContext(ctx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	return *output, nil
+}
+
 // waitForHealth repeatedly checks the plugin's health endpoint until it responds
 // successfully or the health check timeout is reached. This ensures the plugin
 // is fully initialized before allowing function calls.
-func (c *Client) waitForHealth() error {
+func (c *Client) waitForHealth(httpClient *http.Client, baseURL string) error {
 	deadline := time.Now().Add(c.healthCheckTimeout)
 
 	for {
 		if time.Now().After(deadline) {
+			if c.metrics != nil {
+				c.metrics.IncCounter(MetricClientHealthCheckFailures, Labels{"plugin": c.name}, 1)
+			}
 			return errors.New("timeout waiting for plugin to become healthy")
 		}
-		resp, err := c.httpClient.Get(c.connection.BaseURL + healthPath)
+		resp, err := httpClient.Get(baseURL + healthPath)
 		if err == nil && resp.StatusCode == http.StatusOK {
 			_ = resp.Body.Close()
 			return nil
@@ -0,0 +1,187 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pluginEnv makes the test binary serve newProcessPlugin instead of running
+// the tests, so that clients can launch it as a real plugin.
+const pluginEnv = "PLUGGO_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		if err := newProcessPlugin().Start(); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type pidOutput struct {
+	PID int `json:"pid"`
+}
+
+// longFunctionName is the name of a function of newProcessPlugin longer
+// than the tool name limit.
+var longFunctionName = strings.Repeat("nested.", 12) + "greet"
+
+type sleepInput struct {
+	Duration string `json:"duration"`
+}
+
+// newProcessPlugin creates the plugin served by the test binary: pid returns
+// the process id of the plugin, crash panics, greet is also served with
+// longFunctionName, sleep waits for a duration and register adds a greet
+// function with the given name.
+func newProcessPlugin() *Plugin {
+	p := NewPlugin(WithLogger(slog.New(slog.DiscardHandler)))
+	_ = p.AddFunction("sleep", NewFunctionHandler(func(ctx context.Context, in *sleepInput) (*struct{}, error) {
+		duration, err := time.ParseDuration(in.Duration)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(duration):
+			return &struct{}{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}, nil).Handler())
+	_ = p.AddFunction("register", NewFunctionHandler(func(_ context.Context, in *greetInput) (*struct{}, error) {
+		return &struct{}{}, p.AddFunction(in.Name, NewFunctionHandler(greet, nil).Handler())
+	}, nil).Handler())
+	validator, _ := NewValidator(&greetInput{})
+	_ = p.AddFunction("greet", NewFunctionHandler(greet, validator).Handler(), WithDescription("Greets someone."))
+	_ = p.AddFunction(longFunctionName, NewFunctionHandler(greet, nil).Handler())
+	_ = p.AddFunction("pid", NewFunctionHandler(func(context.Context, *struct{}) (*pidOutput, error) {
+		return &pidOutput{PID: os.Getpid()}, nil
+	}, nil).Handler())
+	_ = p.AddFunction("crash", NewFunctionHandler(func(context.Context, *struct{}) (*struct{}, error) {
+		panic("crashed")
+	}, nil).Handler())
+	return p
+}
+
+// openProcessPlugin launches the test binary as a plugin until the end of the test.
+func openProcessPlugin(t *testing.T, opts ...ClientOption) *Client {
+	t.Helper()
+	t.Setenv(pluginEnv, "1")
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := New(executable, opts...)
+	if err := client.Open(context.Background()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return client
+}
+
+// crashing returns a greet function panicking with "crashed" on empty names.
+func crashing(opts ...HandlerOption) *Handler {
+	return NewFunctionHandler(func(_ context.Context, in *greetInput) (*greetOutput, error) {
+		if in.Name == "" {
+			panic("crashed")
+		}
+		return greet(context.Background(), in)
+	}, nil, opts...).Handler()
+}
+
+func TestPanicCrashReport(t *testing.T) {
+	p := newTestPlugin(t)
+	if err := p.AddFunction("crash", crashing()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddFunction("crash.traced", crashing(WithStackTraces())); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			connection := serve(t, p, protocol)
+
+			for _, tt := range []struct {
+				function  string
+				wantStack bool
+			}{
+				{function: "crash"},
+				{function: "crash.traced", wantStack: true},
+			} {
+				f := newTestFunction[greetInput, greetOutput](t, tt.function, connection)
+
+				_, err := f.Call(&greetInput{})
+				var panicErr *PluginPanicError
+				if !errors.As(err, &panicErr) || panicErr.Function != tt.function || panicErr.Value != "crashed" {
+					t.Fatalf("%s error = %v, want a PluginPanicError", tt.function, err)
+				}
+				if hasStack := strings.Contains(panicErr.Stack, "goroutine"); hasStack != tt.wantStack {
+					t.Errorf("%s stack = %q, want a stack trace: %t", tt.function, panicErr.Stack, tt.wantStack)
+				}
+
+				if out, err := f.Call(&greetInput{Name: "ada"}); err != nil || out.Greeting != "hello ada" {
+					t.Errorf("%s call after the panic = %v, %v", tt.function, out, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPanicPolicyRestart(t *testing.T) {
+	client := openProcessPlugin(t, WithPanicPolicy(PanicPolicyRestart))
+	pid := newTestFunction[struct{}, pidOutput](t, "pid", client.Connection())
+
+	before, err := pid.Call(&struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newTestFunction[struct{}, struct{}](t, "crash", client.Connection()).Call(&struct{}{})
+	var panicErr *PluginPanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "crashed" {
+		t.Fatalf("error = %v, want a PluginPanicError", err)
+	}
+
+	// The restart happens in the background: calls made meanwhile may fail
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		after, err := pid.Call(&struct{}{})
+		if err == nil && after.PID != before.PID {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("plugin was not restarted after the panic: pid %d, last call %v, %v", before.PID, after, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestPanicPolicyReport(t *testing.T) {
+	client := openProcessPlugin(t)
+	pid := newTestFunction[struct{}, pidOutput](t, "pid", client.Connection())
+
+	before, err := pid.Call(&struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestFunction[struct{}, struct{}](t, "crash", client.Connection()).Call(&struct{}{}); err == nil {
+		t.Fatal("panicking function returned no error")
+	}
+
+	after, err := pid.Call(&struct{}{})
+	if err != nil || after.PID != before.PID {
+		t.Errorf("call after the panic = %v, %v, want the same process %d", after, err, before.PID)
+	}
+}
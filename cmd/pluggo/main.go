@@ -0,0 +1,112 @@
+// Command pluggo provides command line tools for pluggo plugins.
+//
+// Usage:
+//
+//	pluggo mcp [flags] plugin [plugin...]
+//
+// The mcp command launches the given plugins and serves their functions as
+// Model Context Protocol tools over stdio.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/henomis/pluggo"
+	"github.com/henomis/pluggo/mcp"
+)
+
+const usage = `usage: pluggo <command> [flags] [arguments]
+
+commands:
+  mcp    serve plugin functions as MCP tools over stdio
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "mcp":
+		err = runMCP(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pluggo: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runMCP launches the plugins and serves their functions over stdio until
+// stdin is closed or the process is interrupted.
+func runMCP(args []string) error {
+	flags := flag.NewFlagSet("mcp", flag.ExitOnError)
+	prefix := flags.Bool("prefix", false, "prefix tool names with the plugin name, e.g. reverse_run")
+	timeout := flags.Duration("timeout", pluggo.DefaultFunctionExecutionTimeout, "function execution timeout")
+	restart := flags.Bool("restart", false, "restart a plugin after one of its functions panics")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: pluggo mcp [flags] plugin [plugin...]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	options := []pluggo.ClientOption{pluggo.WithFunctionExecutionTimeout(*timeout)}
+	if *restart {
+		options = append(options, pluggo.WithPanicPolicy(pluggo.PanicPolicyRestart))
+	}
+
+	dispatcher := pluggo.NewToolDispatcher()
+	for _, path := range flags.Args() {
+		client := pluggo.New(path, options...)
+		if err := client.Open(ctx); err != nil {
+			return fmt.Errorf("cannot open plugin %s: %w", path, err)
+		}
+		defer func() { _ = client.Close() }()
+
+		var toolOptions []pluggo.ToolOption
+		if *prefix {
+			toolOptions = append(toolOptions, pluggo.WithToolPrefix(client.Name()+"_"))
+		}
+
+		if err := dispatcher.AddClient(client, toolOptions...); err != nil {
+			return fmt.Errorf("cannot add plugin %s: %w", path, err)
+		}
+	}
+
+	server := mcp.NewServer(dispatcher, mcp.WithServerInfo("pluggo", version()))
+	if err := server.Serve(ctx, os.Stdin, os.Stdout); err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	return nil
+}
+
+// version returns the module version pluggo was built from.
+func version() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "dev"
+}
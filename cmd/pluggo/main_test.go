@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/henomis/pluggo"
+)
+
+// modeEnv makes the test binary run as the pluggo command ("pluggo") or as
+// the plugin it launches ("plugin") instead of running the tests.
+const modeEnv = "PLUGGO_CMD_TEST_MODE"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(modeEnv) {
+	case "plugin":
+		if err := newTestPlugin().Start(); err != nil {
+			os.Exit(1)
+		}
+		return
+	case "pluggo":
+		// The plugins launched by the command run as the test plugin
+		_ = os.Setenv(modeEnv, "plugin")
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type reverseInput struct {
+	Text string `json:"text"`
+}
+
+type reverseOutput struct {
+	Text string `json:"text"`
+}
+
+// newTestPlugin creates a plugin serving run, which reverses its input.
+func newTestPlugin() *pluggo.Plugin {
+	run := func(_ context.Context, in *reverseInput) (*reverseOutput, error) {
+		runes := []rune(in.Text)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return &reverseOutput{Text: string(runes)}, nil
+	}
+
+	p := pluggo.NewPlugin(pluggo.WithLogger(slog.New(slog.DiscardHandler)))
+	_ = p.AddFunction("run", pluggo.NewFunctionHandler(run, nil).Handler())
+	return p
+}
+
+// command runs the test binary as the pluggo command with args.
+func command(t *testing.T, args ...string) *exec.Cmd {
+	t.Helper()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), modeEnv+"=pluggo")
+	return cmd
+}
+
+func TestMCPCommand(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := command(t, "mcp", "-prefix", executable)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	responses := bufio.NewScanner(stdout)
+	call := func(id int, method, params string) string {
+		t.Helper()
+
+		if _, err := fmt.Fprintf(stdin, `{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`+"\n", id, method, params); err != nil {
+			t.Fatal(err)
+		}
+		if !responses.Scan() {
+			t.Fatalf("no response to %s: %v", method, responses.Err())
+		}
+		return responses.Text()
+	}
+
+	tool := strings.ReplaceAll(pluggo.New(executable).Name(), ".", "_") + "_run"
+
+	var list struct {
+		Result struct {
+			Tools []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(call(1, "tools/list", "{}")), &list); err != nil {
+		t.Fatal(err)
+	}
+	if tools := list.Result.Tools; len(tools) != 1 || tools[0].Name != tool {
+		t.Fatalf("tools = %+v, want the prefixed run tool %s", tools, tool)
+	}
+
+	response := call(2, "tools/call", fmt.Sprintf(`{"name":%q,"arguments":{"text":"pluggo"}}`, tool))
+	if !strings.Contains(response, `"structuredContent":{"text":"oggulp"}`) {
+		t.Errorf("tool call = %s, want the reversed text", response)
+	}
+
+	// Closing stdin ends the session and the command
+	_ = stdin.Close()
+	if _, err := io.ReadAll(stdout); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("pluggo mcp exited with %v", err)
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	out, err := command(t, "help").Output()
+	if err != nil || !strings.Contains(string(out), "mcp    serve plugin functions") {
+		t.Errorf("pluggo help = %q, %v", out, err)
+	}
+
+	for _, args := range [][]string{{}, {"unknown"}, {"mcp"}} {
+		var exitErr *exec.ExitError
+		if err := command(t, args...).Run(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+			t.Errorf("pluggo %q = %v, want exit status 2", args, err)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package pluggo
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Content types of the built-in codecs.
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeMessagePack = "application/msgpack"
+	ContentTypeCBOR        = "application/cbor"
+)
+
+// Codec serializes function inputs and outputs. Requests carry the content
+// type of their codec in the Content-Type header, and ask for the codec of
+// the response with the Accept header. Requests whose Content-Type names no
+// codec of the function are decoded as JSON.
+type Codec interface {
+	// ContentType returns the media type identifying the codec, e.g. "application/json".
+	ContentType() string
+	// Marshal encodes v.
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSONCodec encodes payloads as JSON. It is the default codec.
+	JSONCodec Codec = jsonCodec{}
+	// MessagePackCodec encodes payloads as MessagePack. Struct fields are
+	// named after their json tags, and []byte fields are sent as binary.
+	MessagePackCodec Codec = msgpackCodec{}
+	// CBORCodec encodes payloads as CBOR (RFC 8949). Struct fields are named
+	// after their cbor or json tags, and []byte fields are sent as binary.
+	CBORCodec Codec = newCBORCodec()
+)
+
+// defaultCodecs are the codecs every function handler accepts.
+var defaultCodecs = []Codec{JSONCodec, MessagePackCodec, CBORCodec}
+
+// jsonCodec implements Codec with encoding/json.
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) unmarshalStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// msgpackCodec implements Codec with MessagePack.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return ContentTypeMessagePack }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (msgpackCodec) unmarshalStrict(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(v)
+}
+
+// cborCodec implements Codec with CBOR.
+type cborCodec struct {
+	enc    cbor.EncMode
+	dec    cbor.DecMode
+	strict cbor.DecMode
+}
+
+// newCBORCodec creates a CBOR codec decoding maps to map[string]any, as JSON does.
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	options := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any(nil))}
+	dec, err := options.DecMode()
+	if err != nil {
+		panic(err)
+	}
+
+	options.ExtraReturnErrors = cbor.ExtraDecErrorUnknownField
+	strict, err := options.DecMode()
+	if err != nil {
+		panic(err)
+	}
+
+	return cborCodec{enc: enc, dec: dec, strict: strict}
+}
+
+func (cborCodec) ContentType() string { return ContentTypeCBOR }
+
+func (c cborCodec) Marshal(v any) ([]byte, error) { return c.enc.Marshal(v) }
+
+func (c cborCodec) Unmarshal(data []byte, v any) error { return c.dec.Unmarshal(data, v) }
+
+func (c cborCodec) unmarshalStrict(data []byte, v any) error { return c.strict.Unmarshal(data, v) }
+
+// codecFor returns the codec of codecs matching the media type of a
+// Content-Type header, or nil. An empty header selects JSON.
+func codecFor(codecs []Codec, contentType string) Codec {
+	if contentType == "" {
+		return JSONCodec
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	for _, codec := range codecs {
+		if codec.ContentType() == mediaType {
+			return codec
+		}
+	}
+	return nil
+}
+
+// inputCodec returns the codec decoding a request with a Content-Type header.
+// As before codecs were introduced, the header is ignored unless it names one
+// of codecs: missing, malformed and other content types are decoded as JSON.
+func inputCodec(codecs []Codec, contentType string) Codec {
+	if codec := codecFor(codecs, contentType); codec != nil {
+		return codec
+	}
+	return JSONCodec
+}
+
+// strictCodec is implemented by the built-in codecs, to decode function
+// inputs rejecting the fields unknown to the input type.
+type strictCodec interface {
+	unmarshalStrict(data []byte, v any) error
+}
+
+// unmarshalInput decodes the input of a function with codec, rejecting
+// unknown fields when the codec supports it.
+func unmarshalInput(codec Codec, data []byte, v any) error {
+	if strict, ok := codec.(strictCodec); ok {
+		return strict.unmarshalStrict(data, v)
+	}
+	return codec.Unmarshal(data, v)
+}
+
+// negotiateCodec returns the first codec of codecs accepted by an Accept
+// header, or fallback when the header is missing or accepts anything.
+func negotiateCodec(codecs []Codec, accept string, fallback Codec) Codec {
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return fallback
+		}
+		if codec := codecFor(codecs, mediaType); codec != nil {
+			return codec
+		}
+	}
+	return fallback
+}
+
+// validationJSON converts a payload decoded by codec to JSON, so that it can
+// be checked by a Validator. Binary values become base64 strings, as
+// encoding/json would produce for []byte fields.
+func validationJSON(codec Codec, data []byte) ([]byte, error) {
+	var value any
+	if err := codec.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonCompatible(value))
+}
+
+// jsonCompatible converts the maps with non-string keys produced by some
+// decoders to map[string]any.
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = jsonCompatible(value)
+		}
+		return v
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			out[toString(key)] = jsonCompatible(value)
+		}
+		return out
+	case []any:
+		for i, value := range v {
+			v[i] = jsonCompatible(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// toString formats a map key as a JSON object key.
+func toString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
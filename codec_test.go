@@ -0,0 +1,142 @@
+package pluggo
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	connection := serve(t, newTestPlugin(t), ProtocolREST)
+
+	for _, codec := range defaultCodecs {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			f := newTestFunction[greetInput, greetOutput](t, "greet", connection)
+			f.SetCodec(codec)
+
+			out, err := f.Call(&greetInput{Name: "ada"})
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if out.Greeting != "hello ada" {
+				t.Errorf("greeting = %q, want %q", out.Greeting, "hello ada")
+			}
+		})
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	connection := serve(t, newTestPlugin(t), ProtocolREST)
+	input, err := MessagePackCodec.Marshal(&greetInput{Name: "ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		accept string
+		want   Codec
+	}{
+		{name: "no accept", want: MessagePackCodec},
+		{name: "any", accept: "*/*", want: MessagePackCodec},
+		{name: "cbor", accept: "application/cbor", want: CBORCodec},
+		{name: "first known", accept: "text/html, application/json;q=0.9", want: JSONCodec},
+		{name: "unknown", accept: "text/html", want: MessagePackCodec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, connection.BaseURL+"/greet", bytes.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", ContentTypeMessagePack)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			if got := resp.Header.Get("Content-Type"); got != tt.want.ContentType() {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.want.ContentType())
+			}
+			body, _ := io.ReadAll(resp.Body)
+			var out greetOutput
+			if err := tt.want.Unmarshal(body, &out); err != nil || out.Greeting != "hello ada" {
+				t.Errorf("output = %+v, %v", out, err)
+			}
+		})
+	}
+}
+
+func TestInputContentTypes(t *testing.T) {
+	connection := serve(t, newTestPlugin(t), ProtocolREST)
+
+	tests := []struct {
+		contentType string
+		want        int
+	}{
+		{contentType: "", want: http.StatusOK},
+		{contentType: "application/json; charset=utf-8", want: http.StatusOK},
+		{contentType: "text/plain", want: http.StatusOK},
+		{contentType: "application/octet-stream", want: http.StatusOK},
+		{contentType: "application/vnd.api+json", want: http.StatusOK},
+		{contentType: "not a media type;;", want: http.StatusOK},
+		{contentType: "application/xml", want: http.StatusOK},
+		{contentType: "application/x-msgpack", want: http.StatusOK},
+		{contentType: "application/x-www-form-urlencoded", want: http.StatusOK},
+		{contentType: "application/atom+xml", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, connection.BaseURL+"/greet", bytes.NewReader([]byte(`{"name":"ada"}`)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.want, body)
+			}
+			if tt.want == http.StatusOK && !jsonEqual(body, `{"greeting":"hello ada"}`) {
+				t.Errorf("body = %s", body)
+			}
+		})
+	}
+}
+
+func TestCodecUnknownFields(t *testing.T) {
+	for _, codec := range defaultCodecs {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			data, err := codec.Marshal(map[string]any{"name": "ada", "unknown": 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var in greetInput
+			if _, err := decodeData(data, codec, nil, &in); err == nil {
+				t.Errorf("input with an unknown field = %+v, want an error", in)
+			}
+			if err := codec.Unmarshal(data, &in); err != nil || in.Name != "ada" {
+				t.Errorf("Unmarshal = %+v, %v, want unknown fields ignored", in, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package pluggo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/kaptinlin/jsonschema"
+)
+
+const errorCodeContractViolation = "contract_violation"
+
+// WithHandlerOutputValidation validates the outputs of the function against
+// its output schema before sending them. Outputs violating the schema are not
+// sent: the call fails with a ContractViolationError on the client.
+func WithHandlerOutputValidation() HandlerOption {
+	return func(o *handlerOptions) {
+		o.validateOutput = true
+	}
+}
+
+// WithOutputValidation validates the outputs of the functions called through
+// the client against the output schemas advertised by the plugin, so that
+// plugin regressions are caught before bad data spreads. Outputs violating
+// their schema fail the call with a ContractViolationError. Outputs carrying
+// attachments are not validated.
+func WithOutputValidation() ClientOption {
+	return func(p *Client) {
+		p.validateOutput = true
+	}
+}
+
+// SetOutputValidation enables or disables the validation of the outputs of
+// this function, overriding the setting of the connection.
+func (f *Function[T, R]) SetOutputValidation(enabled bool) {
+	f.outputContract.setEnabled(enabled)
+}
+
+// outputValidator validates the outputs of a function handler.
+type outputValidator struct {
+	schema *jsonschema.Schema
+}
+
+// newOutputValidator compiles the output schema of a function handler.
+func newOutputValidator(schema map[string]any) (*outputValidator, error) {
+	compiled, err := compileJSONSchema(schema, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &outputValidator{schema: compiled}, nil
+}
+
+// validate checks a JSON output against the output schema.
+func (v *outputValidator) validate(data []byte) error {
+	result := v.schema.Validate(data)
+	if result.IsValid() {
+		return nil
+	}
+	return fmt.Errorf("invalid output: %s", newValidationError(schemaViolations(result, data)).Message)
+}
+
+// validateOutput checks the output of a function handler against the output schema.
+func validateOutput(validator *outputValidator, output any) error {
+	b, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	return validator.validate(b)
+}
+
+// outputContract validates the outputs received by a Function against the
+// output schema advertised by the plugin, fetched on the first call.
+type outputContract struct {
+	mu        sync.Mutex
+	enabled   bool
+	validator *outputValidator
+}
+
+// setEnabled enables or disables the validation.
+func (c *outputContract) setEnabled(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.enabled = enabled
+}
+
+// check validates a JSON output of function, fetching its schema with fetch
+// if needed. It returns a ContractViolationError if the output is invalid.
+func (c *outputContract) check(function string, fetch func() (*Schema, error), data []byte) error {
+	c.mu.Lock()
+	if !c.enabled {
+		c.mu.Unlock()
+		return nil
+	}
+	validator := c.validator
+	c.mu.Unlock()
+
+	if validator == nil {
+		schema, err := fetch()
+		if err != nil {
+			return &FunctionExecutionError{Function: function, Err: fmt.Errorf("error fetching output schema: %w", err)}
+		}
+
+		validator, err = newOutputValidator(schema.Output)
+		if err != nil {
+			return &FunctionExecutionError{Function: function, Err: err}
+		}
+
+		c.mu.Lock()
+		c.validator = validator
+		c.mu.Unlock()
+	}
+
+	if err := validator.validate(data); err != nil {
+		return &ContractViolationError{Function: function, Message: err.Error()}
+	}
+	return nil
+}
+
+// checkOutput validates an output encoded with codec when output validation
+// is enabled.
+func (f *Function[T, R]) checkOutput(codec Codec, out []byte) error {
+	data := out
+	if codec != JSONCodec {
+		var err error
+		if data, err = validationJSON(codec, out); err != nil {
+			return &FunctionExecutionError{Function: f.name, Err: err}
+		}
+	}
+	return f.outputContract.check(f.name, f.Schema, data)
+}
@@ -0,0 +1,133 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+)
+
+type contractOutput struct {
+	Greeting string `json:"greeting" jsonschema:"minLength=1"`
+}
+
+// addEcho registers a function echoing its input name as the greeting, which
+// violates the output schema for empty names.
+func addEcho(t *testing.T, p *Plugin, opts ...HandlerOption) {
+	t.Helper()
+
+	handler := NewFunctionHandler(func(_ context.Context, in *greetInput) (*contractOutput, error) {
+		return &contractOutput{Greeting: in.Name}, nil
+	}, nil, opts...).Handler()
+	if err := p.AddFunction("echo", handler); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerOutputValidation(t *testing.T) {
+	p := newTestPlugin(t)
+	addEcho(t, p, WithHandlerOutputValidation())
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			f := newTestFunction[greetInput, greetOutput](t, "echo", serve(t, p, protocol))
+
+			var violation *ContractViolationError
+			if _, err := f.Call(&greetInput{}); !errors.As(err, &violation) || violation.Function != "echo" {
+				t.Errorf("invalid output = %v, want a ContractViolationError", err)
+			}
+			if out, err := f.Call(&greetInput{Name: "ada"}); err != nil || out.Greeting != "ada" {
+				t.Errorf("valid output = %v, %v", out, err)
+			}
+		})
+	}
+}
+
+func TestClientOutputValidation(t *testing.T) {
+	p := newTestPlugin(t)
+	addEcho(t, p)
+
+	tests := []struct {
+		name     string
+		protocol Protocol
+		codec    Codec
+	}{
+		{name: "rest", protocol: ProtocolREST, codec: JSONCodec},
+		{name: "rest msgpack", protocol: ProtocolREST, codec: MessagePackCodec},
+		{name: "jsonrpc", protocol: ProtocolJSONRPC, codec: JSONCodec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFunction[greetInput, greetOutput](t, "echo", serve(t, p, tt.protocol))
+			f.SetCodec(tt.codec)
+
+			if _, err := f.Call(&greetInput{}); err != nil {
+				t.Fatalf("invalid output without validation: %v", err)
+			}
+
+			f.SetOutputValidation(true)
+			var violation *ContractViolationError
+			if _, err := f.Call(&greetInput{}); !errors.As(err, &violation) || violation.Function != "echo" {
+				t.Errorf("invalid output = %v, want a ContractViolationError", err)
+			}
+			if out, err := f.Call(&greetInput{Name: "ada"}); err != nil || out.Greeting != "ada" {
+				t.Errorf("valid output = %v, %v", out, err)
+			}
+		})
+	}
+}
+
+type uncompilableOutput struct {
+	Greeting string `json:"greeting"`
+}
+
+// JSONSchemaExtend makes the output schema invalid.
+func (uncompilableOutput) JSONSchemaExtend(schema *jsonschema.Schema) {
+	schema.Extras = map[string]any{"minProperties": "one"}
+}
+
+func TestInvalidOutputSchema(t *testing.T) {
+	handler := NewFunctionHandler(func(_ context.Context, in *greetInput) (*uncompilableOutput, error) {
+		return &uncompilableOutput{Greeting: in.Name}, nil
+	}, nil, WithHandlerOutputValidation()).Handler()
+
+	var registration *FunctionRegistrationError
+	if err := newTestPlugin(t).AddFunction("uncompilable", handler); !errors.As(err, &registration) {
+		t.Errorf("AddFunction = %v, want a FunctionRegistrationError", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.HTTPHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/uncompilable", strings.NewReader(`{"name":"ada"}`)))
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get(errorCodeHeader) != errorCodeContractViolation {
+		t.Errorf("call of the handler = %d %s, want a contract violation", rec.Code, rec.Body)
+	}
+}
+
+func TestJobOutputValidation(t *testing.T) {
+	p := newTestPlugin(t)
+	addEcho(t, p)
+	f := newTestFunction[greetInput, greetOutput](t, "echo", serve(t, p, ProtocolREST))
+	f.SetOutputValidation(true)
+
+	job, err := f.Submit(context.Background(), &greetInput{})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	var violation *ContractViolationError
+	if _, err := job.Wait(context.Background()); !errors.As(err, &violation) || violation.Function != "echo" {
+		t.Errorf("invalid job output = %v, want a ContractViolationError", err)
+	}
+
+	job, err = f.Submit(context.Background(), &greetInput{Name: "ada"})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if out, err := job.Wait(context.Background()); err != nil || out.Greeting != "ada" {
+		t.Errorf("valid job output = %v, %v", out, err)
+	}
+}
@@ -1,7 +1,22 @@
 package pluggo
 
 import (
+	"errors"
 	"fmt"
+	"time"
+)
+
+var (
+	// ErrFunctionAlreadyRegistered is returned when registering a function name that is already in use.
+	ErrFunctionAlreadyRegistered = errors.New("function already registered")
+	// ErrReservedFunctionName is returned when registering a function name reserved for internal endpoints.
+	ErrReservedFunctionName = errors.New("function name is reserved: _schemas, _healthz, _metrics, _rpc and _jobs are used by internal endpoints")
+	// ErrTooManyJobs is returned when submitting a job to a plugin that can't keep more jobs.
+	ErrTooManyJobs = errors.New("too many jobs")
+	// ErrJobPending is returned when asking for the result of a job that is still running.
+	ErrJobPending = errors.New("job still running")
+	// ErrJobCancelled is returned when asking for the result of a cancelled job.
+	ErrJobCancelled = errors.New("job cancelled")
 )
 
 // PluginNotFoundError is returned when the specified plugin file cannot be found or accessed.
@@ -65,3 +80,186 @@ type FunctionExecutionError struct {
 func (e *FunctionExecutionError) Error() string {
 	return fmt.Sprintf("error executing function %q: %v", e.Function, e.Err)
 }
+
+// Unwrap returns the underlying error.
+func (e *FunctionExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// ReplayMismatchError is returned by a Replayer when no recorded interaction matches a request.
+type ReplayMismatchError struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Error implements the error interface for ReplayMismatchError.
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("no recorded interaction matches %s %s with body %s", e.Method, e.Path, string(e.Body))
+}
+
+// PluginPanicError is returned when a plugin function panicked while handling a call.
+// Stack is only populated when the plugin is configured to report stack traces.
+type PluginPanicError struct {
+	Function string
+	Value    string
+	Stack    string
+}
+
+// Error implements the error interface for PluginPanicError.
+func (e *PluginPanicError) Error() string {
+	return fmt.Sprintf("function %q panicked: %s", e.Function, e.Value)
+}
+
+// FunctionRegistrationError is returned when a function cannot be registered with a plugin.
+type FunctionRegistrationError struct {
+	Function string
+	Err      error
+}
+
+// Error implements the error interface for FunctionRegistrationError.
+func (e *FunctionRegistrationError) Error() string {
+	return fmt.Sprintf("error registering function %q: %v", e.Function, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *FunctionRegistrationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned when a plugin rejects the input of a function call.
+// Fields lists the violations of the JSON schema or of the validation hooks
+// of the validator, when the plugin reported them.
+type ValidationError struct {
+	Function string
+	Message  string
+	Fields   []FieldError
+}
+
+// Error implements the error interface for ValidationError.
+func (e *ValidationError) Error() string {
+	if e.Function == "" {
+		return "invalid input: " + e.Message
+	}
+	return fmt.Sprintf("invalid input for function %q: %s", e.Function, e.Message)
+}
+
+// JobNotFoundError is returned when a job is unknown to the plugin, e.g.
+// because it expired or the plugin restarted.
+type JobNotFoundError struct {
+	ID string
+}
+
+// Error implements the error interface for JobNotFoundError.
+func (e *JobNotFoundError) Error() string {
+	return fmt.Sprintf("job %q not found", e.ID)
+}
+
+// RetryableError marks an error returned by a plugin function as transient:
+// clients with a RetryPolicy retry calls failing with it, whatever the policy
+// error classes. Clients receive it wrapped in a FunctionExecutionError.
+type RetryableError struct {
+	Err error
+}
+
+// Error implements the error interface for RetryableError.
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// CircuitOpenError is returned without calling the plugin when the circuit
+// breaker of a function is open. RetryAt is when the breaker lets trial calls
+// through again.
+type CircuitOpenError struct {
+	Function string
+	Breaker  string
+	RetryAt  time.Time
+}
+
+// Error implements the error interface for CircuitOpenError.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %q is open for function %q", e.Breaker, e.Function)
+}
+
+// PluginBusyError is returned when a plugin rejected a call because its
+// concurrency limit was reached. RetryAfter is the delay suggested by the
+// plugin before calling again.
+type PluginBusyError struct {
+	Function   string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface for PluginBusyError.
+func (e *PluginBusyError) Error() string {
+	return fmt.Sprintf("function %q is busy, retry after %s", e.Function, e.RetryAfter)
+}
+
+// PayloadTooLargeError is returned when a plugin rejected a call because its
+// request body exceeded the size accepted by the plugin.
+type PayloadTooLargeError struct {
+	Function string
+	Message  string
+}
+
+// Error implements the error interface for PayloadTooLargeError.
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("payload too large for function %q: %s", e.Function, e.Message)
+}
+
+// ResponseTooLargeError is returned when the response of a plugin exceeds
+// the size accepted by the client.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+// Error implements the error interface for ResponseTooLargeError.
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds %d bytes", e.Limit)
+}
+
+// ContractViolationError is returned when the output of a function doesn't
+// match the output schema it advertises, as checked by the plugin with
+// WithHandlerOutputValidation or by the client with WithOutputValidation.
+type ContractViolationError struct {
+	Function string
+	Message  string
+}
+
+// Error implements the error interface for ContractViolationError.
+func (e *ContractViolationError) Error() string {
+	return fmt.Sprintf("function %q violated its output contract: %s", e.Function, e.Message)
+}
+
+// FunctionTimeoutError is returned when a function exceeds the execution
+// time set with TimeoutMiddleware.
+type FunctionTimeoutError struct {
+	Function string
+	Message  string
+}
+
+// Error implements the error interface for FunctionTimeoutError.
+func (e *FunctionTimeoutError) Error() string {
+	return fmt.Sprintf("function %q timed out: %s", e.Function, e.Message)
+}
+
+// FieldError is a violation of a field of an input, returned by validation
+// hooks or reported in a ValidationError.
+type FieldError struct {
+	// Field is the JSON pointer of the field, e.g. /end, or empty for the
+	// input as a whole.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface for FieldError.
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
@@ -27,7 +27,11 @@ func main() {
 		return
 	}
 
-	p.AddFunction("hello", pluggo.NewFunctionHandler(Hello, v).Handler())
+	if err := p.AddFunction("hello", pluggo.NewFunctionHandler(Hello, v).Handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "error adding function: %v\n", err)
+		return
+	}
+
 	if err := p.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "error starting plugin: %v\n", err)
 		return
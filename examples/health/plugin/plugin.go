@@ -28,7 +28,11 @@ func main() {
 		p.Stop()
 	})
 
-	p.AddFunction("hello", pluggo.NewFunctionHandler(Hello, nil).Handler())
+	if err := p.AddFunction("hello", pluggo.NewFunctionHandler(Hello, nil).Handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "error adding function: %v\n", err)
+		return
+	}
+
 	if err := p.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "error starting plugin: %v\n", err)
 		return
@@ -21,7 +21,11 @@ func exec(ctx context.Context, in *shared.Input) (*shared.Output, error) {
 func main() {
 	p := pluggo.NewPlugin()
 
-	p.AddFunction("exec", pluggo.NewFunctionHandler(exec, nil).Handler())
+	if err := p.AddFunction("exec", pluggo.NewFunctionHandler(exec, nil).Handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "error adding function: %v\n", err)
+		return
+	}
+
 	if err := p.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "error starting plugin: %v\n", err)
 		return
@@ -2,7 +2,9 @@ package pluggo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,9 +16,13 @@ import (
 // It handles JSON serialization/deserialization and HTTP communication automatically.
 type Function[T, R any] struct {
 	name             string
-	fn               func(*T) (*R, error)
+	fn               func(context.Context, *T) (*R, error)
 	httpClient       *http.Client
 	clientConnection *Connection
+	codec            Codec
+	retryPolicy      *RetryPolicy
+	circuitBreaker   *CircuitBreaker
+	outputContract   *outputContract
 }
 
 // NewFunction creates a new typed function client for calling a specific function on a plugin.
@@ -34,26 +40,94 @@ func NewFunction[T, R any](name string, clientConnection *Connection) (*Function
 	function := &Function[T, R]{
 		name:             name,
 		clientConnection: clientConnection,
-		httpClient:       &http.Client{Timeout: clientConnection.FunctionExecutionTimeout},
+		httpClient:       &http.Client{Timeout: clientConnection.FunctionExecutionTimeout, Transport: clientConnection.transport()},
+		codec:            clientConnection.Codec,
+		retryPolicy:      clientConnection.RetryPolicy,
+		circuitBreaker:   clientConnection.CircuitBreaker,
+		outputContract:   &outputContract{enabled: clientConnection.ValidateOutput},
 	}
 
-	fn := func(input *T) (*R, error) {
-		b, err := json.Marshal(input)
+	if function.codec == nil {
+		function.codec = JSONCodec
+	}
+
+	propagator := clientConnection.Propagator
+	if propagator == nil {
+		propagator = W3CPropagator{}
+	}
+
+	call := func(ctx context.Context, input *T, key string) (_ *R, errClass string, err error) {
+		ctx, span := startSpan(ctx, clientConnection.Tracer, name, SpanKindClient)
+		defer func() {
+			endSpan(span, err)
+		}()
+
+		metrics := clientConnection.Metrics
+		labels := Labels{"plugin": clientConnection.Plugin, "function": name}
+		if metrics != nil {
+			start := time.Now()
+			defer func() {
+				metrics.IncCounter(MetricClientCalls, labels, 1)
+				metrics.Observe(MetricClientCallDuration, labels, time.Since(start).Seconds())
+				if err != nil {
+					metrics.IncCounter(MetricClientErrors, Labels{"plugin": clientConnection.Plugin, "function": name, "class": errClass}, 1)
+				}
+			}()
+		}
+
+		if breaker := function.circuitBreaker; breaker != nil {
+			generation, retryAt, ok := breaker.allow()
+			if !ok {
+				return nil, ErrorClassCircuitOpen, &CircuitOpenError{Function: name, Breaker: breaker.Name(), RetryAt: retryAt}
+			}
+			defer func() {
+				if err != nil && ctx.Err() != nil {
+					breaker.release(generation)
+					return
+				}
+				breaker.record(generation, err != nil && breakerFailure(errClass))
+			}()
+		}
+
+		codec := function.codec
+		if clientConnection.Protocol == ProtocolJSONRPC {
+			codec = JSONCodec
+		}
+
+		b, err := codec.Marshal(input)
 		if err != nil {
-			return nil, &FunctionExecutionError{Function: name, Err: err}
+			return nil, ErrorClassEncode, &FunctionExecutionError{Function: name, Err: err}
 		}
 
-		url := fmt.Sprintf("%s/%s", clientConnection.BaseURL, name)
-		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+		req, err := newCallRequest(ctx, clientConnection, name, codec, b, false)
 		if err != nil {
-			return nil, &FunctionExecutionError{Function: name, Err: err}
+			return nil, ErrorClassEncode, &FunctionExecutionError{Function: name, Err: err}
+		}
+
+		if attachments := attachmentsOf(input); len(attachments) > 0 && clientConnection.Protocol != ProtocolJSONRPC {
+			setAttachmentBody(req, codec, b, attachments)
+		}
+
+		propagator.Inject(ctx, req.Header)
+		req.Header.Set(idempotencyKeyHeader, key)
+
+		callback := progressCallback(ctx)
+		if callback != nil && clientConnection.Protocol != ProtocolJSONRPC {
+			req.Header.Set(progressHeader, "1")
+		}
+
+		if span != nil {
+			span.SetAttribute("rpc.system", "pluggo")
+			span.SetAttribute("rpc.method", name)
 		}
 
-		req.Header.Set("Content-Type", "application/json")
+		if metrics != nil {
+			metrics.IncCounter(MetricClientRequestBytes, labels, float64(len(b)))
+		}
 
 		resp, err := function.httpClient.Do(req)
 		if err != nil {
-			return nil, &FunctionExecutionError{Function: name, Err: err}
+			return nil, errorClass(err), &FunctionExecutionError{Function: name, Err: err}
 		}
 
 		if resp.Body != nil {
@@ -62,28 +136,144 @@ func NewFunction[T, R any](name string, clientConnection *Connection) (*Function
 			}()
 		}
 
+		if callback != nil && resp.Header.Get("Content-Type") == progressContentType {
+			resp, err = readProgress(resp, callback)
+			if err != nil {
+				return nil, errorClass(err), &FunctionExecutionError{Function: name, Err: err}
+			}
+		}
+
+		if resp.StatusCode == http.StatusOK && isMultipart(resp.Header.Get("Content-Type")) {
+			var (
+				output   R
+				contract error
+			)
+			err = decodeAttachmentOutput(resp, append([]Codec{codec}, defaultCodecs...), func(codec Codec, out []byte) error {
+				if metrics != nil {
+					metrics.IncCounter(MetricClientResponseBytes, labels, float64(len(out)))
+				}
+				if contract = function.checkOutput(codec, out); contract != nil {
+					return contract
+				}
+				return codec.Unmarshal(out, &output)
+			}, &output)
+			if contract != nil {
+				return nil, ErrorClassContractViolation, contract
+			}
+			if err != nil {
+				return nil, ErrorClassDecode, &FunctionExecutionError{Function: name, Err: err}
+			}
+			return &output, "", nil
+		}
+
 		out, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, &FunctionExecutionError{Function: name, Err: err}
+			return nil, errorClass(err), &FunctionExecutionError{Function: name, Err: err}
+		}
+
+		if metrics != nil {
+			metrics.IncCounter(MetricClientResponseBytes, labels, float64(len(out)))
+		}
+
+		if span != nil {
+			span.SetAttribute("http.response.status_code", resp.StatusCode)
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, &FunctionExecutionError{Function: name, Err: fmt.Errorf("plugin returned status %d: %s", resp.StatusCode, string(out))}
+			err = responseError(name, resp, out)
+			errClass = statusClass(resp.StatusCode)
+		} else if clientConnection.Protocol == ProtocolJSONRPC {
+			out, errClass, err = rpcResult(name, out)
+		}
+
+		if err != nil {
+			var (
+				panicErr    *PluginPanicError
+				busyErr     *PluginBusyError
+				contractErr *ContractViolationError
+				timeoutErr  *FunctionTimeoutError
+			)
+			if errors.As(err, &panicErr) {
+				errClass = ErrorClassPanic
+				if clientConnection.onPanic != nil {
+					clientConnection.onPanic()
+				}
+			}
+			if errors.As(err, &busyErr) {
+				errClass = ErrorClassBusy
+			}
+			if errors.As(err, &contractErr) {
+				errClass = ErrorClassContractViolation
+			}
+			if errors.As(err, &timeoutErr) {
+				errClass = ErrorClassTimeout
+			}
+			return nil, errClass, err
+		}
+
+		if clientConnection.Protocol != ProtocolJSONRPC {
+			codecs := append([]Codec{codec}, defaultCodecs...)
+			if responseCodec := codecFor(codecs, resp.Header.Get("Content-Type")); responseCodec != nil {
+				codec = responseCodec
+			}
+		}
+
+		if err := function.checkOutput(codec, out); err != nil {
+			return nil, ErrorClassContractViolation, err
 		}
 
 		var output R
-		err = json.Unmarshal(out, &output)
+		err = codec.Unmarshal(out, &output)
 		if err != nil {
-			return nil, &FunctionExecutionError{Function: name, Err: err}
+			return nil, ErrorClassDecode, &FunctionExecutionError{Function: name, Err: err}
 		}
 
-		return &output, nil
+		return &output, "", nil
+	}
+
+	fn := func(ctx context.Context, input *T) (*R, error) {
+		key := idempotencyKey(ctx)
+
+		// Attachments are streamed once and can't be sent again
+		policy := function.retryPolicy
+		if len(attachmentsOf(input)) > 0 {
+			policy = nil
+		}
+
+		for attempt := 1; ; attempt++ {
+			output, errClass, err := call(ctx, input, key)
+			if err == nil || attempt >= policy.attempts() || ctx.Err() != nil || !policy.retryable(errClass, err) {
+				return output, err
+			}
+
+			if clientConnection.Metrics != nil {
+				clientConnection.Metrics.IncCounter(MetricClientRetries, Labels{"plugin": clientConnection.Plugin, "function": name, "class": errClass}, 1)
+			}
+
+			// Busy plugins tell when to call again
+			delay := policy.backoff(attempt)
+			var busyErr *PluginBusyError
+			if errors.As(err, &busyErr) {
+				delay = max(delay, busyErr.RetryAfter)
+			}
+
+			if sleep(ctx, delay) != nil {
+				return nil, err
+			}
+		}
 	}
 
 	function.fn = fn
 	return function, nil
 }
 
+// SetCodec sets the codec used to encode the input and decode the output of
+// the function, overriding the codec of the connection. The codec is ignored
+// with ProtocolJSONRPC, whose payloads are always JSON.
+func (f *Function[T, R]) SetCodec(codec Codec) {
+	f.codec = codec
+}
+
 // SetTimeout configures the HTTP timeout for this specific function.
 // This overrides the default timeout set in the connection.
 func (f *Function[T, R]) SetTimeout(timeout time.Duration) {
@@ -94,13 +284,61 @@ func (f *Function[T, R]) SetTimeout(timeout time.Duration) {
 // The input is serialized to JSON, sent to the plugin via HTTP POST,
 // and the response is deserialized back to the expected output type.
 func (f *Function[T, R]) Call(input *T) (*R, error) {
-	out, err := f.fn(input)
+	return f.CallContext(context.Background(), input)
+}
+
+// CallContext is like Call but carries ctx to the plugin: cancelling ctx aborts
+// the request, and its trace context is propagated to the plugin function.
+func (f *Function[T, R]) CallContext(ctx context.Context, input *T) (*R, error) {
+	out, err := f.fn(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
+// Notify calls the function without waiting for its output, e.g. for
+// fire-and-forget calls. With ProtocolJSONRPC it sends a JSON-RPC notification
+// and returns as soon as the plugin accepted it; with ProtocolREST it performs
+// a regular call and discards the output.
+func (f *Function[T, R]) Notify(ctx context.Context, input *T) error {
+	if f.clientConnection.Protocol != ProtocolJSONRPC {
+		_, err := f.CallContext(ctx, input)
+		return err
+	}
+
+	b, err := json.Marshal(input)
+	if err != nil {
+		return &FunctionExecutionError{Function: f.name, Err: err}
+	}
+
+	req, err := newCallRequest(ctx, f.clientConnection, f.name, JSONCodec, b, true)
+	if err != nil {
+		return &FunctionExecutionError{Function: f.name, Err: err}
+	}
+
+	propagator := f.clientConnection.Propagator
+	if propagator == nil {
+		propagator = W3CPropagator{}
+	}
+	propagator.Inject(ctx, req.Header)
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return &FunctionExecutionError{Function: f.name, Err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		out, _ := io.ReadAll(resp.Body)
+		return responseError(f.name, resp, out)
+	}
+
+	return nil
+}
+
 // Name returns the name of this function as registered with the plugin.
 func (f *Function[T, R]) Name() string {
 	return f.name
@@ -109,7 +347,7 @@ func (f *Function[T, R]) Name() string {
 // Schema retrieves the JSON schema definition for this function's input and output types.
 // This provides introspection capabilities to understand the expected data structure.
 func (f *Function[T, R]) Schema() (*Schema, error) {
-	url := fmt.Sprintf("%s/%s%s", f.clientConnection.BaseURL, f.name, schemasPath)
+	url := fmt.Sprintf("%s/%s%s", f.clientConnection.baseURL(), f.name, schemasPath)
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, &FunctionExecutionError{Function: f.Name(), Err: err}
@@ -137,3 +375,77 @@ func (f *Function[T, R]) Schema() (*Schema, error) {
 	}
 	return &schema, nil
 }
+
+// newCallRequest builds the HTTP request calling a function with the protocol
+// of the connection and input encoded with codec.
+func newCallRequest(ctx context.Context, connection *Connection, function string, codec Codec, input []byte, notify bool) (*http.Request, error) {
+	var (
+		req *http.Request
+		err error
+	)
+
+	if connection.Protocol == ProtocolJSONRPC {
+		req, err = newRPCRequest(ctx, connection.baseURL(), function, input, notify)
+	} else {
+		url := fmt.Sprintf("%s/%s", connection.baseURL(), function)
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(input))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", codec.ContentType())
+	req.Header.Set("Accept", codec.ContentType())
+	return req, nil
+}
+
+// responseError maps an unsuccessful plugin response to the matching error type,
+// using the error code sent by the plugin when available.
+func responseError(function string, resp *http.Response, body []byte) error {
+	switch resp.Header.Get(errorCodeHeader) {
+	case errorCodeNotFound:
+		return &FunctionNotFoundError{Function: function}
+	case errorCodeInvalid:
+		return decodeValidationError(function, resp.Header.Get("Content-Type"), body)
+	case errorCodePayloadTooLarge:
+		return &PayloadTooLargeError{Function: function, Message: string(body)}
+	case errorCodeContractViolation:
+		return &ContractViolationError{Function: function, Message: string(body)}
+	case errorCodeBusy:
+		return &PluginBusyError{Function: function, RetryAfter: retryAfter(resp)}
+	case errorCodeTimeout:
+		return &FunctionTimeoutError{Function: function, Message: string(body)}
+	case errorCodePanic:
+		var report crashReport
+		if err := json.Unmarshal(body, &report); err == nil {
+			return &PluginPanicError{Function: function, Value: report.Panic, Stack: report.Stack}
+		}
+	}
+
+	err := fmt.Errorf("plugin returned status %d: %s", resp.StatusCode, string(body))
+	if resp.Header.Get(retryableHeader) == "true" {
+		err = &RetryableError{Err: err}
+	}
+
+	return &FunctionExecutionError{Function: function, Err: err}
+}
+
+// decodeResponse decodes the output of a successful call from resp into
+// output, with the codec matching the response Content-Type or codec. The
+// encoded output is passed to decode, which deserializes it.
+func decodeResponse(resp *http.Response, codec Codec, decode func(codec Codec, out []byte) error, output any) error {
+	codecs := append([]Codec{codec}, defaultCodecs...)
+	if isMultipart(resp.Header.Get("Content-Type")) {
+		return decodeAttachmentOutput(resp, codecs, decode, output)
+	}
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if responseCodec := codecFor(codecs, resp.Header.Get("Content-Type")); responseCodec != nil {
+		codec = responseCodec
+	}
+	return decode(codec, out)
+}
@@ -0,0 +1,493 @@
+package pluggo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gatewayPluginsPath = "/plugins/"
+	gatewayOpenAPIPath = "/openapi.json"
+
+	// DefaultGatewayMaxBodySize is the default maximum size of a request body accepted by the gateway.
+	DefaultGatewayMaxBodySize = 1 << 20
+
+	// DefaultGatewayRefreshInterval is the default interval between two
+	// reloads of the function catalogs of the mounted plugins.
+	DefaultGatewayRefreshInterval = 30 * time.Second
+
+	// gatewayMissRefreshInterval is the minimum interval between two reloads
+	// of the catalog of a plugin triggered by calls to unknown functions.
+	gatewayMissRefreshInterval = time.Second
+)
+
+// AuthFunc authorizes a gateway request to a plugin function. Returning an
+// error rejects the request with 401 Unauthorized.
+type AuthFunc func(r *http.Request, plugin, function string) error
+
+// Gateway is an http.Handler publishing the functions of one or more plugins
+// as a REST API. Every function is mounted at POST /plugins/{plugin}/{function}
+// and an OpenAPI 3.1 document describing them is served at /openapi.json.
+//
+// Plugins can be added and removed at any time; routes are updated live.
+// The function catalog of every plugin is reloaded periodically, see
+// WithGatewayRefreshInterval, and when a call targets an unknown function.
+// Plugins are removed once their client is closed. When a client is created
+// with WithHeartbeatInterval, its plugin is also refreshed right after a
+// restart and removed as soon as it becomes unhealthy.
+type Gateway struct {
+	auth          AuthFunc
+	timeout       time.Duration
+	routeTimeouts map[string]time.Duration
+	maxBodySize   int64
+	refresh       time.Duration
+	title         string
+	version       string
+
+	mu      sync.RWMutex
+	plugins map[string]*gatewayPlugin
+}
+
+// gatewayPlugin is a plugin mounted on a gateway.
+type gatewayPlugin struct {
+	client    *Client
+	schemas   Schemas
+	refreshed time.Time
+	stop      chan struct{}
+}
+
+// GatewayOption is a function that configures a Gateway during creation.
+type GatewayOption func(*Gateway)
+
+// WithGatewayAuth sets the hook used to authorize every function call.
+func WithGatewayAuth(auth AuthFunc) GatewayOption {
+	return func(g *Gateway) {
+		g.auth = auth
+	}
+}
+
+// WithGatewayTimeout bounds the execution time of every function call.
+// Calls exceeding it fail with 504 Gateway Timeout.
+func WithGatewayTimeout(timeout time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.timeout = timeout
+	}
+}
+
+// WithRouteTimeout bounds the execution time of a single function,
+// overriding the timeout set with WithGatewayTimeout.
+func WithRouteTimeout(plugin, function string, timeout time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.routeTimeouts[plugin+"/"+function] = timeout
+	}
+}
+
+// WithGatewayMaxBodySize sets the maximum size in bytes of a request body.
+// Larger requests are rejected with 413 Request Entity Too Large.
+// Defaults to DefaultGatewayMaxBodySize.
+func WithGatewayMaxBodySize(size int64) GatewayOption {
+	return func(g *Gateway) {
+		g.maxBodySize = size
+	}
+}
+
+// WithGatewayRefreshInterval sets the interval between two reloads of the
+// function catalogs of the mounted plugins. Zero or negative values disable
+// the periodic reloads. Defaults to DefaultGatewayRefreshInterval.
+func WithGatewayRefreshInterval(interval time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.refresh = interval
+	}
+}
+
+// WithGatewayInfo sets the title and version of the OpenAPI document.
+func WithGatewayInfo(title, version string) GatewayOption {
+	return func(g *Gateway) {
+		g.title = title
+		g.version = version
+	}
+}
+
+// NewGateway creates a gateway with no plugins.
+func NewGateway(opts ...GatewayOption) *Gateway {
+	g := &Gateway{
+		routeTimeouts: make(map[string]time.Duration),
+		maxBodySize:   DefaultGatewayMaxBodySize,
+		refresh:       DefaultGatewayRefreshInterval,
+		title:         "pluggo gateway",
+		version:       "1.0.0",
+		plugins:       make(map[string]*gatewayPlugin),
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g
+}
+
+// AddPlugin mounts the functions of an open client at /plugins/{name}/, where
+// name is the client name. It fails if a plugin with the same name is already mounted.
+func (g *Gateway) AddPlugin(client *Client) error {
+	schemas, err := client.Schemas()
+	if err != nil {
+		return &FunctionListError{Err: err}
+	}
+
+	name := client.Name()
+	if err := validateFunctionName(name); err != nil {
+		return fmt.Errorf("invalid plugin name %q: %w", name, err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.plugins[name]; ok {
+		return fmt.Errorf("plugin %q is already mounted", name)
+	}
+
+	plugin := &gatewayPlugin{client: client, schemas: schemas, refreshed: time.Now(), stop: make(chan struct{})}
+	g.plugins[name] = plugin
+
+	go g.watch(name, plugin)
+
+	return nil
+}
+
+// RemovePlugin unmounts a plugin. Calls already in flight complete normally.
+func (g *Gateway) RemovePlugin(name string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	plugin, ok := g.plugins[name]
+	if !ok {
+		return fmt.Errorf("plugin %q is not mounted", name)
+	}
+
+	close(plugin.stop)
+	delete(g.plugins, name)
+
+	return nil
+}
+
+// Refresh reloads the function catalog of a mounted plugin, e.g. after
+// functions were added to or removed from it.
+func (g *Gateway) Refresh(name string) error {
+	g.mu.RLock()
+	plugin, ok := g.plugins[name]
+	g.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("plugin %q is not mounted", name)
+	}
+
+	schemas, err := plugin.client.Schemas()
+	if err != nil {
+		return &FunctionListError{Err: err}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.plugins[name] == plugin {
+		plugin.schemas = schemas
+		plugin.refreshed = time.Now()
+	}
+
+	return nil
+}
+
+// watch refreshes a plugin periodically and when it is restarted, and
+// removes it when it is closed. The restarts are only reported by clients
+// with a heartbeat: Client.Done is nil otherwise.
+func (g *Gateway) watch(name string, plugin *gatewayPlugin) {
+	var tick <-chan time.Time
+	if g.refresh > 0 {
+		ticker := time.NewTicker(g.refresh)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-plugin.stop:
+			return
+		case <-plugin.client.Done():
+		case <-tick:
+		}
+
+		if plugin.client.Connection() == nil {
+			g.mu.Lock()
+			if g.plugins[name] == plugin {
+				close(plugin.stop)
+				delete(g.plugins, name)
+			}
+			g.mu.Unlock()
+			return
+		}
+
+		_ = g.Refresh(name)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == gatewayOpenAPIPath {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(g.OpenAPI())
+		return
+	}
+
+	route, ok := strings.CutPrefix(r.URL.Path, gatewayPluginsPath)
+	pluginName, function, _ := strings.Cut(route, "/")
+	if !ok || pluginName == "" || function == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	g.serveFunction(w, r, pluginName, function)
+}
+
+// serveFunction proxies a call to a plugin function. Requests are authorized
+// before the function is looked up, so that unauthorized callers can't probe
+// the catalogs of the plugins.
+func (g *Gateway) serveFunction(w http.ResponseWriter, r *http.Request, pluginName, function string) {
+	if g.auth != nil {
+		if err := g.auth(r, pluginName, function); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	plugin, ok := g.route(pluginName, function)
+	if !ok && plugin != nil && g.refreshOnMiss(pluginName, plugin) {
+		plugin, ok = g.route(pluginName, function)
+	}
+
+	if !ok {
+		w.Header().Set(errorCodeHeader, errorCodeNotFound)
+		http.Error(w, fmt.Sprintf("function %q not found in plugin %q", function, pluginName), http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	input, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.maxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := W3CPropagator{}.Extract(r.Context(), r.Header)
+	if timeout := g.routeTimeout(pluginName, function); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	output, err := plugin.client.Invoke(ctx, function, input)
+	if err != nil {
+		writeGatewayError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(output)
+}
+
+// route returns the plugin mounted as pluginName, or nil, and whether it
+// has the function.
+func (g *Gateway) route(pluginName, function string) (*gatewayPlugin, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	plugin, ok := g.plugins[pluginName]
+	if !ok {
+		return nil, false
+	}
+	_, ok = plugin.schemas[function]
+	return plugin, ok
+}
+
+// refreshOnMiss reloads the catalog of a plugin after a call to an unknown
+// function, in case the function was added since the last reload. It reports
+// whether the catalog was reloaded: reloads are at most one per
+// gatewayMissRefreshInterval.
+func (g *Gateway) refreshOnMiss(name string, plugin *gatewayPlugin) bool {
+	g.mu.Lock()
+	if g.plugins[name] != plugin || time.Since(plugin.refreshed) < gatewayMissRefreshInterval {
+		g.mu.Unlock()
+		return false
+	}
+	plugin.refreshed = time.Now()
+	g.mu.Unlock()
+
+	return g.Refresh(name) == nil
+}
+
+// routeTimeout returns the timeout applying to a function.
+func (g *Gateway) routeTimeout(plugin, function string) time.Duration {
+	if timeout, ok := g.routeTimeouts[plugin+"/"+function]; ok {
+		return timeout
+	}
+	return g.timeout
+}
+
+// writeGatewayError maps a function call error to an HTTP response, preserving the
+// pluggo error code so that the gateway can itself be called with a Client connection.
+func writeGatewayError(ctx context.Context, w http.ResponseWriter, err error) {
+	var (
+		notFound   *FunctionNotFoundError
+		validation *ValidationError
+		panicked   *PluginPanicError
+		busy       *PluginBusyError
+		tooLarge   *PayloadTooLargeError
+		timedOut   *FunctionTimeoutError
+	)
+
+	switch {
+	case errors.As(err, &validation):
+		writeInvalid(w, slog.Default(), validation)
+	case errors.As(err, &notFound):
+		w.Header().Set(errorCodeHeader, errorCodeNotFound)
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &panicked):
+		w.Header().Set(errorCodeHeader, errorCodePanic)
+		_ = encodeOutput(w, http.StatusInternalServerError, crashReport{
+			Function: panicked.Function,
+			Panic:    panicked.Value,
+			Stack:    panicked.Stack,
+		})
+	case errors.As(err, &busy):
+		w.Header().Set(errorCodeHeader, errorCodeBusy)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(busy.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.As(err, &tooLarge):
+		w.Header().Set(errorCodeHeader, errorCodePayloadTooLarge)
+		http.Error(w, tooLarge.Message, http.StatusRequestEntityTooLarge)
+	case errors.As(err, &timedOut):
+		w.Header().Set(errorCodeHeader, errorCodeTimeout)
+		http.Error(w, timedOut.Message, http.StatusServiceUnavailable)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// OpenAPI returns an OpenAPI 3.1 document describing the functions of the mounted plugins.
+func (g *Gateway) OpenAPI() map[string]any {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	names := make([]string, 0, len(g.plugins))
+	for name := range g.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make(map[string]any)
+	tags := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		tags = append(tags, map[string]any{"name": name})
+
+		for function, schema := range g.plugins[name].schemas {
+			paths[gatewayPluginsPath+name+"/"+function] = map[string]any{
+				"post": openAPIOperation(name, function, schema),
+			}
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   g.title,
+			"version": g.version,
+		},
+		"tags":  tags,
+		"paths": paths,
+	}
+}
+
+// openAPIOperation describes a plugin function as an OpenAPI operation.
+func openAPIOperation(plugin, function string, schema Schema) map[string]any {
+	text := map[string]any{
+		"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+	}
+
+	operation := map[string]any{
+		"operationId": ToolName(plugin + "_" + function),
+		"tags":        append([]string{plugin}, schema.Tags...),
+		"requestBody": map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemaOrObject(schema.Input)},
+			},
+		},
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": "Function output",
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemaOrObject(schema.Output)},
+				},
+			},
+			"400": map[string]any{"description": "Invalid input", "content": text},
+			"401": map[string]any{"description": "Unauthorized", "content": text},
+			"404": map[string]any{"description": "Function not found", "content": text},
+			"413": map[string]any{"description": "Request body too large", "content": text},
+			"500": map[string]any{"description": "Function panicked"},
+			"502": map[string]any{"description": "Function failed", "content": text},
+			"504": map[string]any{"description": "Function timed out", "content": text},
+		},
+	}
+
+	if schema.Description != "" {
+		operation["summary"] = schema.Description
+	}
+	if schema.Deprecation != "" {
+		operation["deprecated"] = true
+		operation["description"] = "Deprecated: " + schema.Deprecation
+	}
+	if schema.Version != "" {
+		operation["x-pluggo-version"] = schema.Version
+	}
+	if len(schema.Examples) > 0 {
+		operation["x-pluggo-examples"] = schema.Examples
+	}
+
+	return operation
+}
+
+// schemaOrObject returns schema, or a generic object schema when it is missing.
+func schemaOrObject(schema map[string]any) map[string]any {
+	if schema == nil {
+		return map[string]any{"type": "object"}
+	}
+	return schema
+}
@@ -0,0 +1,215 @@
+package pluggo
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveGateway mounts the test binary plugin on a gateway served until the
+// end of the test, returning the gateway, its URL and the plugin name.
+func serveGateway(t *testing.T, opts ...GatewayOption) (*Gateway, string, string) {
+	t.Helper()
+
+	client := openProcessPlugin(t)
+	gateway := NewGateway(opts...)
+	if err := gateway.AddPlugin(client); err != nil {
+		t.Fatalf("AddPlugin: %v", err)
+	}
+
+	srv := httptest.NewServer(gateway)
+	t.Cleanup(srv.Close)
+
+	return gateway, srv.URL, client.Name()
+}
+
+// post calls url with body and returns the status and body of the response.
+func post(t *testing.T, url, body string, header http.Header) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	gateway, url, name := serveGateway(t, WithGatewayMaxBodySize(256))
+	plugin := url + "/plugins/" + name
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "call", path: "/greet", body: `{"name":"ada"}`, wantStatus: http.StatusOK, wantBody: `{"greeting":"hello ada"}`},
+		{name: "invalid input", path: "/greet", body: `{"name":""}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown function", path: "/unknown", body: `{}`, wantStatus: http.StatusNotFound},
+		{name: "panic", path: "/crash", body: `{}`, wantStatus: http.StatusInternalServerError, wantBody: "crashed"},
+		{name: "body too large", path: "/greet", body: `{"name":"` + strings.Repeat("a", 512) + `"}`, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := post(t, plugin+tt.path, tt.body, nil)
+			if status != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("POST %s = %d %s, want %d %s", tt.path, status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	if resp, err := http.Get(plugin + "/greet"); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET of a function = %v, %v, want 405", resp, err)
+	}
+
+	t.Run("live catalog", func(t *testing.T) {
+		// Functions added to the plugin are found on the first call, through
+		// the reload of its catalog
+		if status, body := post(t, plugin+"/register", `{"name":"added"}`, nil); status != http.StatusOK {
+			t.Fatalf("register = %d %s", status, body)
+		}
+		time.Sleep(gatewayMissRefreshInterval)
+		if status, body := post(t, plugin+"/added", `{"name":"ada"}`, nil); status != http.StatusOK || body != `{"greeting":"hello ada"}` {
+			t.Errorf("added function = %d %s", status, body)
+		}
+
+		if err := gateway.RemovePlugin(name); err != nil {
+			t.Fatalf("RemovePlugin: %v", err)
+		}
+		if status, _ := post(t, plugin+"/greet", `{"name":"ada"}`, nil); status != http.StatusNotFound {
+			t.Errorf("function of a removed plugin = %d, want 404", status)
+		}
+		if err := gateway.RemovePlugin(name); err == nil {
+			t.Error("removing a removed plugin succeeded")
+		}
+	})
+}
+
+func TestGatewayTimeouts(t *testing.T) {
+	client := openProcessPlugin(t)
+
+	// serve mounts the plugin on a gateway created with opts
+	serve := func(opts ...GatewayOption) string {
+		gateway := NewGateway(opts...)
+		if err := gateway.AddPlugin(client); err != nil {
+			t.Fatalf("AddPlugin: %v", err)
+		}
+		srv := httptest.NewServer(gateway)
+		t.Cleanup(srv.Close)
+		return srv.URL + "/plugins/" + client.Name()
+	}
+	bounded := serve(WithGatewayTimeout(50 * time.Millisecond))
+	routed := serve(WithGatewayTimeout(50*time.Millisecond), WithRouteTimeout(client.Name(), "sleep", time.Minute))
+
+	start := time.Now()
+	if status, _ := post(t, bounded+"/sleep", `{"duration":"1s"}`, nil); status != http.StatusGatewayTimeout {
+		t.Errorf("call past the gateway timeout = %d, want 504", status)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("call timed out after %v, want the gateway timeout", elapsed)
+	}
+
+	if status, body := post(t, routed+"/sleep", `{"duration":"100ms"}`, nil); status != http.StatusOK {
+		t.Errorf("call within the route timeout = %d %s", status, body)
+	}
+	if status, body := post(t, routed+"/greet", `{"name":"ada"}`, nil); status != http.StatusOK {
+		t.Errorf("call of another function = %d %s", status, body)
+	}
+}
+
+func TestGatewayAuth(t *testing.T) {
+	var authorized []string
+	_, url, name := serveGateway(t, WithGatewayAuth(func(r *http.Request, plugin, function string) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("invalid token")
+		}
+		authorized = append(authorized, plugin+"/"+function)
+		return nil
+	}))
+	plugin := url + "/plugins/" + name
+	token := http.Header{"Authorization": []string{"Bearer secret"}}
+
+	for _, path := range []string{"/greet", "/unknown"} {
+		if status, body := post(t, plugin+path, `{"name":"ada"}`, nil); status != http.StatusUnauthorized {
+			t.Errorf("unauthorized call of %s = %d %s, want 401", path, status, body)
+		}
+	}
+	if status, _ := post(t, url+"/plugins/unknown/greet", `{}`, nil); status != http.StatusUnauthorized {
+		t.Errorf("unauthorized call of an unknown plugin = %d, want 401", status)
+	}
+
+	if status, body := post(t, plugin+"/greet", `{"name":"ada"}`, token); status != http.StatusOK {
+		t.Errorf("authorized call = %d %s", status, body)
+	}
+	if status, _ := post(t, plugin+"/unknown", `{}`, token); status != http.StatusNotFound {
+		t.Errorf("authorized call of an unknown function = %d, want 404", status)
+	}
+	if len(authorized) != 2 || authorized[0] != name+"/greet" {
+		t.Errorf("authorized calls = %q", authorized)
+	}
+}
+
+func TestGatewayOpenAPI(t *testing.T) {
+	_, url, name := serveGateway(t, WithGatewayInfo("test gateway", "2.0.0"))
+
+	resp, err := http.Get(url + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]struct {
+			Post struct {
+				OperationID string         `json:"operationId"`
+				Summary     string         `json:"summary"`
+				Tags        []string       `json:"tags"`
+				RequestBody map[string]any `json:"requestBody"`
+			} `json:"post"`
+		} `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+
+	if document.OpenAPI != "3.1.0" || document.Info.Title != "test gateway" || document.Info.Version != "2.0.0" {
+		t.Errorf("document = %+v", document)
+	}
+	greet, ok := document.Paths["/plugins/"+name+"/greet"]
+	if !ok {
+		t.Fatalf("paths = %v, want the greet function", document.Paths)
+	}
+	if greet.Post.OperationID != ToolName(name+"_greet") || greet.Post.Summary != "Greets someone." || greet.Post.Tags[0] != name {
+		t.Errorf("greet operation = %+v", greet.Post)
+	}
+	if requestBody, _ := json.Marshal(greet.Post.RequestBody); !strings.Contains(string(requestBody), `"minLength":1`) {
+		t.Errorf("greet request body = %v, want the input schema", greet.Post.RequestBody)
+	}
+
+	if resp, err := http.Post(url+"/openapi.json", "application/json", nil); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST of the OpenAPI document = %v, %v, want 405", resp, err)
+	}
+}
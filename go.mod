@@ -3,8 +3,10 @@ module github.com/henomis/pluggo
 go 1.25
 
 require (
+	github.com/fxamacker/cbor/v2 v2.9.4
 	github.com/invopop/jsonschema v0.13.0
 	github.com/kaptinlin/jsonschema v0.4.15
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 )
 
 require (
@@ -16,7 +18,9 @@ require (
 	github.com/kaptinlin/go-i18n v0.1.7 // indirect
 	github.com/kaptinlin/messageformat-go v0.4.4 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/text v0.29.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,13 +1,15 @@
 package pluggo
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"os"
+	"reflect"
+	"runtime/debug"
 	"strings"
 
 	"github.com/invopop/jsonschema"
@@ -21,24 +23,130 @@ type FunctionHandler[T, R any] struct {
 	validator *Validator[T]
 }
 
+const (
+	errorCodeHeader   = "X-Pluggo-Error"
+	errorCodePanic    = "panic"
+	errorCodeNotFound = "not_found"
+	errorCodeInvalid  = "invalid_input"
+)
+
+// crashReport is the response body sent to the client when a function panics.
+type crashReport struct {
+	Function string `json:"function"`
+	Panic    string `json:"panic"`
+	Stack    string `json:"stack,omitempty"`
+}
+
+// handlerOptions holds the optional configuration of a FunctionHandler.
+type handlerOptions struct {
+	tracer      Tracer
+	propagator  Propagator
+	stackTraces bool
+	codecs      []Codec
+	attachments attachmentLimits
+
+	validateOutput bool
+}
+
+// HandlerOption is a function that configures a FunctionHandler during creation.
+type HandlerOption func(*handlerOptions)
+
+// WithHandlerTracer sets the tracer used to create a server span around every
+// invocation of the function.
+func WithHandlerTracer(tracer Tracer) HandlerOption {
+	return func(o *handlerOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithHandlerPropagator sets the propagator used to extract the caller's trace
+// context from incoming requests. Defaults to W3CPropagator.
+func WithHandlerPropagator(propagator Propagator) HandlerOption {
+	return func(o *handlerOptions) {
+		o.propagator = propagator
+	}
+}
+
 // Handler contains the HTTP handler and schema information for a plugin function.
 type Handler struct {
 	HTTPHandler http.Handler
 	Schema      Schema
+
+	// boundsAttachments is set when HTTPHandler bounds the attachments of
+	// requests itself, see WithAttachmentLimits.
+	boundsAttachments bool
+	// err is set when the handler can't serve its function, e.g. because its
+	// output schema doesn't compile. AddFunction rejects such handlers.
+	err error
+}
+
+// WithStackTraces includes the stack trace of the panicking goroutine in the
+// crash report returned to the client when the function panics.
+func WithStackTraces() HandlerOption {
+	return func(o *handlerOptions) {
+		o.stackTraces = true
+	}
+}
+
+// WithCodecs adds codecs accepted by the function, next to the built-in JSON,
+// MessagePack and CBOR codecs. The codec of a request is selected with its
+// Content-Type header and the codec of the response with its Accept header.
+func WithCodecs(codecs ...Codec) HandlerOption {
+	return func(o *handlerOptions) {
+		o.codecs = append(o.codecs, codecs...)
+	}
 }
 
 // NewFunctionHandler creates a new function handler that wraps a user function
 // with HTTP request/response handling, JSON processing, and optional input validation.
 // The handler automatically generates JSON schemas for input and output types.
-func NewFunctionHandler[T, R any](fn func(context.Context, *T) (*R, error), validator *Validator[T]) *FunctionHandler[T, R] {
-	inputSchema, err := structAsJSONSchema(new(T))
+// The caller's trace context is extracted from the request into the context
+// passed to fn. Panics raised by fn are recovered and reported to the client
+// as a PluginPanicError.
+func NewFunctionHandler[T, R any](fn func(context.Context, *T) (*R, error), validator *Validator[T], opts ...HandlerOption) *FunctionHandler[T, R] {
+	var inputValidator inputValidator
+	if validator != nil {
+		inputValidator = validator
+	}
+
+	call := func(ctx context.Context, input any) (any, error) {
+		return fn(ctx, input.(*T))
+	}
+
+	return &FunctionHandler[T, R]{
+		handler:   newHandler(new(T), new(R), call, inputValidator, opts),
+		validator: validator,
+	}
+}
+
+// Handler returns the underlying HTTP handler and schema information.
+// This is used internally by the plugin framework to register the function.
+func (m *FunctionHandler[T, R]) Handler() *Handler {
+	return m.handler
+}
+
+// newHandler builds the HTTP handler and schema of a user function in its
+// untyped form. input and output are pointers to zero values of the function's
+// input and output types; call receives a freshly decoded input of the same
+// type as input.
+func newHandler(input, output any, call func(context.Context, any) (any, error), validator inputValidator, opts []HandlerOption) *Handler {
+	options := handlerOptions{
+		propagator:  W3CPropagator{},
+		attachments: attachmentLimits{maxSize: DefaultMaxAttachmentSize, maxMemory: DefaultAttachmentMemory},
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	codecs := append(options.codecs, defaultCodecs...)
+
+	inputSchema, err := structAsJSONSchema(input)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error generating input schema: %v\n", err)
+		slog.Error("failed to generate input schema", "error", err)
 	}
 
-	outputSchema, err := structAsJSONSchema(new(R))
+	outputSchema, err := structAsJSONSchema(output)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error generating output schema: %v\n", err)
+		slog.Error("failed to generate output schema", "error", err)
 	}
 
 	schema := Schema{
@@ -46,50 +154,219 @@ func NewFunctionHandler[T, R any](fn func(context.Context, *T) (*R, error), vali
 		Output: outputSchema,
 	}
 
+	var outputValidator *outputValidator
+	if options.validateOutput {
+		outputValidator, err = newOutputValidator(outputSchema)
+		if err != nil {
+			err = fmt.Errorf("invalid output schema: %w", err)
+			return &Handler{
+				HTTPHandler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set(errorCodeHeader, errorCodeContractViolation)
+					w.WriteHeader(http.StatusInternalServerError)
+					_, _ = w.Write([]byte(err.Error()))
+				}),
+				Schema: schema,
+				err:    err,
+			}
+		}
+	}
+
+	inputType := reflect.TypeOf(input).Elem()
+
 	httpHandler := func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		logger, function := contextLogger(r.Context()), functionFromPath(r.URL.Path)
+
 		if r.Method != http.MethodPost {
-			fmt.Fprintf(os.Stderr, "method not allowed: %s\n", r.Method)
+			logger.Warn("method not allowed", "function", function, "method", r.Method)
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			_, _ = w.Write([]byte("method not allowed"))
 			return
 		}
 
-		req, err := decodeInput(r, validator)
+		ctx := options.propagator.Extract(r.Context(), r.Header)
+		if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+			ctx = ContextWithIdempotencyKey(ctx, key)
+		}
+		ctx, span := startSpan(ctx, options.tracer, function, SpanKindServer)
+		defer func() {
+			endSpan(span, err)
+		}()
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("panic: %v", v)
+				writePanic(w, logger, function, v, options.stackTraces)
+			}
+		}()
+
+		codec := inputCodec(codecs, inputContentType(r))
+
+		var (
+			req    = reflect.New(inputType).Interface()
+			report *NormalizationReport
+		)
+		if hasAttachments(r) {
+			var cleanup func()
+			cleanup, report, err = decodeAttachments(w, r, codec, validator, req, options.attachments)
+			defer cleanup()
+		} else {
+			report, err = decodeInput(r, codec, validator, req)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error reading request body: %v\n", err)
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte(err.Error()))
+			logger.Warn("invalid input", "function", function, "error", err)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writePayloadTooLarge(w, maxBytesErr.Limit)
+				return
+			}
+			writeInvalid(w, logger, err)
 			return
 		}
 
-		resp, err := fn(r.Context(), req)
+		if validator != nil {
+			err = validator.runHooks(ctx, req)
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				logger.Warn("invalid input", "function", function, "error", err)
+				writeInvalid(w, logger, err)
+				return
+			}
+		}
+
+		if report != nil {
+			ctx = withNormalizationReport(ctx, report)
+		}
+
+		// Other hook errors fail the call as function errors do
+		var resp any
+		if err == nil {
+			resp, err = call(ctx, req)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error executing function: %v\n", err)
+			logger.Error("function failed", "function", function, "error", err)
+			// Attachments read past their limit by the function
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writePayloadTooLarge(w, maxBytesErr.Limit)
+				return
+			}
+			var retryableErr *RetryableError
+			if errors.As(err, &retryableErr) {
+				w.Header().Set(retryableHeader, "true")
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
 
-		err = encodeOutput(w, http.StatusOK, resp)
+		if outputValidator != nil {
+			if err = validateOutput(outputValidator, resp); err != nil {
+				logger.Error("invalid output", "function", function, "error", err)
+				w.Header().Set(errorCodeHeader, errorCodeContractViolation)
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		err = writeOutput(w, negotiateCodec(codecs, r.Header.Get("Accept"), codec), resp)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error encoding response: %v\n", err)
+			logger.Error("failed to encode output", "function", function, "error", err)
 			return
 		}
 	}
 
-	return &FunctionHandler[T, R]{
-		handler: &Handler{
-			HTTPHandler: http.HandlerFunc(httpHandler),
-			Schema:      schema,
-		},
-		validator: validator,
+	return &Handler{
+		HTTPHandler:       http.HandlerFunc(httpHandler),
+		Schema:            schema,
+		boundsAttachments: true,
 	}
 }
 
-// Handler returns the underlying HTTP handler and schema information.
-// This is used internally by the plugin framework to register the function.
-func (m *FunctionHandler[T, R]) Handler() *Handler {
-	return m.handler
+// recoveredPanic is a panic recovered on another goroutine, with the stack
+// trace of that goroutine, re-raised by the middleware waiting for it.
+type recoveredPanic struct {
+	value any
+	stack []byte
+}
+
+// writePanic logs a recovered panic with its stack trace and sends a crash
+// report to the client. The stack trace is only sent when stack is true.
+func writePanic(w http.ResponseWriter, logger *slog.Logger, function string, v any, stack bool) {
+	trace := string(debug.Stack())
+	if p, ok := v.(*recoveredPanic); ok {
+		v, trace = p.value, string(p.stack)
+	}
+	logger.Error("function panicked", "function", function, "panic", v, "stack", trace)
+
+	report := crashReport{
+		Function: function,
+		Panic:    fmt.Sprint(v),
+	}
+	if stack {
+		report.Stack = trace
+	}
+
+	w.Header().Set(errorCodeHeader, errorCodePanic)
+	if err := encodeOutput(w, http.StatusInternalServerError, report); err != nil {
+		logger.Error("failed to encode crash report", "function", function, "error", err)
+	}
+}
+
+// writeOutput serializes the function output with codec and writes it to the
+// HTTP response with a 200 OK status. Outputs with attachments are written as
+// a multipart response.
+func writeOutput(w http.ResponseWriter, codec Codec, v any) error {
+	if attachments := attachmentsOf(v); len(attachments) > 0 {
+		return writeAttachments(w, codec, v, attachments)
+	}
+
+	b, err := codec.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return err
+	}
+
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(b)
+	return err
+}
+
+// validationBody is the response body sent to the client when its input is
+// rejected by the validator.
+type validationBody struct {
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// writeInvalid rejects an input with 400 Bad Request. Validation errors are
+// sent as a validationBody, other errors as plain text.
+func writeInvalid(w http.ResponseWriter, logger *slog.Logger, err error) {
+	w.Header().Set(errorCodeHeader, errorCodeInvalid)
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		body := validationBody{Message: validationErr.Message, Fields: validationErr.Fields}
+		if err := encodeOutput(w, http.StatusBadRequest, body); err != nil {
+			logger.Error("failed to encode validation error", "error", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write([]byte(err.Error()))
+}
+
+// decodeValidationError builds the ValidationError of a call from the body of
+// the response rejecting its input.
+func decodeValidationError(function, contentType string, body []byte) *ValidationError {
+	var validation validationBody
+	if strings.HasPrefix(contentType, "application/json") && json.Unmarshal(body, &validation) == nil {
+		return &ValidationError{Function: function, Message: validation.Message, Fields: validation.Fields}
+	}
+	return &ValidationError{Function: function, Message: string(body)}
 }
 
 // encodeOutput serializes the response value to JSON and writes it to the HTTP response.
@@ -100,10 +377,10 @@ func encodeOutput(w http.ResponseWriter, status int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
-// decodeInput reads and validates the JSON input from an HTTP request.
+// decodeInput reads and validates the input from an HTTP request encoded with codec.
 // It performs validation if a validator is provided, then deserializes
-// the JSON into the expected input type T.
-func decodeInput[T any](r *http.Request, validator *Validator[T]) (*T, error) {
+// the input into req, which must be a pointer to the expected input type.
+func decodeInput(r *http.Request, codec Codec, validator inputValidator, req any) (*NormalizationReport, error) {
 	defer func() {
 		_ = r.Body.Close()
 	}()
@@ -114,26 +391,39 @@ func decodeInput[T any](r *http.Request, validator *Validator[T]) (*T, error) {
 		return nil, err
 	}
 
-	// Validate
+	return decodeData(data, codec, validator, req)
+}
+
+// decodeData validates and deserializes an input encoded with codec into req.
+// When the validator normalizes inputs, the normalized JSON document is the
+// one deserialized, and the report of the normalization is returned.
+func decodeData(data []byte, codec Codec, validator inputValidator, req any) (*NormalizationReport, error) {
+	var (
+		report *NormalizationReport
+		err    error
+	)
+
+	// Validate, through a generic JSON value for codecs other than JSON
 	if validator != nil {
-		result := validator.Validate(data)
-		if !result.IsValid() {
-			errors := make([]string, 0, len(result.Errors))
-			for field, err := range result.Errors {
-				errors = append(errors, fmt.Sprintf("%s: %s", field, err))
+		input := data
+		if codec != JSONCodec {
+			input, err = validationJSON(codec, data)
+			if err != nil {
+				return nil, err
 			}
-			return nil, fmt.Errorf("invalid input: %s", strings.Join(errors, ", "))
+		}
+
+		normalized, normalization, err := validator.check(input)
+		if err != nil {
+			return nil, err
+		}
+		if normalization != nil {
+			data, codec, report = normalized, JSONCodec, normalization
 		}
 	}
 
 	// Unmarshal after validation
-	var req T
-	dec := json.NewDecoder(bytes.NewReader(data))
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&req); err != nil {
-		return nil, err
-	}
-	return &req, nil
+	return report, unmarshalInput(codec, data, req)
 }
 
 // structAsJSONSchema generates a JSON schema from a Go struct type.
@@ -0,0 +1,64 @@
+package pluggo
+
+import (
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type greetInput struct {
+	Name      string `json:"name" jsonschema:"minLength=1"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type greetOutput struct {
+	Greeting string `json:"greeting"`
+}
+
+func greet(_ context.Context, in *greetInput) (*greetOutput, error) {
+	return &greetOutput{Greeting: "hello " + in.Name}, nil
+}
+
+// newTestPlugin creates a plugin logging nowhere, serving the greet function.
+func newTestPlugin(t *testing.T, opts ...PluginOption) *Plugin {
+	t.Helper()
+
+	p := NewPlugin(append([]PluginOption{WithLogger(slog.New(slog.DiscardHandler))}, opts...)...)
+
+	validator, err := NewValidator(&greetInput{})
+	if err != nil {
+		t.Fatalf("NewValidator: %v", err)
+	}
+	if err := p.AddFunction("greet", NewFunctionHandler(greet, validator).Handler()); err != nil {
+		t.Fatalf("AddFunction: %v", err)
+	}
+
+	return p
+}
+
+// serve serves p in-process until the end of the test and returns a
+// connection to it.
+func serve(t *testing.T, p *Plugin, protocol Protocol) *Connection {
+	t.Helper()
+
+	srv := httptest.NewServer(p.httpServer.Handler)
+	t.Cleanup(srv.Close)
+
+	return &Connection{BaseURL: srv.URL, Protocol: protocol, FunctionExecutionTimeout: 10 * time.Second}
+}
+
+// newTestFunction creates a client of a function, failing the test on error.
+func newTestFunction[T, R any](t *testing.T, name string, connection *Connection) *Function[T, R] {
+	t.Helper()
+
+	f, err := NewFunction[T, R](name, connection)
+	if err != nil {
+		t.Fatalf("NewFunction(%q): %v", name, err)
+	}
+	return f
+}
+
+// protocols are the protocols every call path is tested with.
+var protocols = []Protocol{ProtocolREST, ProtocolJSONRPC}
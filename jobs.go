@@ -0,0 +1,573 @@
+package pluggo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jobsPath     = "/_jobs"
+	jobsEndpoint = "_jobs"
+	resultPath   = "result"
+
+	errorCodeJobNotFound  = "job_not_found"
+	errorCodeJobPending   = "job_pending"
+	errorCodeJobCancelled = "job_cancelled"
+	errorCodeTooManyJobs  = "too_many_jobs"
+
+	// DefaultMaxJobs is the default maximum number of jobs kept by a plugin.
+	DefaultMaxJobs = 1000
+	// DefaultJobTTL is the default time finished jobs are kept by a plugin.
+	DefaultJobTTL = 10 * time.Minute
+
+	// maxJobWait bounds how long a status request waits for a job to finish.
+	maxJobWait = time.Minute
+	// jobPollWait is how long Job.Wait waits for a job to finish per status request.
+	jobPollWait = 10 * time.Second
+)
+
+// JobState is the state of an asynchronous job.
+type JobState string
+
+const (
+	// JobRunning is the state of a job whose function is still executing.
+	JobRunning JobState = "running"
+	// JobSucceeded is the state of a job whose function returned an output.
+	JobSucceeded JobState = "succeeded"
+	// JobFailed is the state of a job whose function returned an error.
+	JobFailed JobState = "failed"
+	// JobCancelled is the state of a job cancelled before its function returned.
+	JobCancelled JobState = "cancelled"
+)
+
+// Done reports whether the job is finished.
+func (s JobState) Done() bool {
+	return s != JobRunning
+}
+
+// JobStatus describes an asynchronous job.
+type JobStatus struct {
+	ID         string    `json:"id"`
+	Function   string    `json:"function"`
+	State      JobState  `json:"state"`
+	Progress   Progress  `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+}
+
+// WithJobLimits sets the maximum number of jobs kept by the plugin and how
+// long finished jobs are kept before being discarded with their results.
+// Submissions beyond the limit are rejected until jobs expire; cancelled jobs
+// count until their function returns.
+// Defaults to DefaultMaxJobs and DefaultJobTTL.
+func WithJobLimits(maxJobs int, ttl time.Duration) PluginOption {
+	return func(l *Plugin) {
+		l.jobs.maxJobs = maxJobs
+		l.jobs.ttl = ttl
+	}
+}
+
+// job is an asynchronous function call run by a plugin.
+type job struct {
+	mu       sync.Mutex
+	status   JobStatus
+	cancel   context.CancelFunc
+	response *responseBuffer
+	done     chan struct{}
+	// returned is set once the function returned, which can be after the
+	// job was cancelled.
+	returned bool
+}
+
+// snapshot returns the current status of the job.
+func (j *job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status
+}
+
+// report records the progress of the job.
+func (j *job) report(progress Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.Progress = progress
+}
+
+// stop cancels the job if it's still running. The job is cancelled right
+// away, even if its function takes time to honor the cancellation.
+func (j *job) stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.status.State != JobRunning {
+		return
+	}
+
+	j.cancel()
+	j.status.State = JobCancelled
+	j.status.FinishedAt = time.Now()
+	close(j.done)
+}
+
+// finish records that the job function returned, and its response unless
+// the job was cancelled.
+func (j *job) finish(response *responseBuffer) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.returned = true
+	if j.status.State != JobRunning {
+		return
+	}
+
+	j.response = response
+	j.status.FinishedAt = time.Now()
+
+	if response.status == http.StatusOK || response.status == 0 {
+		j.status.State = JobSucceeded
+	} else {
+		j.status.State = JobFailed
+		j.status.Error = string(bytes.TrimSpace(response.body.Bytes()))
+	}
+
+	close(j.done)
+}
+
+// expired reports whether the job finished more than ttl ago. Cancelled jobs
+// whose function is still running never expire, so that they keep counting
+// against the job limit.
+func (j *job) expired(now time.Time, ttl time.Duration) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.returned && j.status.State.Done() && now.Sub(j.status.FinishedAt) > ttl
+}
+
+// jobTable holds the jobs of a plugin. Finished jobs are discarded once their
+// TTL elapsed; running jobs, cancelled or not, are kept until their function
+// returns.
+type jobTable struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	maxJobs int
+	ttl     time.Duration
+}
+
+// newJobTable creates a job table with the default limits.
+func newJobTable() *jobTable {
+	return &jobTable{jobs: make(map[string]*job), maxJobs: DefaultMaxJobs, ttl: DefaultJobTTL}
+}
+
+// add adds a job to the table. It returns false if the table is full.
+func (t *jobTable) add(j *job) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.evict()
+	if len(t.jobs) >= t.maxJobs {
+		return false
+	}
+
+	t.jobs[j.status.ID] = j
+	return true
+}
+
+// get returns the job with the given ID, or nil.
+func (t *jobTable) get(id string) *job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.evict()
+	return t.jobs[id]
+}
+
+// evict discards the expired jobs. The caller must hold t.mu.
+func (t *jobTable) evict() {
+	now := time.Now()
+	for id, j := range t.jobs {
+		if j.expired(now, t.ttl) {
+			delete(t.jobs, id)
+		}
+	}
+}
+
+// stopAll cancels the running jobs.
+func (t *jobTable) stopAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, j := range t.jobs {
+		j.stop()
+	}
+}
+
+// newJobID returns a random job ID.
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submitJob serves POST /{functionName}/_jobs: it starts the function in the
+// background and returns the status of the new job with 202 Accepted. The
+// function runs through the same middleware as a synchronous call, with a
+// context that outlives the request.
+func (l *Plugin) submitJob(w http.ResponseWriter, r *http.Request, functionName string, function *registeredFunction) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte("method not allowed"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writePayloadTooLarge(w, maxBytesErr.Limit)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	j := &job{
+		status: JobStatus{
+			ID:        newJobID(),
+			Function:  functionName,
+			State:     JobRunning,
+			CreatedAt: time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	if !l.jobs.add(j) {
+		cancel()
+		w.Header().Set(errorCodeHeader, errorCodeTooManyJobs)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(ErrTooManyJobs.Error()))
+		return
+	}
+
+	req := r.Clone(withProgressReporter(ctx, j.report))
+	req.URL.Path = basePath + functionName
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	go func() {
+		defer cancel()
+
+		response := newResponseBuffer()
+		defer func() {
+			if v := recover(); v != nil {
+				writePanic(response, l.logger, functionName, v, false)
+			}
+			j.finish(response)
+		}()
+
+		function.handler.ServeHTTP(response, req)
+	}()
+
+	if err := encodeOutput(w, http.StatusAccepted, j.snapshot()); err != nil {
+		l.logger.Error("failed to encode job status", "job", j.status.ID, "error", err)
+	}
+}
+
+// serveJob serves the job endpoints:
+//
+//	GET    /_jobs/{id}?wait=10s  status, waiting up to wait for the job to finish
+//	GET    /_jobs/{id}/result    response of the function, once the job is finished
+//	DELETE /_jobs/{id}           cancels the job
+func (l *Plugin) serveJob(w http.ResponseWriter, r *http.Request) {
+	id, endpoint, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, jobsPath+"/"), "/")
+
+	j := l.jobs.get(id)
+	if j == nil || (endpoint != "" && endpoint != resultPath) {
+		w.Header().Set(errorCodeHeader, errorCodeJobNotFound)
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprintf(w, "job %q not found", id)
+		return
+	}
+
+	switch {
+	case endpoint == resultPath && r.Method == http.MethodGet:
+		writeJobResult(w, j)
+		return
+	case endpoint == "" && r.Method == http.MethodGet:
+		if wait, err := time.ParseDuration(r.URL.Query().Get("wait")); err == nil && wait > 0 {
+			timer := time.NewTimer(min(wait, maxJobWait))
+			select {
+			case <-j.done:
+			case <-timer.C:
+			case <-r.Context().Done():
+			}
+			timer.Stop()
+		}
+	case endpoint == "" && r.Method == http.MethodDelete:
+		j.stop()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte("method not allowed"))
+		return
+	}
+
+	if err := encodeOutput(w, http.StatusOK, j.snapshot()); err != nil {
+		l.logger.Error("failed to encode job status", "job", id, "error", err)
+	}
+}
+
+// writeJobResult replays the response of the function of a finished job.
+func writeJobResult(w http.ResponseWriter, j *job) {
+	j.mu.Lock()
+	state, response := j.status.State, j.response
+	j.mu.Unlock()
+
+	switch state {
+	case JobRunning:
+		w.Header().Set(errorCodeHeader, errorCodeJobPending)
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(ErrJobPending.Error()))
+		return
+	case JobCancelled:
+		w.Header().Set(errorCodeHeader, errorCodeJobCancelled)
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(ErrJobCancelled.Error()))
+		return
+	}
+
+	response.writeTo(w)
+}
+
+// Job is a handle to an asynchronous call of a plugin function, created with
+// Function.Submit. Its methods query the plugin, which keeps the job and its
+// result until DefaultJobTTL after it finished.
+type Job[R any] struct {
+	id         string
+	function   string
+	connection *Connection
+	codec      Codec
+	httpClient *http.Client
+	// checkOutput validates the encoded output of the job, see Function.checkOutput.
+	checkOutput func(codec Codec, out []byte) error
+}
+
+// Submit starts an asynchronous call of the function and returns as soon as
+// the plugin accepted it. The function runs without the execution timeout of
+// synchronous calls; use the returned Job to follow it and get its output.
+// It returns a FunctionExecutionError wrapping ErrTooManyJobs when the plugin
+// can't keep more jobs.
+func (f *Function[T, R]) Submit(ctx context.Context, input *T) (*Job[R], error) {
+	b, err := f.codec.Marshal(input)
+	if err != nil {
+		return nil, &FunctionExecutionError{Function: f.name, Err: err}
+	}
+
+	url := fmt.Sprintf("%s/%s/%s", f.clientConnection.baseURL(), f.name, jobsEndpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, &FunctionExecutionError{Function: f.name, Err: err}
+	}
+	req.Header.Set("Content-Type", f.codec.ContentType())
+	req.Header.Set("Accept", f.codec.ContentType())
+
+	if attachments := attachmentsOf(input); len(attachments) > 0 {
+		setAttachmentBody(req, f.codec, b, attachments)
+	}
+
+	propagator := f.clientConnection.Propagator
+	if propagator == nil {
+		propagator = W3CPropagator{}
+	}
+	propagator.Inject(ctx, req.Header)
+
+	job := f.Job("")
+
+	var status JobStatus
+	if err := job.do(req, 0, http.StatusAccepted, &status); err != nil {
+		return nil, err
+	}
+
+	job.id = status.ID
+	return job, nil
+}
+
+// Job returns a handle to a job of the function submitted earlier, e.g. by
+// another process, from its ID.
+func (f *Function[T, R]) Job(id string) *Job[R] {
+	return &Job[R]{
+		id:          id,
+		function:    f.name,
+		connection:  f.clientConnection,
+		codec:       f.codec,
+		httpClient:  &http.Client{Timeout: f.clientConnection.FunctionExecutionTimeout, Transport: f.clientConnection.transport()},
+		checkOutput: f.checkOutput,
+	}
+}
+
+// ID returns the ID of the job.
+func (j *Job[R]) ID() string {
+	return j.id
+}
+
+// Status returns the current status of the job, including its progress.
+func (j *Job[R]) Status(ctx context.Context) (*JobStatus, error) {
+	return j.status(ctx, 0)
+}
+
+// Wait waits for the job to finish and returns its output, as Result does.
+// Cancelling ctx stops waiting without cancelling the job.
+func (j *Job[R]) Wait(ctx context.Context) (*R, error) {
+	for {
+		status, err := j.status(ctx, jobPollWait)
+		if err != nil {
+			return nil, err
+		}
+		if status.State.Done() {
+			return j.Result(ctx)
+		}
+	}
+}
+
+// Result returns the output of a finished job, or the error returned by the
+// function. It returns ErrJobPending if the job is still running and
+// ErrJobCancelled if it was cancelled. With output validation, outputs
+// violating the output schema fail with a ContractViolationError.
+func (j *Job[R]) Result(ctx context.Context) (*R, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url()+"/"+resultPath, nil)
+	if err != nil {
+		return nil, &FunctionExecutionError{Function: j.function, Err: err}
+	}
+
+	resp, err := j.httpClient.Do(req)
+	if err != nil {
+		return nil, &FunctionExecutionError{Function: j.function, Err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		out, _ := io.ReadAll(resp.Body)
+		return nil, j.responseError(resp, out)
+	}
+
+	var (
+		output   R
+		contract error
+	)
+	err = decodeResponse(resp, j.codec, func(codec Codec, out []byte) error {
+		if contract = j.checkOutput(codec, out); contract != nil {
+			return contract
+		}
+		return codec.Unmarshal(out, &output)
+	}, &output)
+	if contract != nil {
+		return nil, contract
+	}
+	if err != nil {
+		return nil, &FunctionExecutionError{Function: j.function, Err: err}
+	}
+	return &output, nil
+}
+
+// Cancel cancels the job: it moves to the JobCancelled state and the context
+// of its function is cancelled. Cancelling a finished job does nothing.
+func (j *Job[R]) Cancel(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, j.url(), nil)
+	if err != nil {
+		return &FunctionExecutionError{Function: j.function, Err: err}
+	}
+
+	return j.do(req, 0, http.StatusOK, &JobStatus{})
+}
+
+// status returns the status of the job, waiting up to wait for it to finish.
+func (j *Job[R]) status(ctx context.Context, wait time.Duration) (*JobStatus, error) {
+	url := j.url()
+	if wait > 0 {
+		url += "?wait=" + wait.String()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &FunctionExecutionError{Function: j.function, Err: err}
+	}
+
+	var status JobStatus
+	if err := j.do(req, wait, http.StatusOK, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// do sends a request to the job endpoints and decodes the JSON response into
+// v. Requests long polling the plugin for up to wait get wait more than the
+// execution timeout of the connection.
+func (j *Job[R]) do(req *http.Request, wait time.Duration, expected int, v any) error {
+	httpClient := j.httpClient
+	if wait > 0 && httpClient.Timeout > 0 {
+		longPoll := *httpClient
+		longPoll.Timeout += wait
+		httpClient = &longPoll
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return &FunctionExecutionError{Function: j.function, Err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &FunctionExecutionError{Function: j.function, Err: err}
+	}
+
+	if resp.StatusCode != expected {
+		return j.responseError(resp, out)
+	}
+
+	if err := json.Unmarshal(out, v); err != nil {
+		return &FunctionExecutionError{Function: j.function, Err: err}
+	}
+	return nil
+}
+
+// responseError maps an unsuccessful response of the job endpoints to the
+// matching error type.
+func (j *Job[R]) responseError(resp *http.Response, body []byte) error {
+	switch resp.Header.Get(errorCodeHeader) {
+	case errorCodeJobNotFound:
+		return &JobNotFoundError{ID: j.id}
+	case errorCodeJobPending:
+		return ErrJobPending
+	case errorCodeJobCancelled:
+		return ErrJobCancelled
+	case errorCodeTooManyJobs:
+		return &FunctionExecutionError{Function: j.function, Err: ErrTooManyJobs}
+	}
+
+	return responseError(j.function, resp, body)
+}
+
+// url returns the URL of the job.
+func (j *Job[R]) url() string {
+	return j.connection.baseURL() + jobsPath + "/" + j.id
+}
@@ -0,0 +1,214 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForStatus polls the status of a job until check accepts it.
+func waitForStatus[R any](t *testing.T, job *Job[R], check func(*JobStatus) bool) *JobStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := job.Status(context.Background())
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		if check(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job status = %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	release := make(chan struct{})
+	p := newTestPlugin(t)
+	handler := NewFunctionHandler(func(ctx context.Context, in *greetInput) (*greetOutput, error) {
+		ReportProgress(ctx, Progress{Percent: 50, Message: "halfway"})
+		<-release
+		return &greetOutput{Greeting: "hello " + in.Name}, nil
+	}, nil).Handler()
+	if err := p.AddFunction("step", handler); err != nil {
+		t.Fatal(err)
+	}
+	f := newTestFunction[greetInput, greetOutput](t, "step", serve(t, p, ProtocolREST))
+
+	job, err := f.Submit(context.Background(), &greetInput{Name: "ada"})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if job.ID() == "" {
+		t.Fatal("submitted job without an ID")
+	}
+
+	status := waitForStatus(t, job, func(s *JobStatus) bool { return s.Progress.Percent == 50 })
+	if status.State != JobRunning || status.Function != "step" || status.Progress.Message != "halfway" {
+		t.Errorf("running job status = %+v", status)
+	}
+	if _, err := job.Result(context.Background()); !errors.Is(err, ErrJobPending) {
+		t.Errorf("result of a running job = %v, want ErrJobPending", err)
+	}
+
+	close(release)
+	out, err := f.Job(job.ID()).Wait(context.Background())
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if out.Greeting != "hello ada" {
+		t.Errorf("greeting = %q, want %q", out.Greeting, "hello ada")
+	}
+
+	status, err = job.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != JobSucceeded || status.FinishedAt.IsZero() {
+		t.Errorf("finished job status = %+v", status)
+	}
+}
+
+func TestJobFailure(t *testing.T) {
+	f := newTestFunction[greetInput, greetOutput](t, "greet", serve(t, newTestPlugin(t), ProtocolREST))
+
+	job, err := f.Submit(context.Background(), &greetInput{})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	var validation *ValidationError
+	if _, err := job.Wait(context.Background()); !errors.As(err, &validation) {
+		t.Errorf("Wait = %v, want the ValidationError of the function", err)
+	}
+
+	status, err := job.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != JobFailed || status.Error == "" {
+		t.Errorf("failed job status = %+v", status)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	p := newTestPlugin(t)
+	started, stopped := addBlockingFunction(t, p, nil)
+	f := newTestFunction[greetInput, greetOutput](t, "block", serve(t, p, ProtocolREST))
+
+	job, err := f.Submit(context.Background(), &greetInput{Name: "ada"})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+
+	if err := job.Cancel(context.Background()); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("function context error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the function was not cancelled")
+	}
+
+	status, err := job.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != JobCancelled {
+		t.Errorf("state = %s, want %s", status.State, JobCancelled)
+	}
+	if _, err := job.Wait(context.Background()); !errors.Is(err, ErrJobCancelled) {
+		t.Errorf("Wait = %v, want ErrJobCancelled", err)
+	}
+	if err := job.Cancel(context.Background()); err != nil {
+		t.Errorf("cancelling a finished job: %v", err)
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	f := newTestFunction[greetInput, greetOutput](t, "greet", serve(t, newTestPlugin(t), ProtocolREST))
+	job := f.Job("missing")
+
+	var notFound *JobNotFoundError
+	if _, err := job.Status(context.Background()); !errors.As(err, &notFound) {
+		t.Errorf("Status = %v, want a JobNotFoundError", err)
+	}
+	if _, err := job.Result(context.Background()); !errors.As(err, &notFound) {
+		t.Errorf("Result = %v, want a JobNotFoundError", err)
+	}
+	if err := job.Cancel(context.Background()); !errors.As(err, &notFound) {
+		t.Errorf("Cancel = %v, want a JobNotFoundError", err)
+	}
+}
+
+func TestTooManyJobs(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	p := newTestPlugin(t, WithJobLimits(1, time.Minute))
+	started, _ := addBlockingFunction(t, p, release)
+	f := newTestFunction[greetInput, greetOutput](t, "block", serve(t, p, ProtocolREST))
+
+	if _, err := f.Submit(context.Background(), &greetInput{Name: "ada"}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+
+	if _, err := f.Submit(context.Background(), &greetInput{Name: "bob"}); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("Submit past the limit = %v, want ErrTooManyJobs", err)
+	}
+}
+
+func TestCancelledJobsCountUntilReturned(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	// stubborn ignores the cancellation of its context
+	stubborn := NewFunctionHandler(func(_ context.Context, in *greetInput) (*greetOutput, error) {
+		started <- struct{}{}
+		<-release
+		return &greetOutput{Greeting: "hello " + in.Name}, nil
+	}, nil).Handler()
+
+	p := newTestPlugin(t, WithJobLimits(1, time.Millisecond))
+	if err := p.AddFunction("stubborn", stubborn); err != nil {
+		t.Fatal(err)
+	}
+	f := newTestFunction[greetInput, greetOutput](t, "stubborn", serve(t, p, ProtocolREST))
+
+	job, err := f.Submit(context.Background(), &greetInput{Name: "ada"})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+	if err := job.Cancel(context.Background()); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := f.Submit(context.Background(), &greetInput{Name: "bob"}); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("Submit while a cancelled job is running = %v, want ErrTooManyJobs", err)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := f.Submit(context.Background(), &greetInput{Name: "bob"})
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrTooManyJobs) || time.Now().After(deadline) {
+			t.Fatalf("Submit once the cancelled job returned = %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,430 @@
+package pluggo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	errorCodeBusy            = "busy"
+	errorCodePayloadTooLarge = "payload_too_large"
+
+	// DefaultMaxBodySize is the default maximum size of a request body accepted
+	// by a plugin. Attachments have their own limit, see WithAttachmentLimits.
+	DefaultMaxBodySize = 32 << 20
+	// DefaultIdleTimeout is the default time a plugin keeps idle connections open.
+	DefaultIdleTimeout = 2 * time.Minute
+	// DefaultMaxResponseSize is the default maximum size of a response read by a client.
+	DefaultMaxResponseSize = 64 << 20
+
+	// Scopes of a ConcurrencyStatus.
+	ConcurrencyScopeFunction = "function"
+	ConcurrencyScopePlugin   = "plugin"
+)
+
+// ConcurrencyLimit bounds the number of requests a plugin handles at once.
+// Requests beyond MaxConcurrent wait in a queue of at most MaxQueue requests
+// for up to QueueTimeout; requests that can't be queued or time out are
+// rejected with a PluginBusyError.
+type ConcurrencyLimit struct {
+	// MaxConcurrent is the maximum number of requests handled at once.
+	MaxConcurrent int
+	// MaxQueue is the maximum number of requests waiting for a slot. Zero
+	// rejects requests as soon as all slots are taken.
+	MaxQueue int
+	// QueueTimeout is how long a request waits for a slot. Zero waits until
+	// the caller gives up.
+	QueueTimeout time.Duration
+}
+
+// ConcurrencyStatus describes a concurrency limit applying to a function,
+// and its current utilization.
+type ConcurrencyStatus struct {
+	Scope         string `json:"scope"`
+	MaxConcurrent int    `json:"maxConcurrent"`
+	MaxQueue      int    `json:"maxQueue"`
+	QueueTimeout  string `json:"queueTimeout,omitempty"`
+	InFlight      int    `json:"inFlight"`
+	Queued        int    `json:"queued"`
+}
+
+// WithConcurrencyLimit bounds the number of requests handled at once by all
+// the functions of the plugin together.
+func WithConcurrencyLimit(limit ConcurrencyLimit) PluginOption {
+	return func(l *Plugin) {
+		l.limiter = newLimiter(ConcurrencyScopePlugin, limit)
+	}
+}
+
+// WithFunctionConcurrencyLimit bounds the number of requests handled at once
+// by the registered function. It applies in addition to the plugin limit.
+func WithFunctionConcurrencyLimit(limit ConcurrencyLimit) FunctionOption {
+	return func(o *functionOptions) {
+		o.concurrencyLimit = &limit
+	}
+}
+
+// limiter enforces a ConcurrencyLimit.
+type limiter struct {
+	scope  string
+	limit  ConcurrencyLimit
+	slots  chan struct{}
+	queued atomic.Int64
+}
+
+// newLimiter creates a limiter, or returns nil if the limit is unbounded.
+func newLimiter(scope string, limit ConcurrencyLimit) *limiter {
+	if limit.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &limiter{scope: scope, limit: limit, slots: make(chan struct{}, limit.MaxConcurrent)}
+}
+
+// acquire waits for a slot. It returns false if the request was rejected.
+func (l *limiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > int64(l.limit.MaxQueue) {
+		l.queued.Add(-1)
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	var timeout <-chan time.Time
+	if l.limit.QueueTimeout > 0 {
+		timer := time.NewTimer(l.limit.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken with acquire.
+func (l *limiter) release() {
+	<-l.slots
+}
+
+// status returns the limit and its current utilization.
+func (l *limiter) status() ConcurrencyStatus {
+	status := ConcurrencyStatus{
+		Scope:         l.scope,
+		MaxConcurrent: l.limit.MaxConcurrent,
+		MaxQueue:      l.limit.MaxQueue,
+		InFlight:      len(l.slots),
+		Queued:        int(l.queued.Load()),
+	}
+	if l.limit.QueueTimeout > 0 {
+		status.QueueTimeout = l.limit.QueueTimeout.String()
+	}
+	return status
+}
+
+// retryAfter returns the delay suggested to rejected callers.
+func (l *limiter) retryAfter() time.Duration {
+	return max(l.limit.QueueTimeout, time.Second)
+}
+
+// admit wraps a function handler with the admission control of the function
+// and of the plugin. Rejected requests get 503 Service Unavailable with a
+// Retry-After header.
+func (l *Plugin) admit(functionLimiter *limiter, handler http.Handler) http.Handler {
+	limiters := make([]*limiter, 0, 2)
+	for _, lim := range []*limiter{functionLimiter, l.limiter} {
+		if lim != nil {
+			limiters = append(limiters, lim)
+		}
+	}
+	if len(limiters) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acquired := make([]*limiter, 0, len(limiters))
+		slot := &callSlot{holders: 1, release: func() {
+			for _, lim := range acquired {
+				lim.release()
+			}
+		}}
+		defer slot.done()
+
+		for _, lim := range limiters {
+			if !lim.acquire(r.Context()) {
+				writeBusy(w, functionFromPath(r.URL.Path), lim)
+				return
+			}
+			acquired = append(acquired, lim)
+		}
+
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callSlotKey{}, slot)))
+	})
+}
+
+// callSlotKey is the context key of the callSlot of an admitted call.
+type callSlotKey struct{}
+
+// callSlot holds the concurrency slots of an admitted call until the
+// handler of the call, and every goroutine of the call outliving it, return.
+type callSlot struct {
+	mu      sync.Mutex
+	holders int
+	release func()
+}
+
+// hold keeps the slots until a matching call to done. It does nothing on a
+// nil slot, the one of calls that are not limited.
+func (s *callSlot) hold() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holders++
+}
+
+// done releases a hold, releasing the slots with the last one.
+func (s *callSlot) done() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holders--
+	if s.holders == 0 {
+		s.release()
+	}
+}
+
+// concurrency returns the status of the limits applying to a function.
+func (l *Plugin) concurrency(functionLimiter *limiter) []ConcurrencyStatus {
+	var statuses []ConcurrencyStatus
+	for _, lim := range []*limiter{functionLimiter, l.limiter} {
+		if lim != nil {
+			statuses = append(statuses, lim.status())
+		}
+	}
+	return statuses
+}
+
+// writeBusy rejects a request because a concurrency limit is reached.
+func writeBusy(w http.ResponseWriter, function string, limiter *limiter) {
+	seconds := int(math.Ceil(limiter.retryAfter().Seconds()))
+
+	w.Header().Set(errorCodeHeader, errorCodeBusy)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = fmt.Fprintf(w, "function %q is busy: %s concurrency limit reached", function, limiter.scope)
+}
+
+// retryAfter parses the Retry-After header of a response, in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// WithMaxBodySize sets the maximum size in bytes of a request body. Larger
+// requests are rejected with 413 Request Entity Too Large, which surfaces on
+// the client as a PayloadTooLargeError. Function calls carrying attachments
+// are bounded by the WithAttachmentLimits of their handler instead; jobs
+// carrying attachments are buffered, and bounded by this limit. Zero or
+// negative values disable the limit. Defaults to DefaultMaxBodySize.
+func WithMaxBodySize(size int64) PluginOption {
+	return func(l *Plugin) {
+		l.maxBodySize = size
+	}
+}
+
+// WithWriteTimeout sets the maximum duration of a request, from the end of
+// its headers to the end of the response. It bounds the duration of function
+// calls, progress streams and job long-polls too. No timeout by default.
+func WithWriteTimeout(timeout time.Duration) PluginOption {
+	return func(l *Plugin) {
+		l.httpServer.WriteTimeout = timeout
+	}
+}
+
+// WithIdleTimeout sets how long idle keep-alive connections are kept open.
+// Defaults to DefaultIdleTimeout.
+func WithIdleTimeout(timeout time.Duration) PluginOption {
+	return func(l *Plugin) {
+		l.httpServer.IdleTimeout = timeout
+	}
+}
+
+// WithMaxHeaderBytes sets the maximum size in bytes of the request headers.
+// Defaults to http.DefaultMaxHeaderBytes.
+func WithMaxHeaderBytes(size int) PluginOption {
+	return func(l *Plugin) {
+		l.httpServer.MaxHeaderBytes = size
+	}
+}
+
+// WithLogger sets the logger of the plugin, of its HTTP server and of the
+// function handlers it serves. Defaults to a text logger writing to stderr.
+func WithLogger(logger *slog.Logger) PluginOption {
+	return func(l *Plugin) {
+		l.logger = logger
+	}
+}
+
+// loggerKey is the context key of the logger of the plugin serving a request.
+type loggerKey struct{}
+
+// withLogger wraps the plugin mux to pass the plugin logger to the handlers
+// through the request context.
+func (l *Plugin) withLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loggerKey{}, l.logger)))
+	})
+}
+
+// contextLogger returns the logger of the plugin serving the request carrying
+// ctx, or the default logger for handlers served outside of a plugin.
+func contextLogger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// limitBody wraps the plugin mux to bound the size of request bodies.
+func (l *Plugin) limitBody(next http.Handler) http.Handler {
+	if l.maxBodySize <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && !(hasAttachments(r) && l.boundsAttachments(r.URL.Path)) {
+			if r.ContentLength > l.maxBodySize {
+				writePayloadTooLarge(w, l.maxBodySize)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, l.maxBodySize)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// boundsAttachments reports whether path is the endpoint of a function call
+// whose handler bounds the attachments of requests itself.
+func (l *Plugin) boundsAttachments(path string) bool {
+	name, endpoint, _ := strings.Cut(strings.TrimPrefix(path, basePath), "/")
+	if endpoint != "" {
+		return false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	function, ok := l.functions[name]
+	return ok && function.boundsAttachments
+}
+
+// writePayloadTooLarge rejects a request whose body exceeds limit bytes.
+func writePayloadTooLarge(w http.ResponseWriter, limit int64) {
+	w.Header().Set(errorCodeHeader, errorCodePayloadTooLarge)
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	_, _ = fmt.Fprintf(w, "request body exceeds %d bytes", limit)
+}
+
+// WithMaxResponseSize sets the maximum size in bytes of a response read by
+// the client, so that a misbehaving plugin can't exhaust its memory or disk.
+// Larger responses fail with a ResponseTooLargeError. The limit bounds
+// responses carrying attachments too, attachments included, even though
+// these are spooled to disk. Defaults to DefaultMaxResponseSize.
+func WithMaxResponseSize(size int64) ClientOption {
+	return func(p *Client) {
+		p.maxResponseSize = size
+	}
+}
+
+// transport returns the transport of the connection, bounding the size of
+// the responses to MaxResponseSize.
+func (c *Connection) transport() http.RoundTripper {
+	limit := c.MaxResponseSize
+	if limit == 0 {
+		limit = DefaultMaxResponseSize
+	}
+	if limit < 0 {
+		return c.Transport
+	}
+	return limitedTransport{next: c.Transport, limit: limit}
+}
+
+// limitedTransport is an http.RoundTripper failing responses larger than limit.
+type limitedTransport struct {
+	next  http.RoundTripper
+	limit int64
+}
+
+// RoundTrip sends the request and bounds the body of the response.
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ContentLength > t.limit {
+		_ = resp.Body.Close()
+		return nil, &ResponseTooLargeError{Limit: t.limit}
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, limit: t.limit, remaining: t.limit}
+	return resp, nil
+}
+
+// limitedBody is a response body failing with a ResponseTooLargeError once
+// more than its limit was read.
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+// Read reads from the body, up to the limit.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, &ResponseTooLargeError{Limit: b.limit}
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), &ResponseTooLargeError{Limit: b.limit}
+	}
+	return n, err
+}
@@ -0,0 +1,254 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addGatedFunction registers a function that blocks until release is closed,
+// reporting every start on started.
+func addGatedFunction(t *testing.T, p *Plugin, release <-chan struct{}, opts ...FunctionOption) <-chan struct{} {
+	t.Helper()
+
+	started := make(chan struct{}, 8)
+	handler := NewFunctionHandler(func(_ context.Context, in *greetInput) (*greetOutput, error) {
+		started <- struct{}{}
+		<-release
+		return &greetOutput{Greeting: "hello " + in.Name}, nil
+	}, nil).Handler()
+	if err := p.AddFunction("gated", handler, opts...); err != nil {
+		t.Fatal(err)
+	}
+
+	return started
+}
+
+// waitForQueued waits until a limit of a function has n queued requests.
+func waitForQueued(t *testing.T, p *Plugin, function string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		statuses := p.Schemas()[function].Concurrency
+		if len(statuses) > 0 && statuses[0].Queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("concurrency of %s = %+v, want %d queued", function, statuses, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFunctionConcurrencyLimit(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			release := make(chan struct{})
+			p := newTestPlugin(t)
+			started := addGatedFunction(t, p, release, WithFunctionConcurrencyLimit(ConcurrencyLimit{MaxConcurrent: 1, MaxQueue: 1}))
+			f := newTestFunction[greetInput, greetOutput](t, "gated", serve(t, p, protocol))
+
+			results := make(chan error, 2)
+			for _, name := range []string{"ada", "bob"} {
+				go func() {
+					out, err := f.Call(&greetInput{Name: name})
+					if err == nil && out.Greeting != "hello "+name {
+						err = errors.New("unexpected greeting " + out.Greeting)
+					}
+					results <- err
+				}()
+				if name == "ada" {
+					<-started
+				}
+			}
+			waitForQueued(t, p, "gated", 1)
+
+			_, err := f.Call(&greetInput{Name: "eve"})
+			var busy *PluginBusyError
+			if !errors.As(err, &busy) {
+				t.Fatalf("call past the queue = %v, want a PluginBusyError", err)
+			}
+			if busy.Function != "gated" || busy.RetryAfter != time.Second {
+				t.Errorf("busy error = %+v, want function gated retried after 1s", busy)
+			}
+
+			status := p.Schemas()["gated"].Concurrency[0]
+			if status.Scope != ConcurrencyScopeFunction || status.InFlight != 1 || status.MaxConcurrent != 1 {
+				t.Errorf("concurrency status = %+v", status)
+			}
+
+			close(release)
+			for range 2 {
+				if err := <-results; err != nil {
+					t.Errorf("admitted call: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrencyQueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	p := newTestPlugin(t)
+	started := addGatedFunction(t, p, release, WithFunctionConcurrencyLimit(ConcurrencyLimit{
+		MaxConcurrent: 1,
+		MaxQueue:      1,
+		QueueTimeout:  50 * time.Millisecond,
+	}))
+	f := newTestFunction[greetInput, greetOutput](t, "gated", serve(t, p, ProtocolREST))
+
+	go func() {
+		_, _ = f.Call(&greetInput{Name: "ada"})
+	}()
+	<-started
+
+	start := time.Now()
+	_, err := f.Call(&greetInput{Name: "bob"})
+	var busy *PluginBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("queued call = %v, want a PluginBusyError", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("queued call rejected after %v, want it to wait for the queue timeout", elapsed)
+	}
+}
+
+func TestPluginConcurrencyLimit(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			release := make(chan struct{})
+			defer close(release)
+
+			p := newTestPlugin(t, WithConcurrencyLimit(ConcurrencyLimit{MaxConcurrent: 1}))
+			started := addGatedFunction(t, p, release)
+			connection := serve(t, p, protocol)
+			gated := newTestFunction[greetInput, greetOutput](t, "gated", connection)
+
+			go func() {
+				_, _ = gated.Call(&greetInput{Name: "ada"})
+			}()
+			<-started
+
+			_, err := newTestFunction[greetInput, greetOutput](t, "greet", connection).Call(&greetInput{Name: "bob"})
+			var busy *PluginBusyError
+			if !errors.As(err, &busy) {
+				t.Fatalf("call of another function = %v, want a PluginBusyError", err)
+			}
+
+			statuses := p.Schemas()["greet"].Concurrency
+			if len(statuses) != 1 || statuses[0].Scope != ConcurrencyScopePlugin || statuses[0].InFlight != 1 {
+				t.Errorf("concurrency of greet = %+v, want the plugin limit in use", statuses)
+			}
+		})
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	p := newTestPlugin(t, WithMaxBodySize(256))
+	handler := NewFunctionHandler(upload, nil, WithAttachmentLimits(2<<10, 1<<10)).Handler()
+	if err := p.AddFunction("upload", handler); err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("a", 512)
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			greet := newTestFunction[greetInput, greetOutput](t, "greet", serve(t, p, protocol))
+
+			var tooLarge *PayloadTooLargeError
+			if _, err := greet.Call(&greetInput{Name: large}); !errors.As(err, &tooLarge) {
+				t.Errorf("call past the limit = %v, want a PayloadTooLargeError", err)
+			}
+			if _, err := greet.Call(&greetInput{Name: "ada"}); err != nil {
+				t.Errorf("call within the limit: %v", err)
+			}
+		})
+	}
+
+	connection := serve(t, p, ProtocolREST)
+	greet := newTestFunction[greetInput, greetOutput](t, "greet", connection)
+	upload := newTestFunction[uploadInput, uploadOutput](t, "upload", connection)
+	var tooLarge *PayloadTooLargeError
+
+	t.Run("job", func(t *testing.T) {
+		if _, err := greet.Submit(context.Background(), &greetInput{Name: large}); !errors.As(err, &tooLarge) {
+			t.Errorf("job past the limit = %v, want a PayloadTooLargeError", err)
+		}
+	})
+
+	t.Run("job with attachments", func(t *testing.T) {
+		input := &uploadInput{File: NewAttachment("a.bin", "", strings.NewReader(large))}
+		if _, err := upload.Submit(context.Background(), input); !errors.As(err, &tooLarge) {
+			t.Errorf("job past the limit = %v, want a PayloadTooLargeError", err)
+		}
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		for _, input := range []*uploadInput{
+			{File: NewAttachment("a.bin", "", strings.NewReader(large))},
+			{File: NewAttachment("a.bin", "", strings.NewReader(large)), Extra: NewAttachment("b.bin", "", strings.NewReader(large))},
+		} {
+			out, err := upload.Call(input)
+			if err != nil {
+				t.Fatalf("attachments within the handler limit: %v", err)
+			}
+			_ = out.Echo.Close()
+		}
+
+		input := &uploadInput{File: NewAttachment("a.bin", "", strings.NewReader(strings.Repeat(large, 8)))}
+		if _, err := upload.Call(input); !errors.As(err, &tooLarge) {
+			t.Errorf("attachments past the handler limit = %v, want a PayloadTooLargeError", err)
+		}
+	})
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	p := newTestPlugin(t)
+	if err := p.AddFunction("upload", NewFunctionHandler(upload, nil).Handler()); err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("a", 512)
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			connection := serve(t, p, protocol)
+			connection.MaxResponseSize = 256
+			greet := newTestFunction[greetInput, greetOutput](t, "greet", connection)
+
+			var tooLarge *ResponseTooLargeError
+			if _, err := greet.Call(&greetInput{Name: large}); !errors.As(err, &tooLarge) || tooLarge.Limit != 256 {
+				t.Errorf("response past the limit = %v, want a ResponseTooLargeError", err)
+			}
+			if _, err := greet.Call(&greetInput{Name: "ada"}); err != nil {
+				t.Errorf("response within the limit: %v", err)
+			}
+		})
+	}
+
+	t.Run("attachments", func(t *testing.T) {
+		connection := serve(t, p, ProtocolREST)
+		connection.MaxResponseSize = 256
+		upload := newTestFunction[uploadInput, uploadOutput](t, "upload", connection)
+
+		var tooLarge *ResponseTooLargeError
+		input := &uploadInput{File: NewAttachment("a.bin", "", strings.NewReader(large))}
+		if _, err := upload.Call(input); !errors.As(err, &tooLarge) {
+			t.Errorf("attachment past the limit = %v, want a ResponseTooLargeError", err)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		connection := serve(t, p, ProtocolREST)
+		connection.MaxResponseSize = -1
+
+		out, err := newTestFunction[greetInput, greetOutput](t, "greet", connection).Call(&greetInput{Name: large})
+		if err != nil || out.Greeting != "hello "+large {
+			t.Errorf("unlimited response = %v", err)
+		}
+	})
+}
@@ -0,0 +1,413 @@
+// Package mcp exposes pluggo plugins as a Model Context Protocol (MCP) server.
+//
+// The server speaks JSON-RPC 2.0 over newline-delimited messages, as required
+// by the MCP stdio transport, and serves the functions of the plugins added to
+// a pluggo.ToolDispatcher as MCP tools.
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/henomis/pluggo"
+)
+
+const (
+	jsonRPCVersion = "2.0"
+
+	// ProtocolVersion is the latest MCP protocol version supported by the server.
+	ProtocolVersion = "2025-06-18"
+
+	// defaultMaxMessageSize is the maximum size of a single JSON-RPC message.
+	defaultMaxMessageSize = 16 << 20
+
+	// messagePrefixSize is the size of the prefix of oversized messages
+	// read to find their id.
+	messagePrefixSize = 4 << 10
+)
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// supportedProtocolVersions are the MCP protocol versions the server can speak.
+var supportedProtocolVersions = map[string]bool{
+	"2024-11-05":    true,
+	"2025-03-26":    true,
+	ProtocolVersion: true,
+}
+
+// request is a JSON-RPC request or notification.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC error object.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Tool is an MCP tool definition, as returned by tools/list.
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// Content is an MCP content block of a tool result.
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// CallToolResult is the result of tools/call. Tool failures, such as invalid
+// arguments, are reported with IsError set so the model can react to them.
+type CallToolResult struct {
+	Content           []Content       `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent,omitempty"`
+	IsError           bool            `json:"isError,omitempty"`
+}
+
+// Server is an MCP server exposing the tools of a pluggo.ToolDispatcher.
+type Server struct {
+	dispatcher     *pluggo.ToolDispatcher
+	name           string
+	version        string
+	maxMessageSize int
+
+	writeMu sync.Mutex
+}
+
+// ServerOption is a function that configures a Server during creation.
+type ServerOption func(*Server)
+
+// WithServerInfo sets the name and version reported to clients during initialization.
+func WithServerInfo(name, version string) ServerOption {
+	return func(s *Server) {
+		s.name = name
+		s.version = version
+	}
+}
+
+// WithMaxMessageSize sets the maximum size in bytes of a single incoming
+// message. Larger messages get an invalid request error.
+func WithMaxMessageSize(size int) ServerOption {
+	return func(s *Server) {
+		s.maxMessageSize = size
+	}
+}
+
+// NewServer creates an MCP server serving the tools of dispatcher.
+func NewServer(dispatcher *pluggo.ToolDispatcher, opts ...ServerOption) *Server {
+	s := &Server{
+		dispatcher:     dispatcher,
+		name:           "pluggo",
+		version:        "dev",
+		maxMessageSize: defaultMaxMessageSize,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Serve reads newline-delimited JSON-RPC messages from r and writes the
+// responses to w until r is exhausted or ctx is cancelled. Tool calls are
+// executed concurrently; Serve waits for the pending ones before returning.
+// Messages larger than the maximum message size are rejected without ending
+// the session.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+
+		reader := bufio.NewReader(r)
+		for {
+			line, tooLarge, err := readMessage(reader, s.maxMessageSize)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+			if tooLarge {
+				s.write(w, response{ID: messageID(line), Error: &rpcError{
+					Code:    codeInvalidRequest,
+					Message: fmt.Sprintf("message exceeds %d bytes", s.maxMessageSize),
+				}})
+				continue
+			}
+
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line, ok := <-lines:
+			if !ok {
+				return <-readErr
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var req request
+			if err := json.Unmarshal(line, &req); err != nil {
+				s.write(w, response{ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: err.Error()}})
+				continue
+			}
+
+			if isNotification(req.Method) {
+				continue
+			}
+			if req.ID == nil || string(req.ID) == "null" {
+				s.write(w, response{ID: json.RawMessage("null"), Error: &rpcError{Code: codeInvalidRequest, Message: "request id is missing"}})
+				continue
+			}
+
+			if req.Method == "tools/call" {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.respond(ctx, w, req)
+				}()
+				continue
+			}
+
+			s.respond(ctx, w, req)
+		}
+	}
+}
+
+// readMessage reads a newline-delimited message of at most limit bytes. The
+// rest of larger messages is discarded: only their first messagePrefixSize
+// bytes are returned, with tooLarge set, so that they can still be answered.
+func readMessage(r *bufio.Reader, limit int) (message []byte, tooLarge bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			if len(message)+len(chunk) > limit {
+				tooLarge = true
+				message = append(message, chunk...)[:min(len(message)+len(chunk), messagePrefixSize)]
+			} else {
+				message = append(message, chunk...)
+			}
+		}
+
+		switch {
+		case err == nil:
+			return bytes.TrimSuffix(message, []byte("\n")), tooLarge, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(message) > 0:
+			return message, tooLarge, nil
+		default:
+			return nil, false, err
+		}
+	}
+}
+
+// messageID reads the id of a message that can't be decoded, e.g. because it
+// is truncated, decoding its members up to the id. It returns null if the id
+// can't be read.
+func messageID(data []byte) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return json.RawMessage("null")
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			break
+		}
+		if key == "id" {
+			return value
+		}
+	}
+
+	return json.RawMessage("null")
+}
+
+// isNotification reports whether method is an MCP notification, which has
+// no id and gets no response.
+func isNotification(method string) bool {
+	return strings.HasPrefix(method, "notifications/")
+}
+
+// respond handles a request and writes its response.
+func (s *Server) respond(ctx context.Context, w io.Writer, req request) {
+	result, rpcErr := s.handle(ctx, req)
+	s.write(w, response{ID: req.ID, Result: result, Error: rpcErr})
+}
+
+// handle dispatches a request to the MCP method it invokes.
+func (s *Server) handle(ctx context.Context, req request) (any, *rpcError) {
+	if req.JSONRPC != jsonRPCVersion {
+		return nil, &rpcError{Code: codeInvalidRequest, Message: "unsupported JSON-RPC version"}
+	}
+
+	switch req.Method {
+	case "initialize":
+		return s.initialize(req.Params)
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return s.listTools(), nil
+	case "tools/call":
+		return s.callTool(ctx, req.Params)
+	default:
+		return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+	}
+}
+
+// initialize negotiates the protocol version and advertises the tools capability.
+func (s *Server) initialize(params json.RawMessage) (any, *rpcError) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+	}
+
+	version := ProtocolVersion
+	if supportedProtocolVersions[p.ProtocolVersion] {
+		version = p.ProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities": map[string]any{
+			"tools": map[string]any{"listChanged": false},
+		},
+		"serverInfo": map[string]any{
+			"name":    s.name,
+			"version": s.version,
+		},
+	}, nil
+}
+
+// listTools describes the dispatcher tools in the MCP format.
+func (s *Server) listTools() any {
+	tools := s.dispatcher.Tools()
+
+	out := make([]Tool, 0, len(tools))
+	for _, tool := range tools {
+		out = append(out, Tool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.InputSchema,
+		})
+	}
+
+	return map[string]any{"tools": out}
+}
+
+// callTool executes a tool call through the dispatcher.
+func (s *Server) callTool(ctx context.Context, params json.RawMessage) (any, *rpcError) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	result := s.dispatcher.Dispatch(ctx, pluggo.ToolCall{Name: p.Name, Arguments: p.Arguments})
+	if result.Error != nil && result.Error.Type == pluggo.ToolErrorNotFound && !s.hasTool(p.Name) {
+		return nil, &rpcError{Code: codeInvalidParams, Message: result.Error.Message}
+	}
+
+	callResult := CallToolResult{
+		Content: []Content{{Type: "text", Text: result.Text()}},
+		IsError: result.IsError(),
+	}
+	if !result.IsError() && isObject(result.Content) {
+		callResult.StructuredContent = result.Content
+	}
+
+	return callResult, nil
+}
+
+// hasTool reports whether the dispatcher knows the tool.
+func (s *Server) hasTool(name string) bool {
+	for _, tool := range s.dispatcher.Tools() {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// write serializes a response as a single line.
+func (s *Server) write(w io.Writer, resp response) {
+	resp.JSONRPC = jsonRPCVersion
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		b, _ = json.Marshal(response{
+			JSONRPC: jsonRPCVersion,
+			ID:      resp.ID,
+			Error:   &rpcError{Code: codeInternalError, Message: err.Error()},
+		})
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, _ = w.Write(append(b, '\n'))
+}
+
+// isObject reports whether data holds a JSON object, as required for structured content.
+func isObject(data json.RawMessage) bool {
+	var object map[string]json.RawMessage
+	return json.Unmarshal(data, &object) == nil && object != nil
+}
@@ -0,0 +1,237 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henomis/pluggo"
+)
+
+// pluginEnv makes the test binary serve the test plugin instead of running
+// the tests, so that the dispatcher can launch it as a real plugin.
+const pluginEnv = "PLUGGO_MCP_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		if err := newTestPlugin().Start(); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type greetInput struct {
+	Name string `json:"name" jsonschema:"minLength=1"`
+}
+
+type greetOutput struct {
+	Greeting string `json:"greeting"`
+}
+
+// newTestPlugin creates a plugin serving greet, and slow which answers
+// after 100ms.
+func newTestPlugin() *pluggo.Plugin {
+	greet := func(_ context.Context, in *greetInput) (*greetOutput, error) {
+		return &greetOutput{Greeting: "hello " + in.Name}, nil
+	}
+	slow := func(ctx context.Context, in *greetInput) (*greetOutput, error) {
+		time.Sleep(100 * time.Millisecond)
+		return greet(ctx, in)
+	}
+
+	p := pluggo.NewPlugin(pluggo.WithLogger(slog.New(slog.DiscardHandler)))
+	validator, _ := pluggo.NewValidator(&greetInput{})
+	_ = p.AddFunction("greet", pluggo.NewFunctionHandler(greet, validator).Handler(), pluggo.WithDescription("Greets someone."))
+	_ = p.AddFunction("slow", pluggo.NewFunctionHandler(slow, nil).Handler())
+	return p
+}
+
+// newTestServer launches the test plugin and returns a server exposing its functions.
+func newTestServer(t *testing.T, opts ...ServerOption) *Server {
+	t.Helper()
+	t.Setenv(pluginEnv, "1")
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := pluggo.New(executable)
+	if err := client.Open(context.Background()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	dispatcher := pluggo.NewToolDispatcher()
+	if err := dispatcher.AddClient(client); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+
+	return NewServer(dispatcher, append([]ServerOption{WithServerInfo("test", "1.0.0")}, opts...)...)
+}
+
+// serveSession serves the messages of a session and returns the responses by id.
+func serveSession(t *testing.T, s *Server, messages ...string) map[string]json.RawMessage {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := s.Serve(context.Background(), strings.NewReader(strings.Join(messages, "\n")), &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	responses := make(map[string]json.RawMessage)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var resp struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil || resp.JSONRPC != jsonRPCVersion {
+			t.Fatalf("invalid response %s: %v", line, err)
+		}
+		if _, ok := responses[string(resp.ID)]; ok && string(resp.ID) != "null" {
+			t.Fatalf("several responses to id %s", resp.ID)
+		}
+		responses[string(resp.ID)] = json.RawMessage(line)
+	}
+	return responses
+}
+
+// jsonEqual reports whether got and want hold the same JSON value.
+func jsonEqual(t *testing.T, got json.RawMessage, want string) bool {
+	t.Helper()
+
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+	gb, _ := json.Marshal(g)
+	wb, _ := json.Marshal(w)
+	return bytes.Equal(gb, wb)
+}
+
+func TestServerSession(t *testing.T) {
+	s := newTestServer(t)
+
+	responses := serveSession(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"greet","arguments":{"name":"ada"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"greet","arguments":{"name":""}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"unknown"}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"ping"}`,
+		`{"jsonrpc":"2.0","id":7,"method":"resources/list"}`,
+		`{"jsonrpc":"1.0","id":8,"method":"ping"}`,
+		``,
+		`{"jsonrpc":"2.0","id":9,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`,
+	)
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "1", want: `{"jsonrpc":"2.0","id":1,"result":{"protocolVersion":"2025-03-26","capabilities":{"tools":{"listChanged":false}},"serverInfo":{"name":"test","version":"1.0.0"}}}`},
+		{id: `"call"`, want: `{"jsonrpc":"2.0","id":"call","result":{"content":[{"type":"text","text":"{\"greeting\":\"hello ada\"}"}],"structuredContent":{"greeting":"hello ada"}}}`},
+		{id: "6", want: `{"jsonrpc":"2.0","id":6,"result":{}}`},
+		{id: "7", want: `{"jsonrpc":"2.0","id":7,"error":{"code":-32601,"message":"method \"resources/list\" not found"}}`},
+		{id: "8", want: `{"jsonrpc":"2.0","id":8,"error":{"code":-32600,"message":"unsupported JSON-RPC version"}}`},
+	}
+	for _, tt := range tests {
+		if got, ok := responses[tt.id]; !ok || !jsonEqual(t, got, tt.want) {
+			t.Errorf("response %s = %s, want %s", tt.id, got, tt.want)
+		}
+	}
+
+	var list struct {
+		Result struct {
+			Tools []Tool `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(responses["2"], &list); err != nil {
+		t.Fatal(err)
+	}
+	if tools := list.Result.Tools; len(tools) != 2 || tools[0].Name != "greet" || tools[0].Description != "Greets someone." || tools[0].InputSchema["type"] != "object" {
+		t.Errorf("tools = %+v", tools)
+	}
+
+	var invalid struct {
+		Result CallToolResult `json:"result"`
+	}
+	if err := json.Unmarshal(responses["4"], &invalid); err != nil || !invalid.Result.IsError || invalid.Result.StructuredContent != nil {
+		t.Errorf("call with invalid arguments = %s, want a tool error", responses["4"])
+	}
+
+	var unknown struct {
+		Error rpcError `json:"error"`
+	}
+	if err := json.Unmarshal(responses["5"], &unknown); err != nil || unknown.Error.Code != codeInvalidParams {
+		t.Errorf("call of an unknown tool = %s, want an invalid params error", responses["5"])
+	}
+
+	if got := responses["9"]; !strings.Contains(string(got), `"protocolVersion":"`+ProtocolVersion+`"`) {
+		t.Errorf("initialize with an unknown version = %s, want the latest version", got)
+	}
+	if len(responses) != 9 {
+		t.Errorf("responses = %d, want one per request", len(responses))
+	}
+}
+
+func TestServerRejectsInvalidMessages(t *testing.T) {
+	s := newTestServer(t, WithMaxMessageSize(256))
+	large := strings.Repeat("a", 512)
+
+	responses := serveSession(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"greet","arguments":{"name":"`+large+`"}}}`,
+		`{"jsonrpc":"2.0","method":"tools/call","params":{"name":"greet","arguments":{"name":"ada"}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"greet","arguments":{"name":"ada"}}}`,
+	)
+
+	if got, want := responses["1"], `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"message exceeds 256 bytes"}}`; !jsonEqual(t, got, want) {
+		t.Errorf("oversized message = %s, want %s", got, want)
+	}
+	if got, want := responses["null"], `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"request id is missing"}}`; !jsonEqual(t, got, want) {
+		t.Errorf("request without id = %s, want %s", got, want)
+	}
+	if got := responses["3"]; !strings.Contains(string(got), "hello ada") {
+		t.Errorf("request after the invalid ones = %s, want it served", got)
+	}
+
+	responses = serveSession(t, s, `{"jsonrpc":"2.0","id":1,`, `not json`)
+	if got := responses["null"]; !strings.Contains(string(got), `"code":-32700`) {
+		t.Errorf("malformed message = %s, want a parse error", got)
+	}
+}
+
+func TestServerWaitsForToolCalls(t *testing.T) {
+	s := newTestServer(t)
+
+	start := time.Now()
+	responses := serveSession(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow","arguments":{"name":"ada"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"slow","arguments":{"name":"bob"}}}`,
+	)
+
+	for id, name := range map[string]string{"1": "ada", "2": "bob"} {
+		if got := responses[id]; !strings.Contains(string(got), "hello "+name) {
+			t.Errorf("response %s = %s, want the result of the call", id, got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 190*time.Millisecond {
+		t.Errorf("tool calls took %v, want them executed concurrently", elapsed)
+	}
+}
@@ -0,0 +1,117 @@
+package pluggo
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// semverRegexp matches semantic versions as defined by https://semver.org, with an optional "v" prefix.
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// Example is a sample input/output pair documenting a plugin function.
+type Example struct {
+	Description string          `json:"description,omitempty"`
+	Input       json.RawMessage `json:"input"`
+	Output      json.RawMessage `json:"output,omitempty"`
+}
+
+// example is an example as provided to WithExample, before serialization.
+type example struct {
+	description string
+	input       any
+	output      any
+}
+
+// WithDescription sets a human-readable description of the function.
+func WithDescription(description string) FunctionOption {
+	return func(o *functionOptions) {
+		o.description = description
+	}
+}
+
+// WithVersion sets the semantic version of the function, e.g. "1.2.0".
+// AddFunction fails if version is not a valid semantic version.
+func WithVersion(version string) FunctionOption {
+	return func(o *functionOptions) {
+		o.version = version
+	}
+}
+
+// WithTags attaches tags used to group or filter functions.
+func WithTags(tags ...string) FunctionOption {
+	return func(o *functionOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// WithExample attaches an example input and, optionally, its expected output.
+// Both are serialized to JSON when the function is registered.
+func WithExample(description string, input, output any) FunctionOption {
+	return func(o *functionOptions) {
+		o.examples = append(o.examples, example{description: description, input: input, output: output})
+	}
+}
+
+// WithDeprecation marks the function as deprecated with a notice for its
+// callers, e.g. pointing to its replacement.
+func WithDeprecation(notice string) FunctionOption {
+	return func(o *functionOptions) {
+		o.deprecation = notice
+	}
+}
+
+// WithIdempotent hints that calling the function repeatedly with the same
+// input has the same effect as calling it once.
+func WithIdempotent() FunctionOption {
+	return func(o *functionOptions) {
+		o.idempotent = true
+	}
+}
+
+// WithReadOnly hints that the function has no side effects.
+func WithReadOnly() FunctionOption {
+	return func(o *functionOptions) {
+		o.readOnly = true
+	}
+}
+
+// applyMetadata returns a copy of schema carrying the metadata set by the function options.
+func applyMetadata(schema Schema, options *functionOptions) (Schema, error) {
+	if options.version != "" && !semverRegexp.MatchString(options.version) {
+		return schema, fmt.Errorf("invalid semantic version %q", options.version)
+	}
+
+	schema.Description = options.description
+	schema.Version = options.version
+	schema.Tags = options.tags
+	schema.Deprecation = options.deprecation
+	schema.Idempotent = options.idempotent
+	schema.ReadOnly = options.readOnly
+	schema.Examples = nil
+
+	for _, e := range options.examples {
+		input, err := json.Marshal(e.input)
+		if err != nil {
+			return schema, fmt.Errorf("invalid example input: %w", err)
+		}
+
+		var output []byte
+		if e.output != nil {
+			output, err = json.Marshal(e.output)
+			if err != nil {
+				return schema, fmt.Errorf("invalid example output: %w", err)
+			}
+		}
+
+		schema.Examples = append(schema.Examples, Example{
+			Description: e.description,
+			Input:       input,
+			Output:      output,
+		})
+	}
+
+	return schema, nil
+}
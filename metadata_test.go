@@ -0,0 +1,90 @@
+package pluggo
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestWithVersion(t *testing.T) {
+	for version, valid := range map[string]bool{
+		"1.2.0":                      true,
+		"v1.2.0":                     true,
+		"0.0.1-alpha.1":              true,
+		"1.0.0-rc.1+build.5":         true,
+		"1.0.0+20260101":             true,
+		"1.2":                        false,
+		"01.2.3":                     false,
+		"1.2.3-":                     false,
+		"1.2.3-01":                   false,
+		"latest":                     false,
+		"1.2.3 ":                     false,
+		"1.2.3-beta..1":              false,
+		"1.2.3+build+metadata":       false,
+		"99999.99999.99999-x.7.z.92": true,
+	} {
+		p := newTestPlugin(t)
+		err := p.AddFunction("versioned", NewFunctionHandler(greet, nil).Handler(), WithVersion(version))
+
+		var registration *FunctionRegistrationError
+		switch {
+		case valid && err != nil:
+			t.Errorf("version %q was rejected: %v", version, err)
+		case !valid && !errors.As(err, &registration):
+			t.Errorf("version %q = %v, want a FunctionRegistrationError", version, err)
+		case !valid && p.Schemas()["versioned"].Version != "":
+			t.Errorf("function with invalid version %q was registered", version)
+		}
+	}
+}
+
+func TestFunctionMetadata(t *testing.T) {
+	p := newTestPlugin(t)
+	err := p.AddFunction("documented", NewFunctionHandler(greet, nil).Handler(),
+		WithDescription("Greets someone."),
+		WithVersion("1.2.0"),
+		WithTags("greetings", "demo"),
+		WithExample("greet ada", greetInput{Name: "ada"}, greetOutput{Greeting: "hello ada"}),
+		WithExample("input only", greetInput{Name: "bob"}, nil),
+		WithDeprecation("use greet"),
+		WithIdempotent(),
+		WithReadOnly(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			connection := serve(t, p, protocol)
+
+			schema, err := newTestFunction[greetInput, greetOutput](t, "documented", connection).Schema()
+			if err != nil {
+				t.Fatalf("Schema: %v", err)
+			}
+			if schema.Description != "Greets someone." || schema.Version != "1.2.0" || schema.Deprecation != "use greet" ||
+				!schema.Idempotent || !schema.ReadOnly || !slices.Equal(schema.Tags, []string{"greetings", "demo"}) {
+				t.Errorf("metadata = %+v", schema)
+			}
+			if _, ok := schema.Input["properties"]; !ok {
+				t.Errorf("input schema = %+v, want the generated schema", schema.Input)
+			}
+
+			if len(schema.Examples) != 2 {
+				t.Fatalf("examples = %+v, want 2", schema.Examples)
+			}
+			first, second := schema.Examples[0], schema.Examples[1]
+			if first.Description != "greet ada" || !jsonEqual(first.Input, `{"name":"ada"}`) || !jsonEqual(first.Output, `{"greeting":"hello ada"}`) {
+				t.Errorf("example = %+v", first)
+			}
+			if !jsonEqual(second.Input, `{"name":"bob"}`) || second.Output != nil {
+				t.Errorf("example without output = %+v", second)
+			}
+
+			plain, err := newTestFunction[greetInput, greetOutput](t, "greet", connection).Schema()
+			if err != nil || plain.Description != "" || plain.Version != "" || plain.Examples != nil {
+				t.Errorf("schema without metadata = %+v, %v", plain, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package pluggo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric names recorded by Client and Function.
+const (
+	MetricClientCalls               = "pluggo_client_calls_total"
+	MetricClientCallDuration        = "pluggo_client_call_duration_seconds"
+	MetricClientRequestBytes        = "pluggo_client_request_bytes_total"
+	MetricClientResponseBytes       = "pluggo_client_response_bytes_total"
+	MetricClientErrors              = "pluggo_client_errors_total"
+	MetricClientRetries             = "pluggo_client_retries_total"
+	MetricClientRestarts            = "pluggo_client_restarts_total"
+	MetricClientHealthCheckFailures = "pluggo_client_health_check_failures_total"
+)
+
+// Metric names recorded by Plugin.
+const (
+	MetricPluginRequests         = "pluggo_plugin_requests_total"
+	MetricPluginRequestsInFlight = "pluggo_plugin_requests_in_flight"
+	MetricPluginHandlerDuration  = "pluggo_plugin_handler_duration_seconds"
+)
+
+// Error classes used as the "class" label of MetricClientErrors.
+const (
+	ErrorClassEncode            = "encode"
+	ErrorClassTransport         = "transport"
+	ErrorClassTimeout           = "timeout"
+	ErrorClassClient            = "http_4xx"
+	ErrorClassServer            = "http_5xx"
+	ErrorClassDecode            = "decode"
+	ErrorClassPanic             = "panic"
+	ErrorClassCircuitOpen       = "circuit_open"
+	ErrorClassBusy              = "busy"
+	ErrorClassContractViolation = "contract_violation"
+)
+
+var metricHelp = map[string]string{
+	MetricClientCalls:               "Total number of plugin function calls.",
+	MetricClientCallDuration:        "Latency of plugin function calls in seconds.",
+	MetricClientRequestBytes:        "Total bytes of request payloads sent to plugin functions.",
+	MetricClientResponseBytes:       "Total bytes of response payloads received from plugin functions.",
+	MetricClientErrors:              "Total number of failed plugin function calls by error class.",
+	MetricClientRetries:             "Total number of retried plugin function calls by error class.",
+	MetricClientRestarts:            "Total number of plugin process restarts.",
+	MetricClientHealthCheckFailures: "Total number of failed plugin health checks.",
+	MetricPluginRequests:            "Total number of function requests handled by the plugin.",
+	MetricPluginRequestsInFlight:    "Number of function requests currently being handled by the plugin.",
+	MetricPluginHandlerDuration:     "Latency of plugin function handlers in seconds.",
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// DefaultBuckets are the histogram buckets, in seconds, used by MetricsRegistry.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Labels identifies a single time series of a metric.
+type Labels map[string]string
+
+// Metrics is the hook used to instrument clients and plugins.
+// Implementations can forward to any metrics backend; MetricsRegistry is a
+// dependency-free implementation exposing the Prometheus text format.
+type Metrics interface {
+	// IncCounter adds delta to the counter identified by name and labels.
+	IncCounter(name string, labels Labels, delta float64)
+	// AddGauge adds delta, which may be negative, to the gauge identified by name and labels.
+	AddGauge(name string, labels Labels, delta float64)
+	// Observe records value in the histogram identified by name and labels.
+	Observe(name string, labels Labels, value float64)
+}
+
+type metricKind int
+
+const (
+	metricCounter metricKind = iota
+	metricGauge
+	metricHistogram
+)
+
+// String returns the Prometheus type name of the metric kind.
+func (k metricKind) String() string {
+	switch k {
+	case metricGauge:
+		return "gauge"
+	case metricHistogram:
+		return "histogram"
+	default:
+		return "counter"
+	}
+}
+
+type series struct {
+	labels  string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type family struct {
+	kind   metricKind
+	series map[string]*series
+}
+
+// MetricsRegistry is an in-memory Metrics implementation that renders its
+// metrics in the Prometheus text exposition format. A metric name keeps the
+// kind it was first recorded with: recording it as another kind is ignored.
+type MetricsRegistry struct {
+	mu       sync.Mutex
+	buckets  []float64
+	families map[string]*family
+}
+
+var _ Metrics = (*MetricsRegistry)(nil)
+
+// NewMetricsRegistry creates an empty registry using DefaultBuckets for histograms.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		buckets:  DefaultBuckets,
+		families: make(map[string]*family),
+	}
+}
+
+// IncCounter adds delta to the counter identified by name and labels.
+func (m *MetricsRegistry) IncCounter(name string, labels Labels, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s := m.series(name, metricCounter, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// AddGauge adds delta to the gauge identified by name and labels.
+func (m *MetricsRegistry) AddGauge(name string, labels Labels, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s := m.series(name, metricGauge, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// Observe records value in the histogram identified by name and labels.
+func (m *MetricsRegistry) Observe(name string, labels Labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.series(name, metricHistogram, labels)
+	if s == nil {
+		return
+	}
+	for i, bound := range m.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Value returns the current value of a counter or gauge, or the number of
+// observations of a histogram. It returns 0 for unknown series.
+func (m *MetricsRegistry) Value(name string, labels Labels) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.families[name]
+	if !ok {
+		return 0
+	}
+	s, ok := f.series[formatLabels(labels)]
+	if !ok {
+		return 0
+	}
+	if f.kind == metricHistogram {
+		return float64(s.count)
+	}
+	return s.value
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format.
+func (m *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m.families[name]
+
+		if help, ok := metricHelp[name]; ok {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != metricHistogram {
+				fmt.Fprintf(bw, "%s%s %s\n", name, wrapLabels(s.labels), formatFloat(s.value))
+				continue
+			}
+
+			for i, bound := range m.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(s.labels, "le", formatFloat(bound))), s.buckets[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(s.labels, "le", "+Inf")), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, wrapLabels(s.labels), formatFloat(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, wrapLabels(s.labels), s.count)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := m.WritePrometheus(w); err != nil {
+		contextLogger(r.Context()).Error("failed to write metrics", "error", err)
+	}
+}
+
+// series returns the series identified by name and labels, creating it if
+// needed. It returns nil if name is a metric of another kind.
+// The caller must hold m.mu.
+func (m *MetricsRegistry) series(name string, kind metricKind, labels Labels) *series {
+	f, ok := m.families[name]
+	if !ok {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		m.families[name] = f
+	}
+	if f.kind != kind {
+		return nil
+	}
+
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: key}
+		if kind == metricHistogram {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// formatLabels renders labels as a sorted, comma-separated list of name="value" pairs.
+func formatLabels(labels Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+quoteLabel(labels[name]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// joinLabels appends a single name="value" pair to formatted labels.
+func joinLabels(labels, name, value string) string {
+	pair := name + "=" + quoteLabel(value)
+	if labels == "" {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+// quoteLabel quotes a label value, escaping it as required by the text format.
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// wrapLabels encloses formatted labels in braces, omitting them when empty.
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// formatFloat renders a sample value the way Prometheus expects it.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// errorClass maps a transport error to the class reported in MetricClientErrors.
+func errorClass(err error) string {
+	var tooLarge *ResponseTooLargeError
+	if errors.As(err, &tooLarge) {
+		return ErrorClassDecode
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+	return ErrorClassTransport
+}
+
+// statusClass maps a non-200 HTTP status to the class reported in MetricClientErrors.
+func statusClass(status int) string {
+	if status >= 500 {
+		return ErrorClassServer
+	}
+	return ErrorClassClient
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the response status.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status and forwards it to the underlying writer.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status and forwards data to the underlying writer.
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the underlying writer, for use by http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,112 @@
+package pluggo
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRegistryPrometheus(t *testing.T) {
+	m := NewMetricsRegistry()
+	m.buckets = []float64{0.1, 1}
+
+	m.IncCounter("calls_total", Labels{"function": "greet", "plugin": `say "hi"`}, 1)
+	m.IncCounter("calls_total", Labels{"plugin": `say "hi"`, "function": "greet"}, 2)
+	m.AddGauge("in_flight", nil, 2)
+	m.AddGauge("in_flight", nil, -1)
+	m.Observe("duration_seconds", Labels{"function": "greet"}, 0.05)
+	m.Observe("duration_seconds", Labels{"function": "greet"}, 0.5)
+	m.Observe("duration_seconds", Labels{"function": "greet"}, 2)
+
+	// Recording a name as another kind is ignored
+	m.Observe("calls_total", nil, 1)
+	m.IncCounter("duration_seconds", Labels{"function": "greet"}, 1)
+	m.AddGauge("calls_total", nil, 1)
+
+	m.IncCounter(MetricClientRestarts, nil, 1)
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# TYPE calls_total counter
+calls_total{function="greet",plugin="say \"hi\""} 3
+# TYPE duration_seconds histogram
+duration_seconds_bucket{function="greet",le="0.1"} 1
+duration_seconds_bucket{function="greet",le="1"} 2
+duration_seconds_bucket{function="greet",le="+Inf"} 3
+duration_seconds_sum{function="greet"} 2.55
+duration_seconds_count{function="greet"} 3
+# TYPE in_flight gauge
+in_flight 1
+# HELP pluggo_client_restarts_total Total number of plugin process restarts.
+# TYPE pluggo_client_restarts_total counter
+pluggo_client_restarts_total 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WritePrometheus =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := m.Value("duration_seconds", Labels{"function": "greet"}); got != 3 {
+		t.Errorf("histogram value = %v, want the number of observations", got)
+	}
+	if got := m.Value("unknown", nil); got != 0 {
+		t.Errorf("unknown series value = %v, want 0", got)
+	}
+}
+
+func TestMetricsInstrumentation(t *testing.T) {
+	pluginMetrics := NewMetricsRegistry()
+	p := newTestPlugin(t, WithPluginMetrics(pluginMetrics))
+	silent := &Handler{HTTPHandler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})}
+	if err := p.AddFunction("silent", silent); err != nil {
+		t.Fatal(err)
+	}
+
+	clientMetrics := NewMetricsRegistry()
+	connection := serve(t, p, ProtocolREST)
+	connection.Plugin = "test"
+	connection.Metrics = clientMetrics
+	f := newTestFunction[greetInput, greetOutput](t, "greet", connection)
+
+	if _, err := f.Call(&greetInput{Name: "ada"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Call(&greetInput{}); err == nil {
+		t.Fatal("invalid input was accepted")
+	}
+
+	resp, err := http.Post(connection.BaseURL+"/silent", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	function := Labels{"function": "greet"}
+	client := Labels{"plugin": "test", "function": "greet"}
+	for _, tt := range []struct {
+		name    string
+		metrics *MetricsRegistry
+		metric  string
+		labels  Labels
+		want    float64
+	}{
+		{name: "successful requests", metrics: pluginMetrics, metric: MetricPluginRequests, labels: Labels{"function": "greet", "status": "200"}, want: 1},
+		{name: "rejected requests", metrics: pluginMetrics, metric: MetricPluginRequests, labels: Labels{"function": "greet", "status": "400"}, want: 1},
+		{name: "handlers writing nothing", metrics: pluginMetrics, metric: MetricPluginRequests, labels: Labels{"function": "silent", "status": "200"}, want: 1},
+		{name: "requests in flight", metrics: pluginMetrics, metric: MetricPluginRequestsInFlight, labels: function, want: 0},
+		{name: "handler durations", metrics: pluginMetrics, metric: MetricPluginHandlerDuration, labels: function, want: 2},
+		{name: "calls", metrics: clientMetrics, metric: MetricClientCalls, labels: client, want: 2},
+		{name: "call durations", metrics: clientMetrics, metric: MetricClientCallDuration, labels: client, want: 2},
+		{name: "errors", metrics: clientMetrics, metric: MetricClientErrors, labels: Labels{"plugin": "test", "function": "greet", "class": ErrorClassClient}, want: 1},
+	} {
+		if got := tt.metrics.Value(tt.metric, tt.labels); got != tt.want {
+			t.Errorf("%s: %s%v = %v, want %v", tt.name, tt.metric, tt.labels, got, tt.want)
+		}
+	}
+	if got := clientMetrics.Value(MetricClientRequestBytes, client); got == 0 {
+		t.Error("request bytes were not recorded")
+	}
+}
@@ -0,0 +1,301 @@
+package pluggo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const errorCodeTimeout = "timeout"
+
+// CallInfo describes a single invocation of a plugin function as seen by middleware.
+// Input holds the raw request body; middleware may replace it before calling
+// the next handler. For requests with attachments, Input holds the function
+// input sent next to a single attachment, and is nil for multipart requests:
+// attachments are streamed to the function without being buffered.
+type CallInfo struct {
+	Function string
+	Input    []byte
+	Schema   Schema
+}
+
+// CallHandler handles an invocation of a plugin function.
+type CallHandler func(w http.ResponseWriter, r *http.Request, call *CallInfo)
+
+// Middleware wraps a CallHandler to add cross-cutting behavior such as
+// authentication, logging or rate limiting.
+type Middleware func(next CallHandler) CallHandler
+
+// WithMiddleware adds middleware that only applies to the registered function.
+// It runs after the plugin-wide middleware added with Plugin.Use.
+func WithMiddleware(middleware ...Middleware) FunctionOption {
+	return func(o *functionOptions) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// Use adds middleware applied to every function of the plugin, including the
+// ones already registered. Middleware runs in the order it was added.
+func (l *Plugin) Use(middleware ...Middleware) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.middleware = append(l.middleware, middleware...)
+}
+
+// LoggingMiddleware logs every function invocation with the plugin logger.
+func (l *Plugin) LoggingMiddleware() Middleware {
+	return func(next CallHandler) CallHandler {
+		return func(w http.ResponseWriter, r *http.Request, call *CallInfo) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next(recorder, r, call)
+
+			l.logger.Info("function called",
+				"function", call.Function,
+				"status", recorder.status,
+				"input_bytes", len(call.Input),
+				"duration", time.Since(start),
+			)
+		}
+	}
+}
+
+// RecoveryMiddleware recovers panics raised by the next handlers, including
+// other middleware, and reports them to the client as a PluginPanicError
+// instead of dropping the connection.
+func RecoveryMiddleware() Middleware {
+	return func(next CallHandler) CallHandler {
+		return func(w http.ResponseWriter, r *http.Request, call *CallInfo) {
+			defer func() {
+				if v := recover(); v != nil {
+					writePanic(w, contextLogger(r.Context()), call.Function, v, false)
+				}
+			}()
+
+			next(w, r, call)
+		}
+	}
+}
+
+// TimeoutMiddleware bounds the execution time of a function. The request
+// context is cancelled after timeout and the caller receives a 503 Service
+// Unavailable response, surfacing as a FunctionTimeoutError, even if the
+// function doesn't honor the context. Responses are not buffered: progress
+// and output streamed before the timeout reach the caller unchanged.
+// Functions still running after the timeout keep counting against the
+// concurrency limits of the plugin until they return, and their panics are
+// reported with the stack of the function.
+func TimeoutMiddleware(timeout time.Duration) Middleware {
+	return func(next CallHandler) CallHandler {
+		return func(w http.ResponseWriter, r *http.Request, call *CallInfo) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			// The function may outlive the call: it keeps holding the
+			// concurrency slot of the call until it returns
+			slot, _ := r.Context().Value(callSlotKey{}).(*callSlot)
+			slot.hold()
+
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx, header: w.Header().Clone()}
+			done := make(chan any, 1)
+			go func() {
+				defer func() {
+					v := recover()
+					if _, ok := v.(*recoveredPanic); v != nil && !ok {
+						v = &recoveredPanic{value: v, stack: debug.Stack()}
+					}
+					slot.done()
+					done <- v
+				}()
+				next(tw, r.WithContext(ctx), call)
+			}()
+
+			select {
+			case v := <-done:
+				if v != nil {
+					panic(v)
+				}
+			case <-ctx.Done():
+			}
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				tw.writeTimeout(call.Function, timeout)
+				return
+			}
+			tw.finish()
+		}
+	}
+}
+
+// writeTimeout rejects a call that exceeded its execution time.
+func writeTimeout(w http.ResponseWriter, function string, timeout time.Duration) {
+	w.Header().Set(errorCodeHeader, errorCodeTimeout)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = fmt.Fprintf(w, "function %q timed out after %s", function, timeout)
+}
+
+// timeoutWriter is the http.ResponseWriter of a call bounded by
+// TimeoutMiddleware. Once the deadline of the call is exceeded, the response
+// is handed to the timeout error unless the function already started it,
+// and later writes of the function fail with http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+
+	mu          sync.Mutex
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+// Header returns the header of the response, sent with the first write.
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader sends the response header unless the call timed out.
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.expired() || w.wroteHeader {
+		return
+	}
+	w.writeHeader(status)
+}
+
+// Write writes data to the response unless the call timed out.
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends the data written so far to the caller.
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.timedOut {
+		_ = http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// writeTimeout sends the timeout error, unless the function already started
+// the response.
+func (w *timeoutWriter) writeTimeout(function string, timeout time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.timedOut = true
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		writeTimeout(w.ResponseWriter, function, timeout)
+	}
+}
+
+// finish sends the header set by a function that returned without writing
+// its response.
+func (w *timeoutWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+}
+
+// expired reports whether the call timed out before starting the response.
+// The caller must hold w.mu.
+func (w *timeoutWriter) expired() bool {
+	if !w.wroteHeader && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+// writeHeader copies the header of the function and sends it with status.
+// The caller must hold w.mu.
+func (w *timeoutWriter) writeHeader(status int) {
+	w.wroteHeader = true
+	for key, values := range w.header {
+		w.ResponseWriter.Header()[key] = values
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// chain wraps a function handler with the plugin-wide and function middleware.
+// The request body is read once into CallInfo.Input and handed back to the
+// function handler, so middleware can inspect or replace it.
+func (l *Plugin) chain(functionName string, schema Schema, middleware []Middleware, handler http.Handler) http.Handler {
+	final := func(w http.ResponseWriter, r *http.Request, call *CallInfo) {
+		switch {
+		case r.Header.Get(attachmentHeader) != "":
+			r.Header.Set(inputHeader, base64.StdEncoding.EncodeToString(call.Input))
+		case !hasAttachments(r):
+			r.Body = io.NopCloser(bytes.NewReader(call.Input))
+			r.ContentLength = int64(len(call.Input))
+		}
+		handler.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			input []byte
+			err   error
+		)
+		switch {
+		case r.Header.Get(attachmentHeader) != "":
+			input, err = rawInput(r)
+		case !hasAttachments(r):
+			input, err = io.ReadAll(r.Body)
+			_ = r.Body.Close()
+		}
+		if err != nil {
+			l.logger.Warn("failed to read input", "function", functionName, "error", err)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writePayloadTooLarge(w, maxBytesErr.Limit)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		l.mu.RLock()
+		pluginMiddleware := l.middleware
+		l.mu.RUnlock()
+
+		next := CallHandler(final)
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		for i := len(pluginMiddleware) - 1; i >= 0; i-- {
+			next = pluginMiddleware[i](next)
+		}
+
+		next(w, r, &CallInfo{
+			Function: functionName,
+			Input:    input,
+			Schema:   schema,
+		})
+	})
+}
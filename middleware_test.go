@@ -0,0 +1,216 @@
+package pluggo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingLogger is a slog.Handler keeping the messages and attributes of
+// the records it handles.
+type recordingLogger struct {
+	mu      sync.Mutex
+	records []map[string]any
+}
+
+func (l *recordingLogger) Enabled(context.Context, slog.Level) bool { return true }
+
+func (l *recordingLogger) Handle(_ context.Context, record slog.Record) error {
+	attrs := map[string]any{"msg": record.Message}
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value.Any()
+		return true
+	})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, attrs)
+	return nil
+}
+
+func (l *recordingLogger) WithAttrs([]slog.Attr) slog.Handler { return l }
+
+func (l *recordingLogger) WithGroup(string) slog.Handler { return l }
+
+// waitFor waits until a record with msg was logged and returns it.
+func (l *recordingLogger) waitFor(t *testing.T, msg string) map[string]any {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		i := slices.IndexFunc(l.records, func(attrs map[string]any) bool { return attrs["msg"] == msg })
+		var record map[string]any
+		if i >= 0 {
+			record = l.records[i]
+		}
+		l.mu.Unlock()
+		if record != nil {
+			return record
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %q record logged", msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// tagging returns middleware appending tag to the name of the input and to trace.
+func tagging(tag string, trace *[]string) Middleware {
+	return func(next CallHandler) CallHandler {
+		return func(w http.ResponseWriter, r *http.Request, call *CallInfo) {
+			*trace = append(*trace, tag)
+			call.Input = bytes.Replace(call.Input, []byte(`"}`), []byte(tag+`"}`), 1)
+			next(w, r, call)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var trace []string
+	p := newTestPlugin(t)
+	p.Use(tagging("1", &trace))
+	if err := p.AddFunction("tagged", NewFunctionHandler(greet, nil).Handler(), WithMiddleware(tagging("3", &trace))); err != nil {
+		t.Fatal(err)
+	}
+	p.Use(tagging("2", &trace))
+
+	connection := serve(t, p, ProtocolREST)
+	out, err := newTestFunction[greetInput, greetOutput](t, "tagged", connection).Call(&greetInput{Name: "ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Greeting != "hello ada123" || !slices.Equal(trace, []string{"1", "2", "3"}) {
+		t.Errorf("greeting = %q after middleware %q, want plugin middleware in order, then function middleware", out.Greeting, trace)
+	}
+
+	trace = nil
+	if out, err := newTestFunction[greetInput, greetOutput](t, "greet", connection).Call(&greetInput{Name: "ada"}); err != nil || out.Greeting != "hello ada12" {
+		t.Errorf("function without its own middleware = %v, %v", out, err)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	logger := &recordingLogger{}
+	p := newTestPlugin(t, WithLogger(slog.New(logger)))
+	p.Use(p.LoggingMiddleware())
+
+	f := newTestFunction[greetInput, greetOutput](t, "greet", serve(t, p, ProtocolREST))
+	if _, err := f.Call(&greetInput{}); err == nil {
+		t.Fatal("invalid input was accepted")
+	}
+
+	record := logger.waitFor(t, "function called")
+	if record["function"] != "greet" || record["status"] != int64(http.StatusBadRequest) || record["input_bytes"] != int64(len(`{"name":""}`)) {
+		t.Errorf("logged call = %v", record)
+	}
+}
+
+func TestHandlerLogging(t *testing.T) {
+	logger := &recordingLogger{}
+	p := newTestPlugin(t, WithLogger(slog.New(logger)))
+	if err := p.AddFunction("crash", crashing()); err != nil {
+		t.Fatal(err)
+	}
+	addEcho(t, p, WithHandlerOutputValidation())
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			connection := serve(t, p, protocol)
+			_, _ = newTestFunction[greetInput, greetOutput](t, "greet", connection).Call(&greetInput{})
+			_, _ = newTestFunction[greetInput, greetOutput](t, "crash", connection).Call(&greetInput{})
+			_, _ = newTestFunction[greetInput, contractOutput](t, "echo", connection).Call(&greetInput{})
+
+			for msg, function := range map[string]string{"invalid input": "greet", "function panicked": "crash", "invalid output": "echo"} {
+				if record := logger.waitFor(t, msg); record["function"] != function {
+					t.Errorf("%s record = %v, want function %s", msg, record, function)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	p := newTestPlugin(t)
+	p.Use(RecoveryMiddleware(), func(CallHandler) CallHandler {
+		return func(http.ResponseWriter, *http.Request, *CallInfo) {
+			panic("middleware failed")
+		}
+	})
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			_, err := newTestFunction[greetInput, greetOutput](t, "greet", serve(t, p, protocol)).Call(&greetInput{Name: "ada"})
+			var panicErr *PluginPanicError
+			if !errors.As(err, &panicErr) || panicErr.Function != "greet" || panicErr.Value != "middleware failed" {
+				t.Errorf("error = %v, want a PluginPanicError", err)
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	release := make(chan struct{})
+	p := newTestPlugin(t)
+	started := addGatedFunction(t, p, release,
+		WithMiddleware(TimeoutMiddleware(50*time.Millisecond)),
+		WithFunctionConcurrencyLimit(ConcurrencyLimit{MaxConcurrent: 1}),
+	)
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			f := newTestFunction[greetInput, greetOutput](t, "gated", serve(t, p, protocol))
+
+			_, err := f.Call(&greetInput{Name: "ada"})
+			var timeout *FunctionTimeoutError
+			if !errors.As(err, &timeout) || timeout.Function != "gated" {
+				t.Fatalf("error = %v, want a FunctionTimeoutError", err)
+			}
+			<-started
+
+			var busy *PluginBusyError
+			if _, err := f.Call(&greetInput{Name: "bob"}); !errors.As(err, &busy) {
+				t.Errorf("call while the timed out function runs = %v, want a PluginBusyError", err)
+			}
+
+			release <- struct{}{}
+			deadline := time.Now().Add(5 * time.Second)
+			for p.Schemas()["gated"].Concurrency[0].InFlight != 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("the slot of the timed out call was not released")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddlewarePanicStack(t *testing.T) {
+	handler := TimeoutMiddleware(time.Minute)(func(http.ResponseWriter, *http.Request, *CallInfo) {
+		panicInFunction()
+	})
+
+	defer func() {
+		p, ok := recover().(*recoveredPanic)
+		if !ok || p.value != "function failed" {
+			t.Fatalf("recovered %v, want the panic of the function", p)
+		}
+		if !strings.Contains(string(p.stack), "panicInFunction") {
+			t.Errorf("stack does not come from the function:\n%s", p.stack)
+		}
+	}()
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/greet", nil), &CallInfo{Function: "greet"})
+}
+
+func panicInFunction() {
+	panic("function failed")
+}
@@ -0,0 +1,226 @@
+package pluggo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Kinds of a NormalizationChange.
+const (
+	NormalizationDefault  = "default"
+	NormalizationCoercion = "coercion"
+)
+
+// WithDefaults fills the properties missing from the inputs with the default
+// of their schema, e.g. set with the `jsonschema:"default=10"` tag, before
+// validating them.
+func WithDefaults() ValidatorOption {
+	return func(o *validatorOptions) {
+		o.defaults = true
+	}
+}
+
+// WithCoercion converts the strings of the inputs to the numbers or booleans
+// expected by their schema, e.g. "10" to 10 and "true" to true, before
+// validating them. Strings that can't be converted are left untouched.
+func WithCoercion() ValidatorOption {
+	return func(o *validatorOptions) {
+		o.coercion = true
+	}
+}
+
+// NormalizationChange is a change made to an input by a Validator.
+type NormalizationChange struct {
+	// Path is the JSON pointer of the changed value, e.g. /page/size.
+	Path string `json:"path"`
+	// Kind is NormalizationDefault or NormalizationCoercion.
+	Kind string `json:"kind"`
+	// From is the original value, unset for defaults.
+	From any `json:"from,omitempty"`
+	// To is the value after the change.
+	To any `json:"to"`
+}
+
+// NormalizationReport lists the changes made to an input before decoding it.
+type NormalizationReport struct {
+	Changes []NormalizationChange `json:"changes"`
+}
+
+// normalizationReportKey is the context key of the normalization report of a call.
+type normalizationReportKey struct{}
+
+// NormalizationReportFromContext returns the report of the normalization of
+// the input of the function call carrying ctx. It is only set when the
+// validator of the function normalizes inputs.
+func NormalizationReportFromContext(ctx context.Context) (*NormalizationReport, bool) {
+	report, ok := ctx.Value(normalizationReportKey{}).(*NormalizationReport)
+	return report, ok
+}
+
+// withNormalizationReport returns a context carrying the normalization report of a call.
+func withNormalizationReport(ctx context.Context, report *NormalizationReport) context.Context {
+	return context.WithValue(ctx, normalizationReportKey{}, report)
+}
+
+// Normalize applies the defaults and coercions enabled by the options of the
+// validator to a JSON input, then validates the result. It returns the
+// normalized input and the report of the changes.
+func (v *Validator[T]) Normalize(data []byte) ([]byte, *NormalizationReport, error) {
+	report := &NormalizationReport{Changes: []NormalizationChange{}}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var document any
+	if err := dec.Decode(&document); err != nil {
+		return nil, nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	document = v.normalize(v.document, document, "", report)
+
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := v.validate(normalized); err != nil {
+		return nil, nil, err
+	}
+	return normalized, report, nil
+}
+
+// check validates a JSON input, normalizing it first when the options of the
+// validator ask to. The report is nil when inputs are not normalized.
+func (v *Validator[T]) check(data []byte) ([]byte, *NormalizationReport, error) {
+	if !v.options.defaults && !v.options.coercion {
+		return data, nil, v.validate(data)
+	}
+	return v.Normalize(data)
+}
+
+// normalize applies the defaults and coercions of schema to value, found at
+// path, recording the changes in report.
+func (v *Validator[T]) normalize(schema map[string]any, value any, path string, report *NormalizationReport) any {
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(properties)) {
+			propertySchema, ok := properties[name].(map[string]any)
+			if !ok {
+				continue
+			}
+			propertyPath := path + "/" + escapePointer(name)
+
+			current, ok := value[name]
+			if !ok {
+				def, hasDefault := propertySchema["default"]
+				if v.options.defaults && hasDefault {
+					value[name] = cloneJSON(def)
+					report.Changes = append(report.Changes, NormalizationChange{Path: propertyPath, Kind: NormalizationDefault, To: cloneJSON(def)})
+				}
+				continue
+			}
+			value[name] = v.normalize(propertySchema, current, propertyPath, report)
+		}
+		return value
+
+	case []any:
+		items, ok := schema["items"].(map[string]any)
+		if !ok {
+			return value
+		}
+		for i, item := range value {
+			value[i] = v.normalize(items, item, path+"/"+strconv.Itoa(i), report)
+		}
+		return value
+
+	case string:
+		if !v.options.coercion {
+			return value
+		}
+		if coerced, ok := coerce(value, schemaTypes(schema)); ok {
+			report.Changes = append(report.Changes, NormalizationChange{Path: path, Kind: NormalizationCoercion, From: value, To: coerced})
+			return coerced
+		}
+		return value
+
+	default:
+		return value
+	}
+}
+
+// schemaTypes returns the types allowed by a schema.
+func schemaTypes(schema map[string]any) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	default:
+		return nil
+	}
+}
+
+// coerce converts a string to the first of types it can be converted to.
+// Strings allowed by the schema are never converted.
+func coerce(s string, types []string) (any, bool) {
+	if slices.Contains(types, "string") {
+		return nil, false
+	}
+
+	for _, t := range types {
+		switch t {
+		case "integer":
+			if i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+				return i, true
+			}
+		case "number":
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return f, true
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// cloneJSON returns a deep copy of a decoded JSON value, so that defaults
+// are never shared between inputs.
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		clone := make(map[string]any, len(v))
+		for key, value := range v {
+			clone[key] = cloneJSON(value)
+		}
+		return clone
+	case []any:
+		clone := make([]any, len(v))
+		for i, value := range v {
+			clone[i] = cloneJSON(value)
+		}
+		return clone
+	default:
+		return v
+	}
+}
+
+// escapePointer escapes a property name for use in a JSON pointer.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
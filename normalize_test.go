@@ -0,0 +1,184 @@
+package pluggo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type searchInput struct {
+	Query  string      `json:"query" jsonschema:"minLength=1"`
+	Limit  int         `json:"limit,omitempty" jsonschema:"default=10,maximum=50"`
+	Exact  bool        `json:"exact,omitempty"`
+	Page   *searchPage `json:"page,omitempty"`
+	Scores []float64   `json:"scores,omitempty"`
+}
+
+type searchPage struct {
+	Number int `json:"number,omitempty" jsonschema:"default=1"`
+}
+
+func TestNormalize(t *testing.T) {
+	defaults := []ValidatorOption{WithDefaults()}
+	coercion := []ValidatorOption{WithCoercion()}
+	both := []ValidatorOption{WithDefaults(), WithCoercion()}
+
+	tests := []struct {
+		name        string
+		opts        []ValidatorOption
+		input       string
+		want        string
+		wantChanges string
+		wantInvalid bool
+	}{
+		{
+			name:        "defaults",
+			opts:        defaults,
+			input:       `{"query":"go","page":{}}`,
+			want:        `{"query":"go","limit":10,"page":{"number":1}}`,
+			wantChanges: `[{"path":"/limit","kind":"default","to":10},{"path":"/page/number","kind":"default","to":1}]`,
+		},
+		{
+			name:        "present values keep their value",
+			opts:        defaults,
+			input:       `{"query":"go","limit":20}`,
+			want:        `{"query":"go","limit":20}`,
+			wantChanges: `[]`,
+		},
+		{
+			name:  "coercion",
+			opts:  coercion,
+			input: `{"query":"42","limit":" 20 ","exact":"true","page":{"number":"3"},"scores":["1.5","2"]}`,
+			want:  `{"query":"42","limit":20,"exact":true,"page":{"number":3},"scores":[1.5,2]}`,
+			wantChanges: `[
+				{"path":"/exact","kind":"coercion","from":"true","to":true},
+				{"path":"/limit","kind":"coercion","from":" 20 ","to":20},
+				{"path":"/page/number","kind":"coercion","from":"3","to":3},
+				{"path":"/scores/0","kind":"coercion","from":"1.5","to":1.5},
+				{"path":"/scores/1","kind":"coercion","from":"2","to":2}
+			]`,
+		},
+		{
+			name:        "defaults and coercion",
+			opts:        both,
+			input:       `{"query":"go","exact":"false"}`,
+			want:        `{"query":"go","limit":10,"exact":false}`,
+			wantChanges: `[{"path":"/exact","kind":"coercion","from":"false","to":false},{"path":"/limit","kind":"default","to":10}]`,
+		},
+		{name: "coerced values are validated", opts: coercion, input: `{"query":"go","limit":"100"}`, wantInvalid: true},
+		{name: "invalid strings are left untouched", opts: coercion, input: `{"query":"go","limit":"ten"}`, wantInvalid: true},
+		{name: "coercion is opt-in", opts: defaults, input: `{"query":"go","limit":"20"}`, wantInvalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := NewValidator(&searchInput{}, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			normalized, report, err := validator.Normalize([]byte(tt.input))
+			if tt.wantInvalid {
+				var validation *ValidationError
+				if !errors.As(err, &validation) {
+					t.Fatalf("Normalize = %s, %v, want a ValidationError", normalized, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize: %v", err)
+			}
+
+			if !jsonEqual(normalized, tt.want) {
+				t.Errorf("normalized = %s, want %s", normalized, tt.want)
+			}
+			changes, _ := json.Marshal(report.Changes)
+			if !jsonEqual(changes, tt.wantChanges) {
+				t.Errorf("changes = %s, want %s", changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+type searchOutput struct {
+	Input   searchInput           `json:"input"`
+	Changes []NormalizationChange `json:"changes"`
+	Report  bool                  `json:"report"`
+}
+
+// search returns its input and the normalization report of its context.
+func search(ctx context.Context, in *searchInput) (*searchOutput, error) {
+	out := &searchOutput{Input: *in}
+	if report, ok := NormalizationReportFromContext(ctx); ok {
+		out.Report, out.Changes = true, report.Changes
+	}
+	return out, nil
+}
+
+func TestHandlerNormalizesInputs(t *testing.T) {
+	p := newTestPlugin(t)
+	for name, opts := range map[string][]ValidatorOption{
+		"search":       {WithDefaults(), WithCoercion()},
+		"search.plain": nil,
+	} {
+		validator, err := NewValidator(&searchInput{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.AddFunction(name, NewFunctionHandler(search, validator).Handler()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	input := map[string]any{"query": "go", "exact": "true", "scores": []any{"0.5"}}
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			connection := serve(t, p, protocol)
+
+			out, err := newTestFunction[map[string]any, searchOutput](t, "search", connection).Call(&input)
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if out.Input.Limit != 10 || !out.Input.Exact || len(out.Input.Scores) != 1 || out.Input.Scores[0] != 0.5 {
+				t.Errorf("normalized input = %+v", out.Input)
+			}
+			if !out.Report || len(out.Changes) != 3 {
+				t.Errorf("normalization report = %t %+v, want 3 changes", out.Report, out.Changes)
+			}
+
+			_, err = newTestFunction[map[string]any, searchOutput](t, "search.plain", connection).Call(&input)
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("input without normalization = %v, want a ValidationError", err)
+			}
+
+			out, err = newTestFunction[map[string]any, searchOutput](t, "search.plain", connection).Call(&map[string]any{"query": "go"})
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if out.Report || out.Input.Limit != 0 {
+				t.Errorf("input without normalization = %+v, report %t", out.Input, out.Report)
+			}
+		})
+	}
+}
+
+func TestNormalizeCopiesDefaults(t *testing.T) {
+	validator, err := NewValidator(&searchInput{}, WithDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := map[string]any{"properties": map[string]any{
+		"tags": map[string]any{"default": []any{"go"}},
+	}}
+
+	report := &NormalizationReport{}
+	value := validator.normalize(schema, map[string]any{}, "", report).(map[string]any)
+	value["tags"].([]any)[0] = "changed input"
+	report.Changes[0].To.([]any)[0] = "changed report"
+
+	if def := schema["properties"].(map[string]any)["tags"].(map[string]any)["default"].([]any); def[0] != "go" {
+		t.Errorf("schema default = %v, want it unchanged", def)
+	}
+}
@@ -0,0 +1,257 @@
+// Package pluggotest provides test doubles for host code that calls pluggo
+// plugin functions. The fakes implement the pluggo.Caller interface, so
+// services depending on it can be unit tested without launching a plugin.
+package pluggotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/henomis/pluggo"
+)
+
+// ErrNoResponse is returned by a fake function that has no scripted response,
+// handler or injected error left to serve a call.
+var ErrNoResponse = errors.New("pluggotest: no response scripted for call")
+
+// TestingT is the subset of testing.TB used by the assertion helpers.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// response is a single scripted result returned by a fake function.
+type response[R any] struct {
+	output *R
+	err    error
+}
+
+// Function is a fake implementation of pluggo.Caller.
+// Calls are served, in order of precedence, by an injected error, by the queue
+// of scripted responses and finally by the handler function, if any.
+// Errors are returned as-is, so tests can inject pluggo's typed errors.
+// Every received input is recorded so that tests can assert on it.
+type Function[T, R any] struct {
+	mu        sync.Mutex
+	name      string
+	schema    *pluggo.Schema
+	responses []response[R]
+	handler   func(*T) (*R, error)
+	err       error
+	latency   time.Duration
+	calls     []*T
+}
+
+var _ pluggo.Caller[struct{}, struct{}] = (*Function[struct{}, struct{}])(nil)
+
+// NewFunction creates a new fake function with the given name.
+// Its schema is generated from T and R the same way a real plugin would.
+func NewFunction[T, R any](name string) *Function[T, R] {
+	schema := pluggo.NewFunctionHandler[T, R](nil, nil).Handler().Schema
+
+	return &Function[T, R]{
+		name:   name,
+		schema: &schema,
+	}
+}
+
+// Return queues a scripted response. Queued responses are consumed in order,
+// one per call.
+func (f *Function[T, R]) Return(output *R, err error) *Function[T, R] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.responses = append(f.responses, response[R]{output: output, err: err})
+	return f
+}
+
+// Handle sets a function used to compute the response once the scripted
+// responses are exhausted.
+func (f *Function[T, R]) Handle(handler func(*T) (*R, error)) *Function[T, R] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.handler = handler
+	return f
+}
+
+// FailWith makes every subsequent call fail with err. Pass nil to stop injecting errors.
+func (f *Function[T, R]) FailWith(err error) *Function[T, R] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+	return f
+}
+
+// WithLatency delays every subsequent call by the given duration.
+func (f *Function[T, R]) WithLatency(latency time.Duration) *Function[T, R] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = latency
+	return f
+}
+
+// WithSchema overrides the schema returned by Schema.
+func (f *Function[T, R]) WithSchema(schema *pluggo.Schema) *Function[T, R] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.schema = schema
+	return f
+}
+
+// Name returns the name of the fake function.
+func (f *Function[T, R]) Name() string {
+	return f.name
+}
+
+// Call records the input and returns the next response.
+func (f *Function[T, R]) Call(input *T) (*R, error) {
+	return f.CallContext(context.Background(), input)
+}
+
+// CallContext records the input and returns the next response.
+// Cancelling ctx interrupts the injected latency.
+func (f *Function[T, R]) CallContext(ctx context.Context, input *T) (*R, error) {
+	f.mu.Lock()
+	latency := f.latency
+	f.calls = append(f.calls, clone(input))
+
+	var (
+		output  *R
+		err     error
+		handler func(*T) (*R, error)
+	)
+
+	switch {
+	case f.err != nil:
+		err = f.err
+	case len(f.responses) > 0:
+		output, err = f.responses[0].output, f.responses[0].err
+		f.responses = f.responses[1:]
+	case f.handler != nil:
+		handler = f.handler
+	default:
+		err = ErrNoResponse
+	}
+	f.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if handler != nil {
+		return handler(input)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// Schema returns the schema of the fake function.
+func (f *Function[T, R]) Schema() (*pluggo.Schema, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.schema, nil
+}
+
+// Calls returns a copy of the inputs received so far, in call order.
+func (f *Function[T, R]) Calls() []*T {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	calls := make([]*T, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
+
+// CallCount returns the number of calls received so far.
+func (f *Function[T, R]) CallCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.calls)
+}
+
+// Reset clears recorded calls, scripted responses, the handler and injected failures.
+func (f *Function[T, R]) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = nil
+	f.responses = nil
+	f.handler = nil
+	f.err = nil
+	f.latency = 0
+}
+
+// AssertCalled reports a test error unless the function was called exactly n times.
+func (f *Function[T, R]) AssertCalled(t TestingT, n int) bool {
+	t.Helper()
+
+	if count := f.CallCount(); count != n {
+		t.Errorf("function %q: expected %d calls, got %d", f.name, n, count)
+		return false
+	}
+	return true
+}
+
+// AssertCalledWith reports a test error unless the function was called exactly
+// n times with an input deeply equal to input.
+func (f *Function[T, R]) AssertCalledWith(t TestingT, n int, input *T) bool {
+	t.Helper()
+
+	count := 0
+	for _, call := range f.Calls() {
+		if reflect.DeepEqual(call, input) {
+			count++
+		}
+	}
+
+	if count != n {
+		t.Errorf("function %q: expected %d calls with %s, got %d", f.name, n, format(input), count)
+		return false
+	}
+	return true
+}
+
+// AssertNotCalled reports a test error if the function was called at all.
+func (f *Function[T, R]) AssertNotCalled(t TestingT) bool {
+	t.Helper()
+
+	return f.AssertCalled(t, 0)
+}
+
+// clone returns a shallow copy of v so that later mutations by the caller
+// don't alter the recorded input.
+func clone[T any](v *T) *T {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	return &c
+}
+
+// format renders a value for assertion messages.
+func format(v any) string {
+	if v == nil || reflect.ValueOf(v).IsNil() {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%+v", reflect.ValueOf(v).Elem().Interface())
+}
@@ -0,0 +1,162 @@
+package pluggotest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/henomis/pluggo"
+	"github.com/henomis/pluggo/pluggotest"
+)
+
+type greetInput struct {
+	Name string `json:"name"`
+}
+
+type greetOutput struct {
+	Greeting string `json:"greeting"`
+}
+
+// recordingT records the errors reported by the assertion helpers.
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestFunctionResponses(t *testing.T) {
+	errFailed := errors.New("failed")
+	f := pluggotest.NewFunction[greetInput, greetOutput]("greet").
+		Return(&greetOutput{Greeting: "first"}, nil).
+		Return(nil, errFailed).
+		Handle(func(in *greetInput) (*greetOutput, error) {
+			return &greetOutput{Greeting: "hello " + in.Name}, nil
+		})
+
+	want := []struct {
+		greeting string
+		err      error
+	}{
+		{greeting: "first"},
+		{err: errFailed},
+		{greeting: "hello ada"},
+		{greeting: "hello ada"},
+	}
+	for i, want := range want {
+		out, err := f.Call(&greetInput{Name: "ada"})
+		if !errors.Is(err, want.err) {
+			t.Fatalf("call %d error = %v, want %v", i, err, want.err)
+		}
+		if want.err == nil && out.Greeting != want.greeting {
+			t.Errorf("call %d greeting = %q, want %q", i, out.Greeting, want.greeting)
+		}
+	}
+
+	f.FailWith(&pluggo.FunctionNotFoundError{Function: "greet"})
+	var notFound *pluggo.FunctionNotFoundError
+	if _, err := f.Call(&greetInput{}); !errors.As(err, &notFound) {
+		t.Errorf("injected error = %v, want the FunctionNotFoundError as-is", err)
+	}
+
+	f.FailWith(nil)
+	if _, err := f.Call(&greetInput{Name: "bob"}); err != nil {
+		t.Errorf("call after FailWith(nil): %v", err)
+	}
+
+	f.Reset()
+	if _, err := f.Call(&greetInput{}); !errors.Is(err, pluggotest.ErrNoResponse) {
+		t.Errorf("call after Reset = %v, want ErrNoResponse", err)
+	}
+	if f.CallCount() != 1 {
+		t.Errorf("calls after Reset = %d, want 1", f.CallCount())
+	}
+}
+
+func TestFunctionLatency(t *testing.T) {
+	f := pluggotest.NewFunction[greetInput, greetOutput]("greet").
+		Return(&greetOutput{}, nil).
+		WithLatency(20 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := f.Call(&greetInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("call returned after %v, want at least the latency", elapsed)
+	}
+
+	f.WithLatency(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.CallContext(ctx, &greetInput{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled call = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestFunctionAssertions(t *testing.T) {
+	f := pluggotest.NewFunction[greetInput, greetOutput]("greet").Handle(func(*greetInput) (*greetOutput, error) {
+		return &greetOutput{}, nil
+	})
+
+	rt := &recordingT{}
+	if !f.AssertNotCalled(rt) || len(rt.errors) != 0 {
+		t.Errorf("AssertNotCalled before any call reported %q", rt.errors)
+	}
+
+	input := &greetInput{Name: "ada"}
+	_, _ = f.Call(input)
+	_, _ = f.Call(&greetInput{Name: "bob"})
+	input.Name = "changed"
+
+	for _, check := range []struct {
+		name string
+		ok   bool
+	}{
+		{name: "called twice", ok: f.AssertCalled(rt, 2)},
+		{name: "called with ada once", ok: f.AssertCalledWith(rt, 1, &greetInput{Name: "ada"})},
+		{name: "never called with the mutated input", ok: f.AssertCalledWith(rt, 0, input)},
+	} {
+		if !check.ok {
+			t.Errorf("%s: assertion failed with %q", check.name, rt.errors)
+		}
+	}
+	if len(rt.errors) != 0 {
+		t.Fatalf("passing assertions reported %q", rt.errors)
+	}
+
+	if f.AssertCalled(rt, 3) || f.AssertCalledWith(rt, 2, &greetInput{Name: "bob"}) || f.AssertNotCalled(rt) {
+		t.Error("failing assertion returned true")
+	}
+	if len(rt.errors) != 3 {
+		t.Errorf("failing assertions reported %q, want 3 errors", rt.errors)
+	}
+
+	calls := f.Calls()
+	if len(calls) != 2 || calls[0].Name != "ada" || calls[1].Name != "bob" {
+		t.Errorf("recorded calls = %+v", calls)
+	}
+}
+
+func TestFunctionSchema(t *testing.T) {
+	f := pluggotest.NewFunction[greetInput, greetOutput]("greet")
+
+	schema, err := f.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties, _ := schema.Input["properties"].(map[string]any)
+	if _, ok := properties["name"]; !ok || f.Name() != "greet" {
+		t.Errorf("schema of %s = %+v, want the properties of the input", f.Name(), schema.Input)
+	}
+
+	custom := &pluggo.Schema{Input: map[string]any{"type": "object"}}
+	if schema, _ := f.WithSchema(custom).Schema(); schema != custom {
+		t.Errorf("schema = %+v, want the schema set with WithSchema", schema)
+	}
+}
@@ -0,0 +1,109 @@
+package pluggotest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/henomis/pluggo"
+)
+
+// Tracer is a fake pluggo.Tracer that records every span it creates.
+// Each span gets a W3C traceparent stored in the returned context, continuing
+// the trace found in the parent context if any, so propagation across the
+// plugin boundary can be asserted.
+type Tracer struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+var _ pluggo.Tracer = (*Tracer)(nil)
+
+// NewTracer creates a new fake tracer.
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// Start creates and records a new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind pluggo.SpanKind) (context.Context, pluggo.Span) {
+	parent, _ := pluggo.TraceContextFromContext(ctx)
+
+	traceID := randomHex(16)
+	if fields := strings.Split(parent.TraceParent, "-"); len(fields) == 4 {
+		traceID = fields[1]
+	}
+
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Parent:     parent,
+		Attributes: make(map[string]any),
+		TraceContext: pluggo.TraceContext{
+			TraceParent: fmt.Sprintf("00-%s-%s-01", traceID, randomHex(8)),
+			TraceState:  parent.TraceState,
+			Baggage:     parent.Baggage,
+		},
+	}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+
+	return pluggo.ContextWithTraceContext(ctx, span.TraceContext), span
+}
+
+// Spans returns the spans created so far, in creation order.
+func (t *Tracer) Spans() []*Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	spans := make([]*Span, len(t.spans))
+	copy(spans, t.spans)
+	return spans
+}
+
+// Span is a span recorded by the fake Tracer.
+type Span struct {
+	mu           sync.Mutex
+	Name         string
+	Kind         pluggo.SpanKind
+	Parent       pluggo.TraceContext
+	TraceContext pluggo.TraceContext
+	Attributes   map[string]any
+	Errors       []error
+	Ended        bool
+}
+
+// SetAttribute records an attribute on the span.
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Attributes[key] = value
+}
+
+// RecordError records an error on the span.
+func (s *Span) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Errors = append(s.Errors, err)
+}
+
+// End marks the span as ended.
+func (s *Span) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Ended = true
+}
+
+// randomHex returns n random bytes encoded as hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
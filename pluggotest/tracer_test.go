@@ -0,0 +1,160 @@
+package pluggotest_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/henomis/pluggo"
+	"github.com/henomis/pluggo/pluggotest"
+)
+
+// pluginEnv makes the test binary serve the test plugin instead of running
+// the tests, so that the client can launch it as a real plugin.
+const pluginEnv = "PLUGGOTEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		if err := newTracePlugin().Start(); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type traceInput struct {
+	Name string `json:"name"`
+}
+
+// traceOutput reports what the plugin saw of the trace of a call.
+type traceOutput struct {
+	Headers map[string]string
+	Span    traceSpan
+	Context pluggo.TraceContext
+}
+
+// traceSpan is a span recorded in the plugin.
+type traceSpan struct {
+	Name         string
+	Kind         pluggo.SpanKind
+	Parent       pluggo.TraceContext
+	TraceContext pluggo.TraceContext
+}
+
+type headersKey struct{}
+
+// newTracePlugin creates a plugin tracing its trace function with a fake
+// Tracer. The function returns the trace headers it received, its server
+// span and the trace context of its context.
+func newTracePlugin() *pluggo.Plugin {
+	tracer := pluggotest.NewTracer()
+
+	trace := pluggo.NewFunctionHandler(func(ctx context.Context, _ *traceInput) (*traceOutput, error) {
+		header, _ := ctx.Value(headersKey{}).(http.Header)
+		out := &traceOutput{Headers: make(map[string]string)}
+		for _, name := range []string{"traceparent", "tracestate", "baggage"} {
+			out.Headers[name] = header.Get(name)
+		}
+
+		if spans := tracer.Spans(); len(spans) > 0 {
+			span := spans[len(spans)-1]
+			out.Span = traceSpan{Name: span.Name, Kind: span.Kind, Parent: span.Parent, TraceContext: span.TraceContext}
+		}
+		out.Context, _ = pluggo.TraceContextFromContext(ctx)
+		return out, nil
+	}, nil, pluggo.WithHandlerTracer(tracer)).Handler()
+
+	p := pluggo.NewPlugin(pluggo.WithLogger(slog.New(slog.DiscardHandler)))
+	_ = p.AddFunction("trace", &pluggo.Handler{
+		Schema: trace.Schema,
+		HTTPHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			trace.HTTPHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), headersKey{}, r.Header.Clone())))
+		}),
+	})
+	return p
+}
+
+func TestTracerPropagation(t *testing.T) {
+	t.Setenv(pluginEnv, "1")
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := pluggotest.NewTracer()
+	client := pluggo.New(executable, pluggo.WithTracer(tracer))
+	if err := client.Open(context.Background()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	f, err := pluggo.NewFunction[traceInput, traceOutput]("trace", client.Connection())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caller := pluggo.TraceContext{
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		TraceState:  "vendor=value",
+		Baggage:     "user=ada",
+	}
+	out, err := f.CallContext(pluggo.ContextWithTraceContext(context.Background(), caller), &traceInput{Name: "ada"})
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+
+	spans := tracer.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("client spans = %d, want 1", len(spans))
+	}
+	clientSpan, serverSpan := spans[0], out.Span
+
+	t.Run("client injects the trace context", func(t *testing.T) {
+		if clientSpan.Kind != pluggo.SpanKindClient || clientSpan.Parent != caller {
+			t.Errorf("client span = %v child of %+v, want a client span child of the caller", clientSpan.Kind, clientSpan.Parent)
+		}
+		for header, want := range map[string]string{
+			"traceparent": clientSpan.TraceContext.TraceParent,
+			"tracestate":  caller.TraceState,
+			"baggage":     caller.Baggage,
+		} {
+			if got := out.Headers[header]; got != want {
+				t.Errorf("%s header = %q, want %q", header, got, want)
+			}
+		}
+	})
+
+	t.Run("handler extracts the trace context", func(t *testing.T) {
+		if serverSpan.Kind != pluggo.SpanKindServer || serverSpan.Name != "trace" {
+			t.Errorf("server span = %v %q, want a server span named trace", serverSpan.Kind, serverSpan.Name)
+		}
+		if serverSpan.Parent != clientSpan.TraceContext {
+			t.Errorf("server span parent = %+v, want %+v", serverSpan.Parent, clientSpan.TraceContext)
+		}
+		if out.Context != serverSpan.TraceContext {
+			t.Errorf("function trace context = %+v, want the server span %+v", out.Context, serverSpan.TraceContext)
+		}
+	})
+
+	t.Run("spans are parent and child", func(t *testing.T) {
+		traceID := strings.Split(caller.TraceParent, "-")[1]
+		for _, tc := range []pluggo.TraceContext{clientSpan.TraceContext, serverSpan.TraceContext} {
+			if fields := strings.Split(tc.TraceParent, "-"); len(fields) != 4 || fields[1] != traceID {
+				t.Errorf("trace parent %q, want trace %s", tc.TraceParent, traceID)
+			}
+		}
+		if serverSpan.TraceContext.TraceParent == clientSpan.TraceContext.TraceParent {
+			t.Error("server span reuses the span id of the client span")
+		}
+		if !clientSpan.Ended || len(clientSpan.Errors) != 0 {
+			t.Errorf("client span ended = %t with errors %v", clientSpan.Ended, clientSpan.Errors)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package pluggo
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,9 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,10 +21,21 @@ const (
 
 // Schema represents the input and output JSON schemas for a plugin function.
 // This provides introspection capabilities for clients to understand
-// the expected data structures.
+// the expected data structures. The remaining fields carry the optional
+// metadata attached when the function was registered.
 type Schema struct {
-	Input  map[string]any `json:"input"`
-	Output map[string]any `json:"output"`
+	Input       map[string]any `json:"input"`
+	Output      map[string]any `json:"output"`
+	Description string         `json:"description,omitempty"`
+	Version     string         `json:"version,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Examples    []Example      `json:"examples,omitempty"`
+	Deprecation string         `json:"deprecation,omitempty"`
+	Idempotent  bool           `json:"idempotent,omitempty"`
+	ReadOnly    bool           `json:"readOnly,omitempty"`
+	// Concurrency describes the concurrency limits applying to the function
+	// and their utilization when the schema was retrieved.
+	Concurrency []ConcurrencyStatus `json:"concurrency,omitempty"`
 }
 
 // Schemas is a map of function names to their corresponding schemas.
@@ -31,14 +46,63 @@ type Schemas map[string]Schema
 // health check and schema introspection endpoints.
 type Plugin struct {
 	logger     *slog.Logger
-	functions  Schemas
+	metrics    Metrics
 	httpServer *http.Server
 	mux        *http.ServeMux
+	stdio      bool
+	jobs       *jobTable
+	limiter    *limiter
+
+	maxBodySize  int64
+	maxFrameSize int
+
+	mu         sync.RWMutex
+	functions  map[string]*registeredFunction
+	middleware []Middleware
+	stopStdio  context.CancelFunc
+}
+
+// registeredFunction is a function registered with AddFunction.
+type registeredFunction struct {
+	schema            Schema
+	handler           http.Handler
+	limiter           *limiter
+	boundsAttachments bool
+}
+
+// PluginOption is a function that configures a Plugin during creation.
+type PluginOption func(*Plugin)
+
+// WithPluginMetrics sets the metrics hook used to instrument function handlers.
+// Defaults to a MetricsRegistry. The /_metrics endpoint is served only when the
+// hook is also an http.Handler, as MetricsRegistry is.
+func WithPluginMetrics(metrics Metrics) PluginOption {
+	return func(l *Plugin) {
+		l.metrics = metrics
+	}
+}
+
+// functionOptions holds the optional configuration of a registered function.
+type functionOptions struct {
+	middleware  []Middleware
+	description string
+	version     string
+	tags        []string
+	examples    []example
+	deprecation string
+	idempotent  bool
+	readOnly    bool
+
+	concurrencyLimit *ConcurrencyLimit
 }
 
+// FunctionOption is a function that configures a function registered with AddFunction.
+type FunctionOption func(*functionOptions)
+
 // NewPlugin creates a new plugin instance with default configuration.
-// It sets up the HTTP server, logging, health check endpoint, and schema endpoint.
-func NewPlugin() *Plugin {
+// It sets up the HTTP server, logging, health check endpoint, schema endpoint
+// and metrics endpoint. Options can be provided to customize its behavior.
+func NewPlugin(opts ...PluginOption) *Plugin {
 	mux := http.NewServeMux()
 
 	l := &Plugin{
@@ -46,13 +110,25 @@ func NewPlugin() *Plugin {
 		logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
 			Level: slog.LevelInfo,
 		})),
+		metrics: NewMetricsRegistry(),
 		httpServer: &http.Server{
-			Handler:     mux,
-			ReadTimeout: 5 * time.Second,
+			ReadTimeout:    5 * time.Second,
+			IdleTimeout:    DefaultIdleTimeout,
+			MaxHeaderBytes: http.DefaultMaxHeaderBytes,
 		},
-		functions: make(map[string]Schema),
+		functions:    make(map[string]*registeredFunction),
+		jobs:         newJobTable(),
+		maxBodySize:  DefaultMaxBodySize,
+		maxFrameSize: maxStdioFrameSize,
+	}
+
+	for _, opt := range opts {
+		opt(l)
 	}
 
+	l.httpServer.Handler = l.withLogger(l.limitBody(mux))
+	l.httpServer.ErrorLog = slog.NewLogLogger(l.logger.Handler(), slog.LevelError)
+
 	// Liveness/Readiness probe
 	mux.HandleFunc(healthPath, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -63,42 +139,199 @@ func NewPlugin() *Plugin {
 	mux.HandleFunc(schemasPath, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 
-		err := json.NewEncoder(w).Encode(l.functions)
+		err := json.NewEncoder(w).Encode(l.Schemas())
 		if err != nil {
 			l.logger.Error("failed to encode functions list", "error", err)
 		}
 	})
 
+	// Prometheus metrics
+	if handler, ok := l.metrics.(http.Handler); ok {
+		mux.Handle(metricsPath, handler)
+	}
+
+	// JSON-RPC 2.0 endpoint
+	mux.HandleFunc(rpcPath, l.serveRPC)
+
+	// Asynchronous jobs
+	mux.HandleFunc(jobsPath+"/", l.serveJob)
+
+	// Functions, their schemas and job submission
+	mux.HandleFunc(basePath, l.serveFunction)
+
 	return l
 }
 
 // AddFunction registers a new function with the plugin server.
 // The function becomes available at the endpoint /{functionName} and
 // its schema at /{functionName}/_schemas. Function names are validated
-// to ensure they contain only safe characters.
-func (l *Plugin) AddFunction(functionName string, handler *Handler) {
+// to ensure they contain only safe characters. Options can be provided
+// to customize the function, e.g. to add function-specific middleware or
+// metadata such as a description.
+//
+// It returns a FunctionRegistrationError if the name is invalid, reserved
+// (see ErrReservedFunctionName), already registered, if the metadata is
+// invalid or if the handler can't serve the function, e.g. because its
+// output schema doesn't compile.
+func (l *Plugin) AddFunction(functionName string, handler *Handler, opts ...FunctionOption) error {
 	if err := validateFunctionName(functionName); err != nil {
-		l.logger.Error("invalid function name", "function", functionName, "error", err)
+		return &FunctionRegistrationError{Function: functionName, Err: err}
+	}
+	if handler.err != nil {
+		return &FunctionRegistrationError{Function: functionName, Err: handler.err}
+	}
+
+	var options functionOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	schema, err := applyMetadata(handler.Schema, &options)
+	if err != nil {
+		return &FunctionRegistrationError{Function: functionName, Err: err}
+	}
+
+	httpHandler := l.chain(functionName, schema, options.middleware, handler.HTTPHandler)
+
+	var functionLimiter *limiter
+	if options.concurrencyLimit != nil {
+		functionLimiter = newLimiter(ConcurrencyScopeFunction, *options.concurrencyLimit)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.functions[functionName]; ok {
+		return &FunctionRegistrationError{Function: functionName, Err: ErrFunctionAlreadyRegistered}
+	}
+
+	l.functions[functionName] = &registeredFunction{
+		schema:            schema,
+		handler:           l.instrument(functionName, l.admit(functionLimiter, httpHandler)),
+		limiter:           functionLimiter,
+		boundsAttachments: handler.boundsAttachments,
+	}
+
+	return nil
+}
+
+// RemoveFunction unregisters a function at runtime. Requests already being
+// handled complete normally; subsequent requests receive a not found error.
+// It returns a FunctionNotFoundError if no function is registered with that name.
+func (l *Plugin) RemoveFunction(functionName string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.functions[functionName]; !ok {
+		return &FunctionNotFoundError{Function: functionName}
+	}
+
+	delete(l.functions, functionName)
+	return nil
+}
+
+// Schemas returns a snapshot of the schemas of the registered functions.
+func (l *Plugin) Schemas() Schemas {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	schemas := make(Schemas, len(l.functions))
+	for name, function := range l.functions {
+		schemas[name] = l.schemaOf(function)
+	}
+	return schemas
+}
+
+// serveFunction routes requests to /{functionName}, /{functionName}/_schemas
+// and /{functionName}/_jobs to the registered function.
+func (l *Plugin) serveFunction(w http.ResponseWriter, r *http.Request) {
+	functionName, endpoint, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, basePath), "/")
+
+	l.mu.RLock()
+	function, ok := l.functions[functionName]
+	l.mu.RUnlock()
+
+	if !ok || (endpoint != "" && basePath+endpoint != schemasPath && endpoint != jobsEndpoint) {
+		w.Header().Set(errorCodeHeader, errorCodeNotFound)
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprintf(w, "function %q not found", functionName)
 		return
 	}
 
-	l.functions[functionName] = handler.Schema
-	l.mux.Handle(basePath+functionName, handler.HTTPHandler)
-	l.mux.HandleFunc(fmt.Sprintf("%s%s%s", basePath, functionName, schemasPath), func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+	if endpoint == "" && r.Header.Get(progressHeader) != "" {
+		l.serveProgress(w, r, function.handler)
+		return
+	}
 
-		err := json.NewEncoder(w).Encode(l.functions[functionName])
-		if err != nil {
-			l.logger.Error("failed to encode functions list", "error", err)
-		}
+	if endpoint == "" {
+		function.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if endpoint == jobsEndpoint {
+		l.submitJob(w, r, functionName, function)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(l.schemaOf(function))
+	if err != nil {
+		l.logger.Error("failed to encode function schema", "function", functionName, "error", err)
+	}
+}
+
+// schemaOf returns the schema of a registered function, with the current
+// utilization of its concurrency limits.
+func (l *Plugin) schemaOf(function *registeredFunction) Schema {
+	schema := function.schema
+	schema.Concurrency = l.concurrency(function.limiter)
+	return schema
+}
+
+// instrument wraps a function handler to record in-flight requests, request
+// counts and handler latency.
+func (l *Plugin) instrument(functionName string, handler http.Handler) http.Handler {
+	if l.metrics == nil {
+		return handler
+	}
+
+	labels := Labels{"function": functionName}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		l.metrics.AddGauge(MetricPluginRequestsInFlight, labels, 1)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			// A handler writing nothing gets the implicit 200 of net/http
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			status := strconv.Itoa(recorder.status)
+			l.metrics.AddGauge(MetricPluginRequestsInFlight, labels, -1)
+			l.metrics.IncCounter(MetricPluginRequests, Labels{"function": functionName, "status": status}, 1)
+			l.metrics.Observe(MetricPluginHandlerDuration, labels, time.Since(start).Seconds())
+		}()
+
+		handler.ServeHTTP(recorder, r)
 	})
 }
 
 // Start begins serving the plugin on an ephemeral port.
 // The port number is printed to stdout as the first line, which allows
-// the client to discover how to connect to the plugin.
+// the client to discover how to connect to the plugin. When the client
+// requests a protocol, the line is "port|protocol". Functions are served both
+// at /{functionName} and through the JSON-RPC 2.0 endpoint at /_rpc.
+//
+// With the stdio transport no socket is opened: the handshake is "stdio" and
+// requests are served over stdin and stdout until stdin is closed.
 // This method blocks until the server stops or encounters an error.
 func (l *Plugin) Start() error {
+	protocol := negotiateProtocol(os.Getenv(protocolEnv))
+	if l.stdio || os.Getenv(transportEnv) == stdioHandshake {
+		return l.startStdio(protocol)
+	}
+
 	// Bind to an ephemeral port
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -112,8 +345,13 @@ func (l *Plugin) Start() error {
 		return err
 	}
 
-	// First line to stdout MUST be the port so the launcher can parse it
-	fmt.Println(port)
+	// First line to stdout MUST be the handshake so the launcher can parse it:
+	// the port, followed by the negotiated protocol when the client asked for one
+	if protocol != "" {
+		fmt.Printf("%s|%s\n", port, protocol)
+	} else {
+		fmt.Println(port)
+	}
 	_ = os.Stdout.Sync()
 
 	l.httpServer.Addr = ln.Addr().String()
@@ -125,8 +363,8 @@ func (l *Plugin) Start() error {
 	return nil
 }
 
-// Stop gracefully shuts down the plugin server and cleans up resources.
-// This method is safe to call multiple times.
+// Stop gracefully shuts down the plugin server and cleans up resources,
+// cancelling the running jobs. This method is safe to call multiple times.
 func (l *Plugin) Stop() {
 	defer func() {
 		l.httpServer = nil
@@ -136,6 +374,25 @@ func (l *Plugin) Stop() {
 	if l.httpServer != nil {
 		_ = l.httpServer.Close()
 	}
+
+	l.jobs.stopAll()
+
+	l.mu.Lock()
+	if l.stopStdio != nil {
+		l.stopStdio()
+	}
+	l.mu.Unlock()
+}
+
+// isReservedName reports whether name is the path of an internal endpoint
+// of the plugin, such as _schemas or _rpc.
+func isReservedName(name string) bool {
+	switch basePath + name {
+	case schemasPath, healthPath, metricsPath, rpcPath, jobsPath:
+		return true
+	default:
+		return false
+	}
 }
 
 // validateFunctionName ensures that function names contain only safe characters
@@ -148,6 +405,9 @@ func validateFunctionName(function string) error {
 	if function[0] == '/' {
 		return errors.New("function name cannot start with '/'")
 	}
+	if isReservedName(function) {
+		return ErrReservedFunctionName
+	}
 	if len(function) > 128 {
 		return errors.New("function name cannot be longer than 128 characters")
 	}
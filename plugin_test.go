@@ -0,0 +1,85 @@
+package pluggo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAddFunctionNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want error
+	}{
+		{name: "_rpc", want: ErrReservedFunctionName},
+		{name: "_schemas", want: ErrReservedFunctionName},
+		{name: "_healthz", want: ErrReservedFunctionName},
+		{name: "_metrics", want: ErrReservedFunctionName},
+		{name: "_jobs", want: ErrReservedFunctionName},
+		{name: "greet", want: ErrFunctionAlreadyRegistered},
+		{name: "_private"},
+		{name: "_rpc2"},
+		{name: "math.add"},
+	}
+
+	p := newTestPlugin(t)
+	handler := NewFunctionHandler(greet, nil).Handler()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.AddFunction(tt.name, handler)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("AddFunction(%q) = %v, want nil", tt.name, err)
+				}
+				return
+			}
+
+			var registrationErr *FunctionRegistrationError
+			if !errors.As(err, &registrationErr) || !errors.Is(err, tt.want) {
+				t.Fatalf("AddFunction(%q) = %v, want a FunctionRegistrationError wrapping %v", tt.name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnderscoreFunctionIsCallable(t *testing.T) {
+	p := newTestPlugin(t)
+	if err := p.AddFunction("_greet", NewFunctionHandler(greet, nil).Handler()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			f := newTestFunction[greetInput, greetOutput](t, "_greet", serve(t, p, protocol))
+			out, err := f.Call(&greetInput{Name: "ada"})
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if out.Greeting != "hello ada" {
+				t.Errorf("greeting = %q, want %q", out.Greeting, "hello ada")
+			}
+		})
+	}
+}
+
+func TestRemovedFunctionIsNotFound(t *testing.T) {
+	p := newTestPlugin(t)
+	connection := serve(t, p, ProtocolREST)
+
+	if err := p.RemoveFunction("greet"); err != nil {
+		t.Fatal(err)
+	}
+	var notFound *FunctionNotFoundError
+	if err := p.RemoveFunction("greet"); !errors.As(err, &notFound) {
+		t.Errorf("removing twice = %v, want a FunctionNotFoundError", err)
+	}
+
+	_, err := newTestFunction[greetInput, greetOutput](t, "greet", connection).Call(&greetInput{Name: "ada"})
+	if !errors.As(err, &notFound) {
+		t.Errorf("calling a removed function = %v, want a FunctionNotFoundError", err)
+	}
+
+	if err := p.AddFunction("greet", NewFunctionHandler(greet, nil).Handler()); err != nil {
+		t.Errorf("registering a removed name again: %v", err)
+	}
+}
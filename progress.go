@@ -0,0 +1,167 @@
+package pluggo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const (
+	// progressHeader asks the plugin to stream the progress of a call.
+	progressHeader = "X-Pluggo-Progress"
+	// progressContentType is the content type of responses streaming progress.
+	progressContentType = "application/x-ndjson"
+	// maxProgressFrameSize bounds a line of a progress stream. The final frame
+	// carries the base64-encoded response of the function, so the bound is
+	// well above the response limit, which applies to the stream as a whole.
+	maxProgressFrameSize = 1 << 30
+)
+
+// Progress describes how far a running function call is.
+type Progress struct {
+	// Percent is the completion percentage, from 0 to 100.
+	Percent float64 `json:"percent"`
+	// Message is an optional human-readable description of the current step.
+	Message string `json:"message,omitempty"`
+	// Fields holds arbitrary structured details, e.g. the number of processed items.
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+// progressKey is the context key of the progress reporter of a call.
+type progressKey struct{}
+
+// progressCallbackKey is the context key of the progress callback of a caller.
+type progressCallbackKey struct{}
+
+// ReportProgress reports the progress of the function call carrying ctx. The
+// progress is streamed to callers subscribed with ContextWithProgress and, for
+// asynchronous jobs, is part of the job status. It does nothing when nobody
+// listens to the progress of the call.
+func ReportProgress(ctx context.Context, progress Progress) {
+	if report, ok := ctx.Value(progressKey{}).(func(Progress)); ok {
+		report(progress)
+	}
+}
+
+// withProgressReporter returns a context whose progress reports are passed to report.
+func withProgressReporter(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// ContextWithProgress returns a context subscribing the function calls made
+// with it to their progress: callback is called with every progress reported
+// by the plugin function before the call returns. Calls made without a
+// subscription keep a plain response. With the stdio transport the updates
+// arrive together with the response, and with ProtocolJSONRPC they are not
+// reported.
+func ContextWithProgress(ctx context.Context, callback func(Progress)) context.Context {
+	return context.WithValue(ctx, progressCallbackKey{}, callback)
+}
+
+// progressCallback returns the progress callback of ctx, or nil.
+func progressCallback(ctx context.Context) func(Progress) {
+	callback, _ := ctx.Value(progressCallbackKey{}).(func(Progress))
+	return callback
+}
+
+// progressFrame is a line of a progress stream: a progress update, or the
+// final frame carrying the response of the function.
+type progressFrame struct {
+	Progress *Progress   `json:"progress,omitempty"`
+	Status   int         `json:"status,omitempty"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     []byte      `json:"body,omitempty"`
+}
+
+// serveProgress calls a function handler streaming its progress as
+// newline-delimited JSON frames, followed by a frame with its response.
+func (l *Plugin) serveProgress(w http.ResponseWriter, r *http.Request, handler http.Handler) {
+	var (
+		mu   sync.Mutex
+		done bool
+	)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	write := func(frame progressFrame, last bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if done {
+			return
+		}
+		done = last
+
+		if err := enc.Encode(frame); err != nil {
+			l.logger.Error("failed to write progress", "error", err)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	w.Header().Set("Content-Type", progressContentType)
+	w.WriteHeader(http.StatusOK)
+
+	ctx := withProgressReporter(r.Context(), func(progress Progress) {
+		write(progressFrame{Progress: &progress}, false)
+	})
+
+	response := newResponseBuffer()
+	handler.ServeHTTP(response, r.WithContext(ctx))
+
+	status := response.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	write(progressFrame{Status: status, Header: response.header, Body: response.body.Bytes()}, true)
+}
+
+// readProgress reads a progress stream, passing the updates to callback, and
+// returns the response of the function carried by its final frame.
+func readProgress(resp *http.Response, callback func(Progress)) (*http.Response, error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxProgressFrameSize)
+
+	for scanner.Scan() {
+		var frame progressFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, err
+		}
+
+		if frame.Progress != nil {
+			callback(*frame.Progress)
+			continue
+		}
+
+		if frame.Header == nil {
+			frame.Header = make(http.Header)
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", frame.Status, http.StatusText(frame.Status)),
+			StatusCode:    frame.Status,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        frame.Header,
+			Body:          io.NopCloser(bytes.NewReader(frame.Body)),
+			ContentLength: int64(len(frame.Body)),
+			Request:       resp.Request,
+		}, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
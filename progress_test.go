@@ -0,0 +1,105 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// addStepFunction registers a function reporting two progress updates before
+// greeting.
+func addStepFunction(t *testing.T, p *Plugin) {
+	t.Helper()
+
+	handler := NewFunctionHandler(func(ctx context.Context, in *greetInput) (*greetOutput, error) {
+		ReportProgress(ctx, Progress{Percent: 50, Message: "halfway"})
+		ReportProgress(ctx, Progress{Percent: 100, Fields: map[string]any{"items": 2.0}})
+		return &greetOutput{Greeting: "hello " + in.Name}, nil
+	}, nil).Handler()
+	if err := p.AddFunction("step", handler); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContextWithProgress(t *testing.T) {
+	p := newTestPlugin(t)
+	addStepFunction(t, p)
+
+	for name, connection := range map[string]*Connection{
+		"rest":  serve(t, p, ProtocolREST),
+		"stdio": stdioConnection(t, p),
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newTestFunction[greetInput, greetOutput](t, "step", connection)
+
+			var updates []Progress
+			ctx := ContextWithProgress(context.Background(), func(progress Progress) {
+				updates = append(updates, progress)
+			})
+			out, err := f.CallContext(ctx, &greetInput{Name: "ada"})
+			if err != nil || out.Greeting != "hello ada" {
+				t.Fatalf("CallContext = %+v, %v", out, err)
+			}
+			if len(updates) != 2 || updates[0].Message != "halfway" || updates[1].Percent != 100 || updates[1].Fields["items"] != 2.0 {
+				t.Errorf("progress updates = %+v", updates)
+			}
+
+			// Calls without a subscription get the plain response
+			if out, err := f.Call(&greetInput{Name: "bob"}); err != nil || out.Greeting != "hello bob" {
+				t.Errorf("Call = %+v, %v", out, err)
+			}
+		})
+	}
+}
+
+func TestProgressStream(t *testing.T) {
+	p := newTestPlugin(t)
+	large := strings.Repeat("a", 128<<10)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ReportProgress(r.Context(), Progress{Percent: 10})
+		w.Header().Set(errorCodeHeader, errorCodeInvalid)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, large)
+	})
+
+	rec := httptest.NewRecorder()
+	p.serveProgress(rec, httptest.NewRequest(http.MethodPost, "/step", nil), handler)
+	if got := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || got != progressContentType {
+		t.Fatalf("progress stream = %d %s, want 200 %s", rec.Code, got, progressContentType)
+	}
+
+	var updates []Progress
+	resp, err := readProgress(rec.Result(), func(progress Progress) {
+		updates = append(updates, progress)
+	})
+	if err != nil {
+		t.Fatalf("readProgress: %v", err)
+	}
+	if len(updates) != 1 || updates[0].Percent != 10 {
+		t.Errorf("progress updates = %+v", updates)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get(errorCodeHeader) != errorCodeInvalid || string(body) != large {
+		t.Errorf("response = %d %v with %d bytes, want the response of the handler", resp.StatusCode, resp.Header, len(body))
+	}
+
+	for _, tt := range []struct {
+		name    string
+		stream  string
+		wantErr error
+	}{
+		{name: "truncated", stream: `{"progress":{"percent":10}}` + "\n", wantErr: io.ErrUnexpectedEOF},
+		{name: "malformed", stream: "{\n"},
+	} {
+		rec := httptest.NewRecorder()
+		_, _ = io.WriteString(rec, tt.stream)
+		_, err := readProgress(rec.Result(), func(Progress) {})
+		if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("%s stream: readProgress = %v, want an error", tt.name, err)
+		}
+	}
+}
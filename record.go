@@ -0,0 +1,472 @@
+package pluggo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	replayBaseURL = "http://replay.pluggo"
+)
+
+// Interaction is a single request/response pair exchanged with a plugin.
+// JSON bodies are stored verbatim in Request/Response so that cassettes stay
+// readable and diffable; any other body is stored base64-encoded in the Raw fields.
+type Interaction struct {
+	Function    string          `json:"function,omitempty"`
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Request     json.RawMessage `json:"request,omitempty"`
+	RequestRaw  []byte          `json:"requestRaw,omitempty"`
+	Status      int             `json:"status"`
+	Header      http.Header     `json:"header,omitempty"`
+	Response    json.RawMessage `json:"response,omitempty"`
+	ResponseRaw []byte          `json:"responseRaw,omitempty"`
+}
+
+// RequestBody returns the recorded request body.
+func (i *Interaction) RequestBody() []byte {
+	if i.Request != nil {
+		return i.Request
+	}
+	return i.RequestRaw
+}
+
+// ResponseBody returns the recorded response body.
+func (i *Interaction) ResponseBody() []byte {
+	if i.Response != nil {
+		return i.Response
+	}
+	return i.ResponseRaw
+}
+
+// Cassette is an ordered collection of recorded interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette previously written with Cassette.Save.
+func LoadCassette(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cassette Cassette
+	err = json.Unmarshal(b, &cassette)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+
+	return &cassette, nil
+}
+
+// Save writes the cassette to path as indented JSON.
+func (c *Cassette) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
+
+// Recorder is an http.RoundTripper that captures the traffic between the
+// client and a plugin into a Cassette. Health checks are not recorded, and
+// an exchange is recorded once the client read its response or closed it.
+// Use it with the WithRecorder client option.
+type Recorder struct {
+	mu       sync.Mutex
+	next     http.RoundTripper
+	cassette Cassette
+}
+
+// NewRecorder creates a recorder that forwards requests to next.
+// If next is nil, http.DefaultTransport is used.
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Recorder{next: next}
+}
+
+// RoundTrip forwards the request and records the exchange.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.roundTrip(req, r.next)
+}
+
+// roundTrip forwards the request to next and records the exchange.
+func (r *Recorder) roundTrip(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	if req.URL.Path == healthPath {
+		return next.RoundTrip(req)
+	}
+
+	var requestBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		requestBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{
+		Function: requestFunction(req.URL.Path, requestBody),
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		Status:   resp.StatusCode,
+		Header:   resp.Header.Clone(),
+	}
+	interaction.Request, interaction.RequestRaw = splitBody(requestBody)
+
+	// The response is recorded as the client reads it, so that size limits
+	// and progress streams see the plugin response as it arrives
+	resp.Body = &recordingBody{ReadCloser: resp.Body, record: func(responseBody []byte) {
+		interaction.Response, interaction.ResponseRaw = splitBody(responseBody)
+
+		r.mu.Lock()
+		r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+		r.mu.Unlock()
+	}}
+
+	return resp, nil
+}
+
+// recordingBody is a response body passing the bytes read from it to record
+// once it is read to the end or closed.
+type recordingBody struct {
+	io.ReadCloser
+	buf    bytes.Buffer
+	once   sync.Once
+	record func([]byte)
+}
+
+// Read reads from the body, keeping a copy of the bytes read.
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		b.finish()
+	}
+	return n, err
+}
+
+// Close closes the body and records the bytes read so far.
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+// finish records the body once.
+func (b *recordingBody) finish() {
+	b.once.Do(func() {
+		b.record(bytes.Clone(b.buf.Bytes()))
+	})
+}
+
+// Cassette returns a copy of the interactions recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interactions := make([]Interaction, len(r.cassette.Interactions))
+	copy(interactions, r.cassette.Interactions)
+	return &Cassette{Interactions: interactions}
+}
+
+// Save writes the interactions recorded so far to path.
+func (r *Recorder) Save(path string) error {
+	return r.Cassette().Save(path)
+}
+
+// Matcher reports whether an actual request body matches a recorded one.
+// JSON-RPC calls are matched on their params, so that matchers see the same
+// input under every protocol.
+type Matcher func(recorded, actual []byte) bool
+
+// MatchExact matches request bodies that are semantically equal JSON documents,
+// ignoring formatting and key order. Non-JSON bodies must be byte-for-byte equal.
+func MatchExact() Matcher {
+	return func(recorded, actual []byte) bool {
+		var r, a any
+		if json.Unmarshal(recorded, &r) != nil || json.Unmarshal(actual, &a) != nil {
+			return bytes.Equal(recorded, actual)
+		}
+		return reflect.DeepEqual(r, a)
+	}
+}
+
+// MatchIgnoringFields matches JSON request bodies that are equal once the given
+// fields are removed from both. Nested fields are addressed with dotted paths,
+// e.g. "request.timestamp".
+func MatchIgnoringFields(fields ...string) Matcher {
+	return func(recorded, actual []byte) bool {
+		var r, a any
+		if json.Unmarshal(recorded, &r) != nil || json.Unmarshal(actual, &a) != nil {
+			return bytes.Equal(recorded, actual)
+		}
+		for _, field := range fields {
+			path := strings.Split(field, ".")
+			deleteField(r, path)
+			deleteField(a, path)
+		}
+		return reflect.DeepEqual(r, a)
+	}
+}
+
+// ReplayOption is a function that configures a Replayer during creation.
+type ReplayOption func(*Replayer)
+
+// WithMatcher sets the matcher used for every function. Defaults to MatchExact.
+func WithMatcher(matcher Matcher) ReplayOption {
+	return func(r *Replayer) {
+		r.matcher = matcher
+	}
+}
+
+// WithFunctionMatcher sets the matcher used for a single function,
+// overriding the default matcher.
+func WithFunctionMatcher(function string, matcher Matcher) ReplayOption {
+	return func(r *Replayer) {
+		r.functionMatchers[function] = matcher
+	}
+}
+
+// Replayer is an http.RoundTripper that serves the interactions of a Cassette
+// without a running plugin. Matching interactions are served in recorded
+// order; once all of them have been used the last one is served again.
+// A request without any matching interaction fails with a ReplayMismatchError.
+type Replayer struct {
+	mu               sync.Mutex
+	cassette         *Cassette
+	used             []bool
+	matcher          Matcher
+	functionMatchers map[string]Matcher
+}
+
+// NewReplayer creates a replayer serving the interactions of cassette.
+func NewReplayer(cassette *Cassette, opts ...ReplayOption) *Replayer {
+	r := &Replayer{
+		cassette:         cassette,
+		used:             make([]bool, len(cassette.Interactions)),
+		matcher:          MatchExact(),
+		functionMatchers: make(map[string]Matcher),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Connection returns a connection that can be passed to NewFunction to call
+// recorded functions without launching the plugin. Its protocol is the one
+// of the recorded calls.
+func (r *Replayer) Connection() *Connection {
+	return &Connection{
+		FunctionExecutionTimeout: DefaultFunctionExecutionTimeout,
+		BaseURL:                  replayBaseURL,
+		Protocol:                 r.cassette.protocol(),
+		Transport:                r,
+	}
+}
+
+// protocol returns the protocol of the recorded function calls:
+// ProtocolJSONRPC if they were made through the JSON-RPC endpoint.
+func (c *Cassette) protocol() Protocol {
+	for _, interaction := range c.Interactions {
+		if interaction.Path == rpcPath {
+			return ProtocolJSONRPC
+		}
+	}
+	return ProtocolREST
+}
+
+// RoundTrip serves the recorded response matching the request.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == healthPath {
+		return newReplayResponse(req, http.StatusOK, nil, []byte("ok")), nil
+	}
+
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	function, key, id := replayKey(req.URL.Path, body)
+
+	matcher := r.matcher
+	if m, ok := r.functionMatchers[function]; ok {
+		matcher = m
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+	for i := range r.cassette.Interactions {
+		interaction := &r.cassette.Interactions[i]
+		if interaction.Method != req.Method || interaction.Path != req.URL.Path {
+			continue
+		}
+		_, recorded, _ := replayKey(interaction.Path, interaction.RequestBody())
+		if len(key) > 0 || len(recorded) > 0 {
+			if !matcher(recorded, key) {
+				continue
+			}
+		}
+
+		last = i
+		if !r.used[i] {
+			break
+		}
+	}
+
+	if last < 0 {
+		return nil, &ReplayMismatchError{Method: req.Method, Path: req.URL.Path, Body: body}
+	}
+
+	r.used[last] = true
+	interaction := &r.cassette.Interactions[last]
+
+	return newReplayResponse(req, interaction.Status, interaction.Header, replayRPCID(interaction.ResponseBody(), id)), nil
+}
+
+// replayKey returns the function called by a request and the body used to
+// match it against the recordings. JSON-RPC calls are matched on the params
+// of their method, leaving out the id that changes on every run.
+func replayKey(path string, body []byte) (string, []byte, json.RawMessage) {
+	if path != rpcPath {
+		return functionFromPath(path), body, nil
+	}
+
+	var call rpcRequest
+	if err := json.Unmarshal(body, &call); err != nil || call.Method == "" {
+		return "", body, nil
+	}
+	return call.Method, call.Params, call.ID
+}
+
+// replayRPCID sets the id of a replayed JSON-RPC response to the id of the
+// current call.
+func replayRPCID(body []byte, id json.RawMessage) []byte {
+	if id == nil {
+		return body
+	}
+
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	if _, ok := response["id"]; !ok {
+		return body
+	}
+	response["id"] = id
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// requestFunction returns the function called by a request, reading the
+// method of JSON-RPC calls.
+func requestFunction(path string, body []byte) string {
+	function, _, _ := replayKey(path, body)
+	return function
+}
+
+// Unused returns the recorded interactions that have not been served yet.
+// Golden tests can use it to assert that every recording was exercised.
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, used := range r.used {
+		if !used {
+			unused = append(unused, r.cassette.Interactions[i])
+		}
+	}
+	return unused
+}
+
+// newReplayResponse builds an HTTP response for a replayed interaction.
+func newReplayResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// functionFromPath extracts the function name from a plugin endpoint path.
+// It returns an empty string for plugin-wide endpoints such as /_schemas.
+func functionFromPath(path string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(path, basePath), "/")
+	if isReservedName(name) {
+		return ""
+	}
+	return name
+}
+
+// splitBody returns body as a raw JSON message if it is valid JSON, or as
+// raw bytes otherwise.
+func splitBody(body []byte) (json.RawMessage, []byte) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(bytes.TrimSpace(body)), nil
+	}
+	return nil, body
+}
+
+// deleteField removes the field addressed by path from a decoded JSON document.
+func deleteField(v any, path []string) {
+	m, ok := v.(map[string]any)
+	if !ok || len(path) == 0 {
+		return
+	}
+
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+
+	deleteField(m[path[0]], path[1:])
+}
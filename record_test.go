@@ -0,0 +1,175 @@
+package pluggo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// record calls greet once per input through a Recorder and returns the cassette.
+func record(t *testing.T, protocol Protocol, inputs ...*greetInput) *Cassette {
+	t.Helper()
+
+	recorder := NewRecorder(nil)
+	connection := serve(t, newTestPlugin(t), protocol)
+	connection.Transport = recorder
+
+	f := newTestFunction[greetInput, greetOutput](t, "greet", connection)
+	for _, input := range inputs {
+		if _, err := f.Call(input); err != nil {
+			t.Fatalf("recording %+v: %v", input, err)
+		}
+	}
+
+	return recorder.Cassette()
+}
+
+// replay returns the greet function served by a Replayer of cassette.
+func replay(t *testing.T, cassette *Cassette, opts ...ReplayOption) (*Function[greetInput, greetOutput], *Replayer) {
+	t.Helper()
+
+	replayer := NewReplayer(cassette, opts...)
+	return newTestFunction[greetInput, greetOutput](t, "greet", replayer.Connection()), replayer
+}
+
+func TestReplayerServesRecordedCalls(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			cassette := record(t, protocol, &greetInput{Name: "ada"}, &greetInput{Name: "bob"})
+			for _, interaction := range cassette.Interactions {
+				if interaction.Function != "greet" {
+					t.Errorf("recorded function = %q, want greet", interaction.Function)
+				}
+			}
+
+			f, replayer := replay(t, cassette)
+			for _, name := range []string{"bob", "ada"} {
+				out, err := f.Call(&greetInput{Name: name})
+				if err != nil {
+					t.Fatalf("replaying %s: %v", name, err)
+				}
+				if want := "hello " + name; out.Greeting != want {
+					t.Errorf("replayed greeting = %q, want %q", out.Greeting, want)
+				}
+			}
+
+			if unused := replayer.Unused(); len(unused) != 0 {
+				t.Errorf("unused interactions = %d, want 0", len(unused))
+			}
+		})
+	}
+}
+
+func TestReplayerMismatch(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			cassette := record(t, protocol, &greetInput{Name: "ada"})
+			f, replayer := replay(t, cassette)
+
+			_, err := f.Call(&greetInput{Name: "eve"})
+			var mismatch *ReplayMismatchError
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("error = %v, want a ReplayMismatchError", err)
+			}
+			if len(replayer.Unused()) != 1 {
+				t.Errorf("a mismatching call consumed the recording")
+			}
+		})
+	}
+}
+
+func TestReplayerFunctionMatcher(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			cassette := record(t, protocol, &greetInput{Name: "ada", RequestID: "recorded"})
+
+			f, _ := replay(t, cassette)
+			if _, err := f.Call(&greetInput{Name: "ada", RequestID: "replayed"}); err == nil {
+				t.Fatal("exact matching ignored a changed field")
+			}
+
+			f, _ = replay(t, cassette, WithFunctionMatcher("greet", MatchIgnoringFields("requestId")))
+			out, err := f.Call(&greetInput{Name: "ada", RequestID: "replayed"})
+			if err != nil {
+				t.Fatalf("function matcher: %v", err)
+			}
+			if out.Greeting != "hello ada" {
+				t.Errorf("replayed greeting = %q, want %q", out.Greeting, "hello ada")
+			}
+		})
+	}
+}
+
+func TestClientReplaysCassette(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			client := New("replayed", WithReplayer(NewReplayer(record(t, protocol, &greetInput{Name: "ada"}))))
+			if err := client.Open(context.Background()); err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer client.Close()
+
+			if got := client.Connection().Protocol; got != protocol {
+				t.Errorf("replayed protocol = %q, want %q", got, protocol)
+			}
+			out, err := newTestFunction[greetInput, greetOutput](t, "greet", client.Connection()).Call(&greetInput{Name: "ada"})
+			if err != nil || out.Greeting != "hello ada" {
+				t.Errorf("replayed call = %v, %v", out, err)
+			}
+		})
+	}
+}
+
+func TestRecorderStreamsResponses(t *testing.T) {
+	recorder := NewRecorder(nil)
+	connection := serve(t, newTestPlugin(t), ProtocolREST)
+	connection.Transport = recorder
+	connection.MaxResponseSize = 256
+	f := newTestFunction[greetInput, greetOutput](t, "greet", connection)
+
+	var tooLarge *ResponseTooLargeError
+	if _, err := f.Call(&greetInput{Name: strings.Repeat("a", 512)}); !errors.As(err, &tooLarge) {
+		t.Errorf("recorded response past the limit = %v, want a ResponseTooLargeError", err)
+	}
+	if _, err := f.Call(&greetInput{Name: "ada"}); err != nil {
+		t.Fatalf("recorded call: %v", err)
+	}
+
+	interactions := recorder.Cassette().Interactions
+	if len(interactions) != 2 {
+		t.Fatalf("recorded interactions = %d, want 2", len(interactions))
+	}
+	if !jsonEqual(interactions[1].Response, `{"greeting":"hello ada"}`) {
+		t.Errorf("recorded response = %s", interactions[1].Response)
+	}
+}
+
+func TestReplayerRewritesJSONRPCIDs(t *testing.T) {
+	cassette := record(t, ProtocolJSONRPC, &greetInput{Name: "ada"})
+	replayer := NewReplayer(cassette)
+
+	body := `{"jsonrpc":"2.0","id":"replayed","method":"greet","params":{"name":"ada"}}`
+	req, err := http.NewRequest(http.MethodPost, replayBaseURL+rpcPath, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := replayer.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	var response rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+	if string(response.ID) != `"replayed"` {
+		t.Errorf("response id = %s, want the id of the call", response.ID)
+	}
+	if string(response.Result) != `{"greeting":"hello ada"}` {
+		t.Errorf("response result = %s", response.Result)
+	}
+}
@@ -0,0 +1,277 @@
+package pluggo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyKeyHeader carries the idempotency key of a logical function call.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// retryableHeader tells the client whether a failed call may be retried.
+	retryableHeader = "X-Pluggo-Retryable"
+
+	// Defaults applied to the zero fields of a RetryPolicy.
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+// RetryPolicy configures how failed function calls are retried. All attempts
+// of a call carry the same idempotency key, so that a plugin using
+// DeduplicationMiddleware executes the function at most once.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// Values lower than 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. Defaults to 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. Defaults to 10s.
+	MaxBackoff time.Duration
+	// Multiplier increases the delay after every attempt. Defaults to 2.
+	Multiplier float64
+	// Jitter randomly shortens every delay by up to this fraction, from 0 to 1,
+	// so that clients don't retry in lockstep.
+	Jitter float64
+	// RetryOn lists the error classes (see ErrorClassTransport and the other
+	// ErrorClass constants) that are retried. Defaults to ErrorClassTransport,
+	// ErrorClassTimeout and ErrorClassBusy; busy plugins are retried no sooner
+	// than they asked. Errors the plugin flagged with RetryableError are
+	// always retried.
+	RetryOn []string
+}
+
+// WithRetryPolicy sets the retry policy of the functions called through the
+// client. Calls are not retried by default.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(p *Client) {
+		p.retryPolicy = &policy
+	}
+}
+
+// SetRetryPolicy sets the retry policy of this function, overriding the
+// policy of the connection.
+func (f *Function[T, R]) SetRetryPolicy(policy RetryPolicy) {
+	f.retryPolicy = &policy
+}
+
+// attempts returns the maximum number of attempts of a call.
+func (p *RetryPolicy) attempts() int {
+	if p == nil {
+		return 1
+	}
+	return max(p.MaxAttempts, 1)
+}
+
+// retryable reports whether a call that failed with err, of class errClass, may be retried.
+func (p *RetryPolicy) retryable(errClass string, err error) bool {
+	var retryableErr *RetryableError
+	if errors.As(err, &retryableErr) {
+		return true
+	}
+
+	if p.RetryOn == nil {
+		return errClass == ErrorClassTransport || errClass == ErrorClassTimeout || errClass == ErrorClassBusy
+	}
+	return slices.Contains(p.RetryOn, errClass)
+}
+
+// backoff returns the delay before the retry following the given attempt, starting from 1.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial, maxBackoff, multiplier := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if initial <= 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	delay := min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(maxBackoff))
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 {
+		delay *= 1 - jitter*rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// idempotencyKeyKey is the context key of the idempotency key of a call.
+type idempotencyKeyKey struct{}
+
+// ContextWithIdempotencyKey returns a context whose function calls carry the
+// given idempotency key, e.g. to deduplicate calls retried by the caller
+// itself. Calls made without a key get a random one, shared by their retries.
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the idempotency key of the function call
+// carrying ctx in a plugin function, or the key set with ContextWithIdempotencyKey.
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyKey{}).(string)
+	return key, ok && key != ""
+}
+
+// idempotencyKey returns the idempotency key of ctx, or a new random key.
+func idempotencyKey(ctx context.Context) string {
+	if key, ok := IdempotencyKeyFromContext(ctx); ok {
+		return key
+	}
+	return newJobID()
+}
+
+// DeduplicationMiddleware executes a function at most once per idempotency
+// key within window: replays of a call, e.g. retries after a timeout, get the
+// response of the first call instead of executing the function again, and
+// replays received while the first call is running wait for its response.
+// Calls are identified by function, idempotency key and input; calls without
+// an idempotency key are not deduplicated. Only successful responses and the
+// errors the same call would fail with again, i.e. function errors and client
+// errors, are replayed: the calls that timed out, found the plugin busy,
+// panicked or failed with a response flagged as retryable are executed again.
+func DeduplicationMiddleware(window time.Duration) Middleware {
+	var (
+		mu        sync.Mutex
+		calls     = make(map[string]*dedupCall)
+		nextSweep time.Time
+	)
+
+	return func(next CallHandler) CallHandler {
+		return func(w http.ResponseWriter, r *http.Request, call *CallInfo) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next(w, r, call)
+				return
+			}
+
+			hash := sha256.Sum256(call.Input)
+			id := call.Function + "\x00" + key + "\x00" + hex.EncodeToString(hash[:])
+			now := time.Now()
+
+			mu.Lock()
+			// Expired calls are swept once per window
+			if now.After(nextSweep) {
+				for id, c := range calls {
+					if c.expired(now) {
+						delete(calls, id)
+					}
+				}
+				nextSweep = now.Add(window)
+			}
+			c, ok := calls[id]
+			if !ok || c.expired(now) {
+				ok = false
+				c = &dedupCall{done: make(chan struct{}), expires: now.Add(window)}
+				calls[id] = c
+			}
+			mu.Unlock()
+
+			if ok {
+				select {
+				case <-c.done:
+					if c.response != nil {
+						c.response.writeTo(w)
+						return
+					}
+				case <-r.Context().Done():
+					w.WriteHeader(http.StatusServiceUnavailable)
+					_, _ = w.Write([]byte(r.Context().Err().Error()))
+					return
+				}
+				next(w, r, call)
+				return
+			}
+
+			response := newResponseBuffer()
+			defer func() {
+				if replayable(response) {
+					c.response = response
+				} else {
+					mu.Lock()
+					if calls[id] == c {
+						delete(calls, id)
+					}
+					mu.Unlock()
+				}
+				close(c.done)
+			}()
+
+			next(response, r, call)
+			response.writeTo(w)
+		}
+	}
+}
+
+// dedupCall is a call tracked by DeduplicationMiddleware. response is set
+// when done is closed, unless the call may be executed again.
+type dedupCall struct {
+	done     chan struct{}
+	response *responseBuffer
+	expires  time.Time
+}
+
+// expired reports whether the call finished and its window elapsed.
+func (c *dedupCall) expired(now time.Time) bool {
+	select {
+	case <-c.done:
+		return c.expires.Before(now)
+	default:
+		return false
+	}
+}
+
+// replayable reports whether a response can be replayed to the replays of its
+// call: successful responses, client errors other than timeouts and rate
+// limits, and errors returned by the function that were not flagged as
+// retryable.
+func replayable(response *responseBuffer) bool {
+	if response.header.Get(retryableHeader) == "true" {
+		return false
+	}
+
+	switch status := response.status; {
+	case status < http.StatusBadRequest:
+		return true
+	case status == http.StatusRequestTimeout || status == http.StatusTooManyRequests:
+		return false
+	case status < http.StatusInternalServerError:
+		return true
+	default:
+		return status == http.StatusInternalServerError && response.header.Get(errorCodeHeader) == ""
+	}
+}
+
+// writeTo writes the captured response to w.
+func (w *responseBuffer) writeTo(rw http.ResponseWriter) {
+	for key, values := range w.header {
+		rw.Header()[key] = values
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	rw.WriteHeader(status)
+	_, _ = rw.Write(w.body.Bytes())
+}
@@ -0,0 +1,250 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyTransport fails the first failures requests after the plugin handled
+// them, as a connection lost before the response, and records the
+// idempotency keys of all requests.
+type flakyTransport struct {
+	mu       sync.Mutex
+	failures int
+	keys     []string
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.keys = append(t.keys, req.Header.Get(idempotencyKeyHeader))
+	if err == nil && t.failures > 0 {
+		t.failures--
+		_ = resp.Body.Close()
+		return nil, errors.New("connection reset")
+	}
+	return resp, err
+}
+
+// serveCounted serves a function counting its executions, returning the
+// error of fail for the executions it returns one for, behind
+// DeduplicationMiddleware.
+func serveCounted(t *testing.T, protocol Protocol, transport http.RoundTripper, fail func(execution int32) error) (*Function[greetInput, greetOutput], *atomic.Int32) {
+	t.Helper()
+
+	var executions atomic.Int32
+	p := newTestPlugin(t)
+	p.Use(DeduplicationMiddleware(time.Minute))
+	handler := NewFunctionHandler(func(ctx context.Context, in *greetInput) (*greetOutput, error) {
+		if err := fail(executions.Add(1)); err != nil {
+			return nil, err
+		}
+		key, _ := IdempotencyKeyFromContext(ctx)
+		return &greetOutput{Greeting: "hello " + in.Name + " " + key}, nil
+	}, nil).Handler()
+	if err := p.AddFunction("counted", handler); err != nil {
+		t.Fatal(err)
+	}
+
+	connection := serve(t, p, protocol)
+	connection.Transport = transport
+	f := newTestFunction[greetInput, greetOutput](t, "counted", connection)
+	f.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	return f, &executions
+}
+
+func TestRetryDeduplicated(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			transport := &flakyTransport{failures: 2}
+			f, executions := serveCounted(t, protocol, transport, func(int32) error { return nil })
+
+			ctx := ContextWithIdempotencyKey(context.Background(), "key-1")
+			out, err := f.CallContext(ctx, &greetInput{Name: "ada"})
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if out.Greeting != "hello ada key-1" {
+				t.Errorf("greeting = %q, want the idempotency key in the function", out.Greeting)
+			}
+			if got := executions.Load(); got != 1 {
+				t.Errorf("executions = %d, want 1", got)
+			}
+			if len(transport.keys) != 3 {
+				t.Fatalf("attempts = %d, want 3", len(transport.keys))
+			}
+			for _, key := range transport.keys {
+				if key != "key-1" {
+					t.Errorf("attempt idempotency keys = %v, want key-1", transport.keys)
+					break
+				}
+			}
+
+			if _, err := f.CallContext(ctx, &greetInput{Name: "bob"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := executions.Load(); got != 2 {
+				t.Errorf("executions with another input = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestRetryGeneratesSharedKey(t *testing.T) {
+	transport := &flakyTransport{failures: 1}
+	f, executions := serveCounted(t, ProtocolREST, transport, func(int32) error { return nil })
+
+	if _, err := f.Call(&greetInput{Name: "ada"}); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if len(transport.keys) != 2 || transport.keys[0] == "" || transport.keys[0] != transport.keys[1] {
+		t.Errorf("attempt idempotency keys = %q, want one random key", transport.keys)
+	}
+	if got := executions.Load(); got != 1 {
+		t.Errorf("executions = %d, want 1", got)
+	}
+
+	if _, err := f.Call(&greetInput{Name: "ada"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := executions.Load(); got != 2 {
+		t.Errorf("executions of a new call = %d, want 2", got)
+	}
+}
+
+func TestRetryableErrorNotDeduplicated(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			f, executions := serveCounted(t, protocol, nil, func(execution int32) error {
+				if execution == 1 {
+					return &RetryableError{Err: errors.New("try again")}
+				}
+				return nil
+			})
+
+			if _, err := f.Call(&greetInput{Name: "ada"}); err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if got := executions.Load(); got != 2 {
+				t.Errorf("executions = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestRetryStopsOnPermanentErrors(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			f, executions := serveCounted(t, protocol, nil, func(int32) error { return errors.New("boom") })
+
+			var executionErr *FunctionExecutionError
+			if _, err := f.Call(&greetInput{Name: "ada"}); !errors.As(err, &executionErr) {
+				t.Fatalf("error = %v, want a FunctionExecutionError", err)
+			}
+			if got := executions.Load(); got != 1 {
+				t.Errorf("executions = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDeduplicationReplays(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		errorCode  string
+		retryable  bool
+		wantReplay bool
+	}{
+		{name: "success", status: http.StatusOK, wantReplay: true},
+		{name: "invalid input", status: http.StatusBadRequest, errorCode: errorCodeInvalid, wantReplay: true},
+		{name: "function error", status: http.StatusInternalServerError, wantReplay: true},
+		{name: "retryable function error", status: http.StatusInternalServerError, retryable: true},
+		{name: "rate limited", status: http.StatusTooManyRequests},
+		{name: "panic", status: http.StatusInternalServerError, errorCode: errorCodePanic},
+		{name: "timeout", status: http.StatusServiceUnavailable, errorCode: errorCodeTimeout},
+		{name: "busy", status: http.StatusServiceUnavailable, errorCode: errorCodeBusy},
+		{name: "bad gateway", status: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executions int
+			handler := DeduplicationMiddleware(time.Minute)(func(w http.ResponseWriter, _ *http.Request, _ *CallInfo) {
+				executions++
+				if tt.errorCode != "" {
+					w.Header().Set(errorCodeHeader, tt.errorCode)
+				}
+				if tt.retryable {
+					w.Header().Set(retryableHeader, "true")
+				}
+				w.WriteHeader(tt.status)
+			})
+
+			for range 2 {
+				req := httptest.NewRequest(http.MethodPost, "/greet", nil)
+				req.Header.Set(idempotencyKeyHeader, "key-1")
+				rec := httptest.NewRecorder()
+				handler(rec, req, &CallInfo{Function: "greet", Input: []byte(`{"name":"ada"}`)})
+				if rec.Code != tt.status {
+					t.Errorf("status = %d, want %d", rec.Code, tt.status)
+				}
+			}
+
+			want := 2
+			if tt.wantReplay {
+				want = 1
+			}
+			if executions != want {
+				t.Errorf("executions = %d, want %d", executions, want)
+			}
+		})
+	}
+}
+
+func TestDeduplicationWindow(t *testing.T) {
+	var executions int
+	handler := DeduplicationMiddleware(10 * time.Millisecond)(func(w http.ResponseWriter, _ *http.Request, _ *CallInfo) {
+		executions++
+	})
+	call := func() {
+		req := httptest.NewRequest(http.MethodPost, "/greet", nil)
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		handler(httptest.NewRecorder(), req, &CallInfo{Function: "greet"})
+	}
+
+	call()
+	call()
+	time.Sleep(20 * time.Millisecond)
+	call()
+	if executions != 2 {
+		t.Errorf("executions = %d, want the call executed again once its window elapsed", executions)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Multiplier: 3}
+
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 30 * time.Millisecond, 3: 50 * time.Millisecond} {
+		if got := policy.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.backoff(1); got < 5*time.Millisecond || got > 10*time.Millisecond {
+			t.Fatalf("backoff with jitter = %v, want between 5ms and 10ms", got)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package pluggo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Protocol is the wire protocol used to call plugin functions.
+type Protocol string
+
+const (
+	// ProtocolREST calls every function with a POST request to /{functionName}.
+	ProtocolREST Protocol = "rest"
+	// ProtocolJSONRPC calls functions through a single JSON-RPC 2.0 endpoint,
+	// whose method names are the function names.
+	ProtocolJSONRPC Protocol = "jsonrpc"
+)
+
+const (
+	rpcPath    = "/_rpc"
+	rpcVersion = "2.0"
+
+	// protocolEnv is the environment variable used by the client to request a
+	// protocol from the plugin it launches.
+	protocolEnv = "PLUGGO_PROTOCOL"
+)
+
+// JSON-RPC error codes used by plugins. Codes in the -32000 range carry
+// errors raised by the function itself.
+const (
+	RPCCodeParseError        = -32700
+	RPCCodeInvalidRequest    = -32600
+	RPCCodeMethodNotFound    = -32601
+	RPCCodeInvalidParams     = -32602
+	RPCCodeInternalError     = -32603
+	RPCCodeExecutionError    = -32000
+	RPCCodePanic             = -32001
+	RPCCodeBusy              = -32002
+	RPCCodeContractViolation = -32003
+	RPCCodeTimeout           = -32004
+)
+
+// rpcRequestID generates the IDs of the JSON-RPC requests sent by clients.
+var rpcRequestID atomic.Uint64
+
+// rpcRequest is a JSON-RPC request or, when ID is missing, a notification.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC response.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is a JSON-RPC error object. Data holds the crash report of a
+// panicking function or the HTTP status of a failed call.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error implements the error interface for RPCError.
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// negotiateProtocol returns the protocol the plugin answers in its handshake,
+// given the value requested by the client. An empty value keeps the original
+// handshake, which only carries the port.
+func negotiateProtocol(requested string) Protocol {
+	switch Protocol(requested) {
+	case ProtocolREST, ProtocolJSONRPC:
+		return Protocol(requested)
+	default:
+		return ""
+	}
+}
+
+// parseHandshake parses the first line written by a plugin, either a bare
+// port or "port|protocol".
+func parseHandshake(line string) (port string, protocol Protocol) {
+	port, value, ok := strings.Cut(strings.TrimSpace(line), "|")
+	if !ok || value == "" {
+		return port, ProtocolREST
+	}
+	return port, Protocol(value)
+}
+
+// serveRPC serves the JSON-RPC endpoint. Every call is dispatched to the
+// function handler, so middleware, validation and metrics apply as with
+// ProtocolREST. Notifications run in the background and get no response.
+func (l *Plugin) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte("method not allowed"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writePayloadTooLarge(w, maxBytesErr.Limit)
+			return
+		}
+		l.writeRPC(w, rpcErrorResponse(nil, RPCCodeParseError, err.Error()))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		resp := l.rpcCall(r, body)
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		l.writeRPC(w, resp)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		l.writeRPC(w, rpcErrorResponse(nil, RPCCodeParseError, err.Error()))
+		return
+	}
+	if len(batch) == 0 {
+		l.writeRPC(w, rpcErrorResponse(nil, RPCCodeInvalidRequest, "empty batch"))
+		return
+	}
+
+	responses := make([]*rpcResponse, len(batch))
+
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = l.rpcCall(r, raw)
+		}()
+	}
+	wg.Wait()
+
+	results := make([]*rpcResponse, 0, len(responses))
+	for _, resp := range responses {
+		if resp != nil {
+			results = append(results, resp)
+		}
+	}
+
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	l.writeRPC(w, results)
+}
+
+// rpcCall executes a single JSON-RPC call. It returns nil for notifications.
+func (l *Plugin) rpcCall(r *http.Request, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return rpcErrorResponse(nil, RPCCodeParseError, err.Error())
+		}
+		return rpcErrorResponse(nil, RPCCodeInvalidRequest, err.Error())
+	}
+
+	if req.JSONRPC != rpcVersion || req.Method == "" {
+		return rpcErrorResponse(req.ID, RPCCodeInvalidRequest, "invalid JSON-RPC 2.0 request")
+	}
+
+	l.mu.RLock()
+	function, ok := l.functions[req.Method]
+	l.mu.RUnlock()
+
+	if !ok {
+		if req.ID == nil {
+			return nil
+		}
+		return rpcErrorResponse(req.ID, RPCCodeMethodNotFound, fmt.Sprintf("function %q not found", req.Method))
+	}
+
+	params := req.Params
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage("{}")
+	}
+
+	ctx := r.Context()
+	if req.ID == nil {
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	call := r.Clone(ctx)
+	call.Method = http.MethodPost
+	call.URL.Path = basePath + req.Method
+	call.Body = io.NopCloser(bytes.NewReader(params))
+	call.ContentLength = int64(len(params))
+
+	if req.ID == nil {
+		go function.handler.ServeHTTP(newResponseBuffer(), call)
+		return nil
+	}
+
+	rw := newResponseBuffer()
+	function.handler.ServeHTTP(rw, call)
+
+	return rw.response(req.ID)
+}
+
+// responseBuffer captures the response of a handler called outside of an HTTP
+// server, e.g. through JSON-RPC.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// newResponseBuffer creates an empty responseBuffer.
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header)}
+}
+
+// Header implements http.ResponseWriter.
+func (w *responseBuffer) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *responseBuffer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (w *responseBuffer) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// response converts the captured function response to a JSON-RPC response,
+// mapping the pluggo error codes to JSON-RPC error codes.
+func (w *responseBuffer) response(id json.RawMessage) *rpcResponse {
+	body := bytes.TrimSpace(w.body.Bytes())
+
+	if w.status == http.StatusOK || w.status == 0 {
+		if !json.Valid(body) {
+			return rpcErrorResponse(id, RPCCodeInternalError, "function returned invalid JSON")
+		}
+		return &rpcResponse{JSONRPC: rpcVersion, ID: id, Result: body}
+	}
+
+	switch w.header.Get(errorCodeHeader) {
+	case errorCodeNotFound:
+		return rpcErrorResponse(id, RPCCodeMethodNotFound, string(body))
+	case errorCodeInvalid:
+		validation := decodeValidationError("", w.header.Get("Content-Type"), body)
+		resp := rpcErrorResponse(id, RPCCodeInvalidParams, validation.Message)
+		if len(validation.Fields) > 0 {
+			resp.Error.Data, _ = json.Marshal(validationBody{Message: validation.Message, Fields: validation.Fields})
+		}
+		return resp
+	case errorCodePanic:
+		resp := rpcErrorResponse(id, RPCCodePanic, "function panicked")
+		resp.Error.Data = body
+		return resp
+	case errorCodeContractViolation:
+		return rpcErrorResponse(id, RPCCodeContractViolation, string(body))
+	case errorCodeTimeout:
+		return rpcErrorResponse(id, RPCCodeTimeout, string(body))
+	case errorCodeBusy:
+		seconds, _ := strconv.Atoi(w.header.Get("Retry-After"))
+		resp := rpcErrorResponse(id, RPCCodeBusy, string(body))
+		resp.Error.Data, _ = json.Marshal(map[string]int{"retryAfter": seconds})
+		return resp
+	}
+
+	data := map[string]any{"status": w.status}
+	if w.header.Get(retryableHeader) == "true" {
+		data["retryable"] = true
+	}
+
+	resp := rpcErrorResponse(id, RPCCodeExecutionError, string(body))
+	resp.Error.Data, _ = json.Marshal(data)
+	return resp
+}
+
+// rpcErrorResponse builds a JSON-RPC error response.
+func rpcErrorResponse(id json.RawMessage, code int, message string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: rpcVersion, ID: id, Error: &RPCError{Code: code, Message: message}}
+}
+
+// writeRPC writes a JSON-RPC response or batch of responses.
+func (l *Plugin) writeRPC(w http.ResponseWriter, v any) {
+	if err := encodeOutput(w, http.StatusOK, v); err != nil {
+		l.logger.Error("failed to encode JSON-RPC response", "error", err)
+	}
+}
+
+// newRPCRequest builds the HTTP request carrying a single JSON-RPC call, or a
+// notification when notify is true.
+func newRPCRequest(ctx context.Context, baseURL, function string, params []byte, notify bool) (*http.Request, error) {
+	req := rpcRequest{JSONRPC: rpcVersion, Method: function, Params: params}
+	if !notify {
+		req.ID = json.RawMessage(fmt.Sprint(rpcRequestID.Add(1)))
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.NewRequestWithContext(ctx, http.MethodPost, baseURL+rpcPath, bytes.NewReader(b))
+}
+
+// rpcResult extracts the result of a JSON-RPC response, mapping JSON-RPC
+// errors to the matching pluggo error type. It also returns the error class
+// used to label metrics.
+func rpcResult(function string, body []byte) (json.RawMessage, string, error) {
+	var resp rpcResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, ErrorClassDecode, &FunctionExecutionError{Function: function, Err: err}
+	}
+
+	if resp.Error == nil {
+		return resp.Result, "", nil
+	}
+
+	return nil, rpcErrorClass(resp.Error), rpcError(function, resp.Error)
+}
+
+// rpcError maps a JSON-RPC error to the matching pluggo error type.
+func rpcError(function string, e *RPCError) error {
+	switch e.Code {
+	case RPCCodeMethodNotFound:
+		return &FunctionNotFoundError{Function: function}
+	case RPCCodeInvalidParams:
+		var data validationBody
+		_ = json.Unmarshal(e.Data, &data)
+		return &ValidationError{Function: function, Message: e.Message, Fields: data.Fields}
+	case RPCCodePanic:
+		var report crashReport
+		if err := json.Unmarshal(e.Data, &report); err == nil {
+			return &PluginPanicError{Function: function, Value: report.Panic, Stack: report.Stack}
+		}
+	case RPCCodeBusy:
+		var data struct {
+			RetryAfter int `json:"retryAfter"`
+		}
+		_ = json.Unmarshal(e.Data, &data)
+		return &PluginBusyError{Function: function, RetryAfter: time.Duration(data.RetryAfter) * time.Second}
+	case RPCCodeContractViolation:
+		return &ContractViolationError{Function: function, Message: e.Message}
+	case RPCCodeTimeout:
+		return &FunctionTimeoutError{Function: function, Message: e.Message}
+	case RPCCodeExecutionError:
+		var data struct {
+			Retryable bool `json:"retryable"`
+		}
+		if err := json.Unmarshal(e.Data, &data); err == nil && data.Retryable {
+			return &FunctionExecutionError{Function: function, Err: &RetryableError{Err: e}}
+		}
+	}
+
+	return &FunctionExecutionError{Function: function, Err: e}
+}
+
+// rpcErrorClass classifies a JSON-RPC error for metrics.
+func rpcErrorClass(e *RPCError) string {
+	switch e.Code {
+	case RPCCodePanic:
+		return ErrorClassPanic
+	case RPCCodeBusy:
+		return ErrorClassBusy
+	case RPCCodeContractViolation:
+		return ErrorClassContractViolation
+	case RPCCodeTimeout:
+		return ErrorClassTimeout
+	case RPCCodeExecutionError, RPCCodeInternalError:
+		return ErrorClassServer
+	default:
+		return ErrorClassClient
+	}
+}
+
+// BatchCall is a function call sent with Client.Batch. Notify calls get no result.
+type BatchCall struct {
+	Function string
+	Input    json.RawMessage
+	Notify   bool
+}
+
+// BatchResult is the outcome of a BatchCall: the raw JSON output of the
+// function, or the error it failed with.
+type BatchResult struct {
+	Output json.RawMessage
+	Err    error
+}
+
+// Batch calls several functions at once. With ProtocolJSONRPC the calls are
+// sent as a single JSON-RPC batch and executed concurrently by the plugin;
+// with ProtocolREST they are executed one after the other. Results are
+// returned in the order of calls. The error is only set when the batch as a
+// whole could not be executed.
+func (c *Client) Batch(ctx context.Context, calls []BatchCall) ([]BatchResult, error) {
+	connection := c.Connection()
+	if connection == nil {
+		return nil, errors.New("plugin is not connected")
+	}
+
+	results := make([]BatchResult, len(calls))
+
+	if connection.Protocol != ProtocolJSONRPC {
+		for i, call := range calls {
+			output, err := c.Invoke(ctx, call.Function, call.Input)
+			results[i] = BatchResult{Err: err}
+			if !call.Notify {
+				results[i].Output = output
+			}
+		}
+		return results, nil
+	}
+
+	requests := make([]rpcRequest, len(calls))
+	for i, call := range calls {
+		params := call.Input
+		if len(params) == 0 {
+			params = json.RawMessage("{}")
+		}
+
+		requests[i] = rpcRequest{JSONRPC: rpcVersion, Method: call.Function, Params: params}
+		if !call.Notify {
+			requests[i].ID = json.RawMessage(fmt.Sprint(i))
+		}
+	}
+
+	b, err := json.Marshal(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, connection.baseURL()+rpcPath, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	propagator := connection.Propagator
+	if propagator == nil {
+		propagator = W3CPropagator{}
+	}
+	propagator.Inject(ctx, req.Header)
+
+	httpClient := &http.Client{Timeout: connection.FunctionExecutionTimeout, Transport: connection.transport()}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, &PluginExecutionError{Err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return results, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &PluginExecutionError{Err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &PluginExecutionError{Err: fmt.Errorf("plugin returned status %d: %s", resp.StatusCode, string(body))}
+	}
+
+	var responses []rpcResponse
+	if err := json.Unmarshal(body, &responses); err != nil {
+		var single rpcResponse
+		if json.Unmarshal(body, &single) == nil && single.Error != nil {
+			return nil, &PluginExecutionError{Err: single.Error}
+		}
+		return nil, &PluginExecutionError{Err: err}
+	}
+
+	received := make([]bool, len(calls))
+	for _, r := range responses {
+		var i int
+		if err := json.Unmarshal(r.ID, &i); err != nil || i < 0 || i >= len(calls) || calls[i].Notify {
+			continue
+		}
+
+		received[i] = true
+		if r.Error != nil {
+			results[i].Err = rpcError(calls[i].Function, r.Error)
+			if r.Error.Code == RPCCodePanic && connection.onPanic != nil {
+				connection.onPanic()
+			}
+			continue
+		}
+		results[i].Output = r.Result
+	}
+
+	for i, call := range calls {
+		if !call.Notify && !received[i] {
+			results[i].Err = &FunctionExecutionError{Function: call.Function, Err: errors.New("missing response in JSON-RPC batch")}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,177 @@
+package pluggo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			client := &Client{connection: serve(t, newTestPlugin(t), protocol)}
+
+			results, err := client.Batch(context.Background(), []BatchCall{
+				{Function: "greet", Input: json.RawMessage(`{"name":"ada"}`)},
+				{Function: "greet", Input: json.RawMessage(`{"name":""}`)},
+				{Function: "missing", Input: json.RawMessage(`{}`)},
+				{Function: "greet", Input: json.RawMessage(`{"name":"bob"}`), Notify: true},
+				{Function: "greet", Input: json.RawMessage(`{"name":"eve"}`)},
+			})
+			if err != nil {
+				t.Fatalf("Batch: %v", err)
+			}
+			if len(results) != 5 {
+				t.Fatalf("results = %d, want 5", len(results))
+			}
+
+			for i, want := range map[int]string{0: `{"greeting":"hello ada"}`, 4: `{"greeting":"hello eve"}`} {
+				if results[i].Err != nil || !jsonEqual(results[i].Output, want) {
+					t.Errorf("result %d = %s, %v, want %s", i, results[i].Output, results[i].Err, want)
+				}
+			}
+
+			var validation *ValidationError
+			if !errors.As(results[1].Err, &validation) {
+				t.Errorf("invalid call error = %v, want a ValidationError", results[1].Err)
+			}
+			var notFound *FunctionNotFoundError
+			if !errors.As(results[2].Err, &notFound) {
+				t.Errorf("unknown function error = %v, want a FunctionNotFoundError", results[2].Err)
+			}
+			if results[3].Output != nil || results[3].Err != nil {
+				t.Errorf("notification result = %s, %v, want none", results[3].Output, results[3].Err)
+			}
+		})
+	}
+}
+
+func TestNotificationsRunInBackground(t *testing.T) {
+	called := make(chan string, 2)
+	p := newTestPlugin(t)
+	handler := NewFunctionHandler(func(_ context.Context, in *greetInput) (*greetOutput, error) {
+		called <- in.Name
+		return &greetOutput{}, nil
+	}, nil).Handler()
+	if err := p.AddFunction("notify", handler); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{connection: serve(t, p, ProtocolJSONRPC)}
+
+	results, err := client.Batch(context.Background(), []BatchCall{
+		{Function: "notify", Input: json.RawMessage(`{"name":"ada"}`), Notify: true},
+		{Function: "notify", Input: json.RawMessage(`{"name":"bob"}`), Notify: true},
+	})
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	for i, result := range results {
+		if result.Output != nil || result.Err != nil {
+			t.Errorf("notification %d result = %s, %v, want none", i, result.Output, result.Err)
+		}
+	}
+
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case name := <-called:
+			got[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notifications ran for %v, want ada and bob", got)
+		}
+	}
+}
+
+func TestRPCEndpoint(t *testing.T) {
+	connection := serve(t, newTestPlugin(t), ProtocolJSONRPC)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "call",
+			body:       `{"jsonrpc":"2.0","id":7,"method":"greet","params":{"name":"ada"}}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"jsonrpc":"2.0","id":7,"result":{"greeting":"hello ada"}}`,
+		},
+		{
+			name:       "notification",
+			body:       `{"jsonrpc":"2.0","method":"greet","params":{"name":"ada"}}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "batch of notifications",
+			body:       `[{"jsonrpc":"2.0","method":"greet","params":{"name":"ada"}},{"jsonrpc":"2.0","method":"missing"}]`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "batch",
+			body:       `[{"jsonrpc":"2.0","id":"a","method":"greet","params":{"name":"ada"}},{"jsonrpc":"2.0","method":"greet","params":{"name":"bob"}},{"jsonrpc":"2.0","id":"b","method":"missing"}]`,
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"jsonrpc":"2.0","id":"a","result":{"greeting":"hello ada"}},{"jsonrpc":"2.0","id":"b","error":{"code":-32601,"message":"function \"missing\" not found"}}]`,
+		},
+		{
+			name:       "empty batch",
+			body:       `[]`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"empty batch"}}`,
+		},
+		{
+			name:       "invalid request",
+			body:       `{"id":1,"method":"greet"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"invalid JSON-RPC 2.0 request"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(connection.BaseURL+rpcPath, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantBody != "" && !jsonEqual(body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("parse error", func(t *testing.T) {
+		resp, err := http.Post(connection.BaseURL+rpcPath, "application/json", strings.NewReader(`{"jsonrpc":`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+
+		var response rpcResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Error == nil || response.Error.Code != RPCCodeParseError {
+			t.Errorf("error = %+v, want a parse error", response.Error)
+		}
+	})
+}
+
+// jsonEqual reports whether a JSON document is semantically equal to want.
+func jsonEqual(got []byte, want string) bool {
+	return MatchExact()(got, []byte(want))
+}
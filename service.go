@@ -0,0 +1,210 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// serviceOptions holds the optional configuration of RegisterService.
+type serviceOptions struct {
+	prefix           string
+	handlerOptions   []HandlerOption
+	functionOptions  []FunctionOption
+	validatorOptions []ValidatorOption
+	hooks            map[reflect.Type][]func(context.Context, any) error
+}
+
+// ServiceOption is a function that configures RegisterService.
+type ServiceOption func(*serviceOptions)
+
+// WithServicePrefix prepends prefix to every function name derived from the
+// service methods, e.g. "math." turns method Add into function "math.add".
+func WithServicePrefix(prefix string) ServiceOption {
+	return func(o *serviceOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithServiceHandlerOptions applies the given handler options to every function of the service.
+func WithServiceHandlerOptions(opts ...HandlerOption) ServiceOption {
+	return func(o *serviceOptions) {
+		o.handlerOptions = append(o.handlerOptions, opts...)
+	}
+}
+
+// WithServiceFunctionOptions applies the given function options to every function of the service.
+func WithServiceFunctionOptions(opts ...FunctionOption) ServiceOption {
+	return func(o *serviceOptions) {
+		o.functionOptions = append(o.functionOptions, opts...)
+	}
+}
+
+// WithServiceValidatorOptions applies the given validator options to the
+// validators generated for the functions of the service.
+func WithServiceValidatorOptions(opts ...ValidatorOption) ServiceOption {
+	return func(o *serviceOptions) {
+		o.validatorOptions = append(o.validatorOptions, opts...)
+	}
+}
+
+// WithServiceHook adds a validation hook, as Validator.AddHook does, to the
+// functions of the service taking a *T input. It can be given several times,
+// for different input types or to add several hooks to the same one.
+func WithServiceHook[T any](hook func(ctx context.Context, input *T) error) ServiceOption {
+	return func(o *serviceOptions) {
+		if o.hooks == nil {
+			o.hooks = make(map[reflect.Type][]func(context.Context, any) error)
+		}
+
+		inputType := reflect.TypeFor[T]()
+		o.hooks[inputType] = append(o.hooks[inputType], func(ctx context.Context, input any) error {
+			return hook(ctx, input.(*T))
+		})
+	}
+}
+
+// RegisterService registers every exported method of svc as a plugin function.
+// Methods must have the shape func(context.Context, *T) (*R, error); each one
+// gets a validator generated from T, running the hooks added with
+// WithServiceHook for T. Function names are derived from method names in
+// snake_case, e.g. SayHello becomes say_hello, optionally prefixed with
+// WithServicePrefix.
+//
+// If any exported method has an unsupported signature, a validator can't be
+// generated or a function can't be registered, an error describing every
+// offending method is returned and no function is registered.
+func (l *Plugin) RegisterService(svc any, opts ...ServiceOption) error {
+	var options serviceOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	value := reflect.ValueOf(svc)
+	if !value.IsValid() || value.NumMethod() == 0 {
+		return fmt.Errorf("service %T has no exported methods", svc)
+	}
+
+	type function struct {
+		name    string
+		handler *Handler
+	}
+
+	var (
+		functions []function
+		errs      []error
+	)
+
+	for i := 0; i < value.NumMethod(); i++ {
+		method := value.Type().Method(i)
+
+		handler, err := methodHandler(value.Method(i), &options)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("method %s: %w", method.Name, err))
+			continue
+		}
+
+		functions = append(functions, function{
+			name:    options.prefix + snakeCase(method.Name),
+			handler: handler,
+		})
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot register service %T: %w", svc, errors.Join(errs...))
+	}
+
+	for i, f := range functions {
+		if err := l.AddFunction(f.name, f.handler, options.functionOptions...); err != nil {
+			for _, registered := range functions[:i] {
+				_ = l.RemoveFunction(registered.name)
+			}
+			return fmt.Errorf("cannot register service %T: %w", svc, err)
+		}
+	}
+
+	return nil
+}
+
+// methodHandler builds a validated function handler for a bound service method.
+func methodHandler(method reflect.Value, options *serviceOptions) (*Handler, error) {
+	methodType := method.Type()
+
+	if methodType.NumIn() != 2 || methodType.In(0) != contextType || methodType.In(1).Kind() != reflect.Pointer ||
+		methodType.NumOut() != 2 || methodType.Out(0).Kind() != reflect.Pointer || methodType.Out(1) != errorType {
+		return nil, fmt.Errorf("unsupported signature %s, expected func(context.Context, *T) (*R, error)", methodType)
+	}
+
+	input := reflect.New(methodType.In(1).Elem()).Interface()
+	output := reflect.New(methodType.Out(0).Elem()).Interface()
+
+	validator, err := newValidator[any](input, options.validatorOptions)
+	if err != nil {
+		return nil, err
+	}
+	hooks := options.hooks[methodType.In(1).Elem()]
+
+	call := func(ctx context.Context, input any) (any, error) {
+		results := method.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(input)})
+		err, _ := results[1].Interface().(error)
+		return results[0].Interface(), err
+	}
+
+	return newHandler(input, output, call, serviceValidator{Validator: validator, hooks: hooks}, options.handlerOptions), nil
+}
+
+// serviceValidator is the validator of a service method: a Validator of the
+// method input in its untyped form, running the hooks added with
+// WithServiceHook for the input type.
+type serviceValidator struct {
+	*Validator[any]
+	hooks []func(context.Context, any) error
+}
+
+// runHooks runs the hooks of the service on a decoded input, returning a
+// ValidationError merging their field errors, or the first other error.
+func (v serviceValidator) runHooks(ctx context.Context, input any) error {
+	var fields []FieldError
+	for _, hook := range v.hooks {
+		if err := hook(ctx, input); err != nil {
+			violations, ok := hookViolations(err)
+			if !ok {
+				return err
+			}
+			fields = append(fields, violations...)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return newValidationError(fields)
+}
+
+// snakeCase converts a Go method name to snake_case, keeping acronyms
+// together: SayHello becomes say_hello and GetURLInfo becomes get_url_info.
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"Greet":      "greet",
+		"SayHello":   "say_hello",
+		"GetURLInfo": "get_url_info",
+		"URL":        "url",
+		"ParseJSON":  "parse_json",
+		"Base64Data": "base64_data",
+		"V2Greet":    "v2_greet",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// greetService is a service whose methods are all valid functions.
+type greetService struct{}
+
+func (greetService) SayHello(ctx context.Context, in *greetInput) (*greetOutput, error) {
+	return greet(ctx, in)
+}
+
+func (greetService) GetURLInfo(_ context.Context, in *greetInput) (*greetOutput, error) {
+	return &greetOutput{Greeting: "info " + in.Name}, nil
+}
+
+// brokenService is a service with methods that can't be functions.
+type brokenService struct{}
+
+func (brokenService) SayHello(ctx context.Context, in *greetInput) (*greetOutput, error) {
+	return greet(ctx, in)
+}
+
+func (brokenService) NoContext(in *greetInput) (*greetOutput, error) {
+	return greet(context.Background(), in)
+}
+
+func (brokenService) NoError(_ context.Context, _ *greetInput) *greetOutput {
+	return nil
+}
+
+func TestRegisterService(t *testing.T) {
+	p := newTestPlugin(t)
+	if err := p.RegisterService(greetService{}, WithServicePrefix("svc.")); err != nil {
+		t.Fatalf("RegisterService: %v", err)
+	}
+
+	schemas := p.Schemas()
+	for _, name := range []string{"svc.say_hello", "svc.get_url_info"} {
+		properties, _ := schemas[name].Input["properties"].(map[string]any)
+		if _, ok := properties["name"]; !ok {
+			t.Errorf("schema of %s = %+v, want the properties of the input", name, schemas[name].Input)
+		}
+	}
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			connection := serve(t, p, protocol)
+
+			out, err := newTestFunction[greetInput, greetOutput](t, "svc.get_url_info", connection).Call(&greetInput{Name: "ada"})
+			if err != nil || out.Greeting != "info ada" {
+				t.Errorf("service method = %v, %v", out, err)
+			}
+
+			var validation *ValidationError
+			if _, err := newTestFunction[greetInput, greetOutput](t, "svc.say_hello", connection).Call(&greetInput{}); !errors.As(err, &validation) {
+				t.Errorf("invalid input = %v, want a ValidationError from the generated validator", err)
+			}
+		})
+	}
+}
+
+func TestRegisterServiceErrors(t *testing.T) {
+	t.Run("unsupported methods", func(t *testing.T) {
+		p := newTestPlugin(t)
+
+		err := p.RegisterService(brokenService{})
+		if err == nil {
+			t.Fatal("service with unsupported methods was registered")
+		}
+		for _, method := range []string{"NoContext", "NoError"} {
+			if !strings.Contains(err.Error(), "method "+method) {
+				t.Errorf("error %q does not report method %s", err, method)
+			}
+		}
+		if _, ok := p.Schemas()["say_hello"]; ok {
+			t.Error("valid method of a rejected service was registered")
+		}
+	})
+
+	t.Run("no methods", func(t *testing.T) {
+		if err := newTestPlugin(t).RegisterService(struct{}{}); err == nil {
+			t.Error("service without methods was registered")
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		p := newTestPlugin(t)
+		if err := p.AddFunction("say_hello", NewFunctionHandler(greet, nil).Handler()); err != nil {
+			t.Fatal(err)
+		}
+
+		// Methods are registered in name order: get_url_info is registered
+		// before say_hello conflicts with the existing function
+		var registration *FunctionRegistrationError
+		if err := p.RegisterService(greetService{}); !errors.As(err, &registration) || registration.Function != "say_hello" {
+			t.Fatalf("error = %v, want the FunctionRegistrationError of say_hello", err)
+		}
+
+		schemas := p.Schemas()
+		if _, ok := schemas["get_url_info"]; ok {
+			t.Error("functions registered before the failure were not removed")
+		}
+		if _, ok := schemas["say_hello"]; !ok {
+			t.Error("the conflicting function was removed")
+		}
+	})
+}
@@ -0,0 +1,526 @@
+package pluggo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// transportEnv is the environment variable used by the client to request
+	// the stdio transport from the plugin it launches.
+	transportEnv = "PLUGGO_TRANSPORT"
+
+	// stdioHandshake replaces the port in the handshake of plugins served over stdio.
+	stdioHandshake = "stdio"
+
+	// stdioBaseURL is the base URL of connections to plugins served over stdio.
+	stdioBaseURL = "http://stdio.pluggo"
+
+	// maxStdioFrameSize is the maximum size of a single frame.
+	maxStdioFrameSize = 64 << 20
+
+	// stdioFramePrefixSize is the size of the beginning of an oversized frame
+	// kept to read its id.
+	stdioFramePrefixSize = 4 << 10
+)
+
+// ErrTransportClosed is returned by calls in flight when the stdio transport
+// of a plugin is closed, e.g. because the plugin exited.
+var ErrTransportClosed = errors.New("stdio transport closed")
+
+// stdioFrame is a newline-delimited JSON message exchanged over stdio. Requests
+// carry Method and Path, responses carry Status; both are matched by ID. A
+// frame with Cancel set aborts the request with the same ID.
+type stdioFrame struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method,omitempty"`
+	Path   string      `json:"path,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+	Cancel bool        `json:"cancel,omitempty"`
+}
+
+// WithStdioTransport serves the plugin over its stdin and stdout instead of
+// a TCP socket, even if the client didn't ask for it. Plugins also switch to
+// the stdio transport when launched by a client created with WithStdio.
+func WithStdioTransport() PluginOption {
+	return func(l *Plugin) {
+		l.stdio = true
+	}
+}
+
+// startStdio serves the plugin over stdin and stdout until stdin is closed
+// or the plugin is stopped. Anything the plugin writes to os.Stdout is
+// redirected to os.Stderr so that it can't corrupt the frames.
+func (l *Plugin) startStdio(protocol Protocol) error {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	handshake := stdioHandshake
+	if protocol != "" {
+		handshake += "|" + string(protocol)
+	}
+
+	// First line to stdout MUST be the handshake so the launcher can parse it
+	if _, err := fmt.Fprintln(out, handshake); err != nil {
+		l.logger.Error("failed to write handshake", "error", err)
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.mu.Lock()
+	l.stopStdio = cancel
+	l.mu.Unlock()
+
+	if err := l.serveStdio(ctx, os.Stdin, out); err != nil {
+		l.logger.Error("failed to serve stdio", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// serveStdio reads request frames from r, serves them concurrently with the
+// plugin mux and writes the response frames to w. Once r is exhausted it
+// waits for the requests in flight before returning; when ctx is cancelled
+// they are cancelled too.
+func (l *Plugin) serveStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+
+	var (
+		writeMu  sync.Mutex
+		cancelMu sync.Mutex
+		cancels  = make(map[uint64]context.CancelFunc)
+	)
+
+	writeFrame := func(frame stdioFrame) {
+		b, err := json.Marshal(frame)
+		if err != nil {
+			l.logger.Error("failed to encode stdio frame", "error", err)
+			return
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			l.logger.Error("failed to write stdio frame", "error", err)
+		}
+	}
+
+	frames := make(chan stdioFrame)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(frames)
+
+		reader := bufio.NewReader(r)
+		for {
+			line, tooLarge, err := readFrame(reader, l.maxFrameSize)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+
+			var frame stdioFrame
+			if tooLarge {
+				l.logger.Error("stdio frame too large", "limit", l.maxFrameSize)
+				l.rejectFrame(line, writeFrame, errorCodePayloadTooLarge, http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("request frame exceeds %d bytes", l.maxFrameSize))
+				continue
+			}
+			if err := json.Unmarshal(line, &frame); err != nil {
+				l.logger.Error("failed to decode stdio frame", "error", err)
+				l.rejectFrame(line, writeFrame, "", http.StatusBadRequest, fmt.Sprintf("invalid request frame: %v", err))
+				continue
+			}
+
+			select {
+			case frames <- frame:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frame, ok := <-frames:
+			if !ok {
+				return <-readErr
+			}
+
+			if frame.Cancel {
+				cancelMu.Lock()
+				if cancelRequest, ok := cancels[frame.ID]; ok {
+					cancelRequest()
+				}
+				cancelMu.Unlock()
+				continue
+			}
+
+			reqCtx, cancelRequest := context.WithCancel(ctx)
+			cancelMu.Lock()
+			cancels[frame.ID] = cancelRequest
+			cancelMu.Unlock()
+
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				defer func() {
+					cancelMu.Lock()
+					delete(cancels, frame.ID)
+					cancelMu.Unlock()
+					cancelRequest()
+				}()
+
+				writeFrame(l.serveFrame(reqCtx, frame))
+			}()
+		}
+	}
+}
+
+// rejectFrame answers a request frame that can't be served with an error
+// response. Frames whose id can't be read get no response.
+func (l *Plugin) rejectFrame(data []byte, writeFrame func(stdioFrame), code string, status int, message string) {
+	id, ok := frameID(data)
+	if !ok {
+		l.logger.Error("failed to read the id of a rejected stdio frame")
+		return
+	}
+
+	header := http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}}
+	if code != "" {
+		header.Set(errorCodeHeader, code)
+	}
+	writeFrame(stdioFrame{ID: id, Status: status, Header: header, Body: []byte(message)})
+}
+
+// readFrame reads a newline-delimited frame of at most limit bytes. The rest
+// of larger frames is discarded: only their first stdioFramePrefixSize bytes
+// are returned, with tooLarge set, so that they can still be answered.
+func readFrame(r *bufio.Reader, limit int) (frame []byte, tooLarge bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			if len(frame)+len(chunk) > limit {
+				tooLarge = true
+				frame = append(frame, chunk...)[:min(len(frame)+len(chunk), stdioFramePrefixSize)]
+			} else {
+				frame = append(frame, chunk...)
+			}
+		}
+
+		switch {
+		case err == nil:
+			return bytes.TrimSuffix(frame, []byte("\n")), tooLarge, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(frame) > 0:
+			return frame, tooLarge, nil
+		default:
+			return nil, false, err
+		}
+	}
+}
+
+// frameID reads the id of a frame that can't be decoded, e.g. because it is
+// truncated, decoding its fields up to the id.
+func frameID(data []byte) (uint64, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		return 0, false
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, false
+		}
+		if key == "id" {
+			var id uint64
+			if err := dec.Decode(&id); err != nil {
+				return 0, false
+			}
+			return id, true
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// serveFrame serves a request frame with the plugin mux, with the same
+// semantics as a request received over HTTP.
+func (l *Plugin) serveFrame(ctx context.Context, frame stdioFrame) stdioFrame {
+	req, err := http.NewRequestWithContext(ctx, frame.Method, stdioBaseURL+frame.Path, bytes.NewReader(frame.Body))
+	if err != nil {
+		return stdioFrame{ID: frame.ID, Status: http.StatusBadRequest, Body: []byte(err.Error())}
+	}
+	if frame.Header != nil {
+		req.Header = frame.Header
+	}
+	req.RemoteAddr = stdioHandshake
+
+	rw := newResponseBuffer()
+	l.withLogger(l.limitBody(l.mux)).ServeHTTP(rw, req)
+
+	status := rw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return stdioFrame{ID: frame.ID, Status: status, Header: rw.header, Body: rw.body.Bytes()}
+}
+
+// stdioTransport is an http.RoundTripper sending requests to a plugin over its
+// stdin and reading the responses from its stdout. It outlives the plugin
+// process: Client.Restart attaches the pipes of the new process, so functions
+// created before the restart keep working.
+type stdioTransport struct {
+	mu      sync.Mutex
+	session *stdioSession
+}
+
+// attach starts a session over the pipes of a plugin process, closing the previous one.
+func (t *stdioTransport) attach(w io.WriteCloser, r io.Reader) {
+	session := &stdioSession{w: w, pending: make(map[uint64]chan stdioReply)}
+	go session.read(r)
+
+	t.mu.Lock()
+	previous := t.session
+	t.session = session
+	t.mu.Unlock()
+
+	if previous != nil {
+		previous.close(ErrTransportClosed)
+	}
+}
+
+// detach closes the current session, failing the calls in flight.
+func (t *stdioTransport) detach() {
+	t.mu.Lock()
+	session := t.session
+	t.session = nil
+	t.mu.Unlock()
+
+	if session != nil {
+		session.close(ErrTransportClosed)
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *stdioTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	session := t.session
+	t.mu.Unlock()
+
+	if session == nil {
+		return nil, ErrTransportClosed
+	}
+
+	return session.roundTrip(req)
+}
+
+// stdioSession multiplexes requests over the pipes of a single plugin process.
+type stdioSession struct {
+	nextID  atomic.Uint64
+	writeMu sync.Mutex
+	w       io.WriteCloser
+
+	mu      sync.Mutex
+	pending map[uint64]chan stdioReply
+	err     error
+}
+
+// stdioReply is the response frame to a request, or the error reading it.
+type stdioReply struct {
+	frame *stdioFrame
+	err   error
+}
+
+// roundTrip sends a request frame and waits for the matching response frame.
+func (s *stdioSession) roundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	id := s.nextID.Add(1)
+	ch := make(chan stdioReply, 1)
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.pending[id] = ch
+	s.mu.Unlock()
+
+	err := s.write(stdioFrame{ID: id, Method: req.Method, Path: req.URL.RequestURI(), Header: req.Header, Body: body})
+	if err != nil {
+		s.forget(id)
+		return nil, err
+	}
+
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return nil, s.err
+		}
+		if reply.err != nil {
+			return nil, reply.err
+		}
+		frame := reply.frame
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", frame.Status, http.StatusText(frame.Status)),
+			StatusCode:    frame.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        frame.Header,
+			Body:          io.NopCloser(bytes.NewReader(frame.Body)),
+			ContentLength: int64(len(frame.Body)),
+			Request:       req,
+		}, nil
+	case <-req.Context().Done():
+		s.forget(id)
+		_ = s.write(stdioFrame{ID: id, Cancel: true})
+		return nil, req.Context().Err()
+	}
+}
+
+// write sends a frame to the plugin.
+func (s *stdioSession) write(frame stdioFrame) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// forget stops waiting for the response to a request.
+func (s *stdioSession) forget(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, id)
+}
+
+// read dispatches the response frames to the pending requests until the
+// plugin closes its stdout.
+func (s *stdioSession) read(r io.Reader) {
+	reader := bufio.NewReader(r)
+
+	var err error
+	for {
+		var (
+			line     []byte
+			tooLarge bool
+		)
+		line, tooLarge, err = readFrame(reader, maxStdioFrameSize)
+		if err != nil {
+			break
+		}
+
+		var reply stdioReply
+		if tooLarge {
+			id, ok := frameID(line)
+			if !ok {
+				slog.Error("stdio frame too large", "limit", maxStdioFrameSize)
+				continue
+			}
+			reply = stdioReply{frame: &stdioFrame{ID: id}, err: &ResponseTooLargeError{Limit: maxStdioFrameSize}}
+		} else {
+			var frame stdioFrame
+			if err := json.Unmarshal(line, &frame); err != nil {
+				slog.Error("failed to decode stdio frame", "error", err)
+				continue
+			}
+			if frame.Header == nil {
+				frame.Header = make(http.Header)
+			}
+			reply = stdioReply{frame: &frame}
+		}
+
+		s.mu.Lock()
+		ch, ok := s.pending[reply.frame.ID]
+		delete(s.pending, reply.frame.ID)
+		s.mu.Unlock()
+
+		if ok {
+			ch <- reply
+		}
+	}
+
+	closeErr := ErrTransportClosed
+	if !errors.Is(err, io.EOF) {
+		closeErr = fmt.Errorf("%w: %v", ErrTransportClosed, err)
+	}
+	s.close(closeErr)
+}
+
+// close fails the pending requests with err and closes the plugin stdin.
+func (s *stdioSession) close(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	pending := s.pending
+	s.pending = make(map[uint64]chan stdioReply)
+	s.mu.Unlock()
+
+	for _, ch := range pending {
+		close(ch)
+	}
+
+	_ = s.w.Close()
+}
+
+// recordingTransport records the exchanges of another transport with a Recorder.
+type recordingTransport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.recorder.roundTrip(req, t.next)
+}
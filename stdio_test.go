@@ -0,0 +1,268 @@
+package pluggo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveStdioPipes serves p over pipes until the end of the test. It returns
+// the input and the output of the plugin.
+func serveStdioPipes(t *testing.T, p *Plugin) (io.WriteCloser, io.Reader) {
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.serveStdio(context.Background(), inR, outW)
+		_ = outW.Close()
+	}()
+	t.Cleanup(func() {
+		_ = inW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serveStdio: %v", err)
+		}
+	})
+
+	return inW, outR
+}
+
+// stdioConnection returns a connection to p served over stdio.
+func stdioConnection(t *testing.T, p *Plugin) *Connection {
+	t.Helper()
+
+	in, out := serveStdioPipes(t, p)
+	transport := &stdioTransport{}
+	transport.attach(in, out)
+	t.Cleanup(transport.detach)
+
+	return &Connection{BaseURL: stdioBaseURL, Transport: transport, FunctionExecutionTimeout: 10 * time.Second}
+}
+
+// addBlockingFunction registers a function that blocks until release is
+// closed or its context is done, reporting its start on started and the
+// error of its context on stopped.
+func addBlockingFunction(t *testing.T, p *Plugin, release <-chan struct{}) (started chan struct{}, stopped chan error) {
+	t.Helper()
+
+	started = make(chan struct{}, 1)
+	stopped = make(chan error, 1)
+	handler := NewFunctionHandler(func(ctx context.Context, in *greetInput) (*greetOutput, error) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			stopped <- nil
+			return &greetOutput{Greeting: "released " + in.Name}, nil
+		case <-ctx.Done():
+			stopped <- ctx.Err()
+			return nil, ctx.Err()
+		}
+	}, nil).Handler()
+	if err := p.AddFunction("block", handler); err != nil {
+		t.Fatal(err)
+	}
+
+	return started, stopped
+}
+
+func TestStdioMultiplexing(t *testing.T) {
+	p := newTestPlugin(t)
+	release := make(chan struct{})
+	started, _ := addBlockingFunction(t, p, release)
+	connection := stdioConnection(t, p)
+
+	blocked := make(chan error, 1)
+	go func() {
+		out, err := newTestFunction[greetInput, greetOutput](t, "block", connection).Call(&greetInput{Name: "ada"})
+		if err == nil && out.Greeting != "released ada" {
+			err = errors.New("unexpected greeting " + out.Greeting)
+		}
+		blocked <- err
+	}()
+	<-started
+
+	greet := newTestFunction[greetInput, greetOutput](t, "greet", connection)
+	var wg sync.WaitGroup
+	for _, name := range []string{"bob", "eve", "joe", "max"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			out, err := greet.Call(&greetInput{Name: name})
+			if err != nil {
+				t.Errorf("call %s while another is in flight: %v", name, err)
+				return
+			}
+			if out.Greeting != "hello "+name {
+				t.Errorf("greeting = %q, want the response to the %s call", out.Greeting, name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+	if err := <-blocked; err != nil {
+		t.Errorf("blocked call: %v", err)
+	}
+}
+
+func TestStdioTimeoutCancelsCall(t *testing.T) {
+	p := newTestPlugin(t)
+	started, stopped := addBlockingFunction(t, p, nil)
+	connection := stdioConnection(t, p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := newTestFunction[greetInput, greetOutput](t, "block", connection).CallContext(ctx, &greetInput{Name: "ada"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+	<-started
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("function context error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the function was not cancelled")
+	}
+
+	out, err := newTestFunction[greetInput, greetOutput](t, "greet", connection).Call(&greetInput{Name: "ada"})
+	if err != nil || out.Greeting != "hello ada" {
+		t.Errorf("call after a timeout = %v, %v", out, err)
+	}
+}
+
+func TestStdioOversizedFrame(t *testing.T) {
+	p := newTestPlugin(t)
+	p.maxFrameSize = 1 << 10
+	greet := newTestFunction[greetInput, greetOutput](t, "greet", stdioConnection(t, p))
+
+	_, err := greet.Call(&greetInput{Name: strings.Repeat("a", 2<<10)})
+	var tooLarge *PayloadTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("error = %v, want a PayloadTooLargeError", err)
+	}
+
+	out, err := greet.Call(&greetInput{Name: "ada"})
+	if err != nil || out.Greeting != "hello ada" {
+		t.Errorf("call after an oversized frame = %v, %v", out, err)
+	}
+}
+
+func TestStdioInvalidFrames(t *testing.T) {
+	p := newTestPlugin(t)
+	p.maxFrameSize = 1 << 10
+	in, out := serveStdioPipes(t, p)
+	responses := bufio.NewScanner(out)
+
+	readResponse := func(t *testing.T) stdioFrame {
+		t.Helper()
+
+		if !responses.Scan() {
+			t.Fatalf("no response frame: %v", responses.Err())
+		}
+		var frame stdioFrame
+		if err := json.Unmarshal(responses.Bytes(), &frame); err != nil {
+			t.Fatalf("invalid response frame %s: %v", responses.Bytes(), err)
+		}
+		return frame
+	}
+
+	tests := []struct {
+		name       string
+		frame      string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "undecodable", frame: `{"id":41,"method":"POST","path":"/greet","body":"not base64!"}`, wantStatus: http.StatusBadRequest},
+		{name: "truncated", frame: `{"id":42,"method":"POST","path":`, wantStatus: http.StatusBadRequest},
+		{name: "oversized", frame: `{"id":43,"method":"POST","path":"/greet","body":"` + strings.Repeat("A", 2<<10) + `"}`, wantStatus: http.StatusRequestEntityTooLarge, wantCode: errorCodePayloadTooLarge},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := io.WriteString(in, "not a frame\n"+tt.frame+"\n"); err != nil {
+				t.Fatal(err)
+			}
+
+			frame := readResponse(t)
+			if frame.ID != uint64(41+i) || frame.Status != tt.wantStatus {
+				t.Errorf("response = id %d status %d, want id %d status %d", frame.ID, frame.Status, 41+i, tt.wantStatus)
+			}
+			if code := frame.Header.Get(errorCodeHeader); code != tt.wantCode {
+				t.Errorf("error code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("session is alive", func(t *testing.T) {
+		b, _ := json.Marshal(stdioFrame{ID: 50, Method: http.MethodPost, Path: "/greet", Body: []byte(`{"name":"ada"}`)})
+		if _, err := in.Write(append(b, '\n')); err != nil {
+			t.Fatal(err)
+		}
+
+		frame := readResponse(t)
+		if frame.ID != 50 || frame.Status != http.StatusOK || !jsonEqual(frame.Body, `{"greeting":"hello ada"}`) {
+			t.Errorf("response = id %d status %d body %s", frame.ID, frame.Status, frame.Body)
+		}
+	})
+}
+
+func TestStdioWaitsForRequestsAfterEOF(t *testing.T) {
+	p := newTestPlugin(t)
+	release := make(chan struct{})
+	started, stopped := addBlockingFunction(t, p, release)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- p.serveStdio(context.Background(), inR, outW)
+		_ = outW.Close()
+	}()
+
+	b, _ := json.Marshal(stdioFrame{ID: 1, Method: http.MethodPost, Path: "/block", Body: []byte(`{"name":"ada"}`)})
+	if _, err := inW.Write(append(b, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// Half-closing stdin must neither end the session nor cancel the call
+	_ = inW.Close()
+	select {
+	case err := <-done:
+		t.Fatalf("serveStdio returned with a call in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	responses := bufio.NewScanner(outR)
+	if !responses.Scan() {
+		t.Fatalf("no response frame: %v", responses.Err())
+	}
+	var frame stdioFrame
+	if err := json.Unmarshal(responses.Bytes(), &frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.ID != 1 || frame.Status != http.StatusOK || !jsonEqual(frame.Body, `{"greeting":"released ada"}`) {
+		t.Errorf("response = id %d status %d body %s", frame.ID, frame.Status, frame.Body)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("in-flight call stopped with %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serveStdio: %v", err)
+	}
+}
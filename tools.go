@@ -0,0 +1,452 @@
+package pluggo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Error types reported in ToolError.
+const (
+	ToolErrorNotFound         = "not_found"
+	ToolErrorInvalidArguments = "invalid_arguments"
+	ToolErrorPanic            = "panic"
+	ToolErrorExecution        = "execution_error"
+)
+
+// Tool is a provider-neutral description of a plugin function exposed as an
+// LLM tool. Use the OpenAITools, AnthropicTools and GeminiFunctionDeclarations
+// helpers to render it in the format expected by each API.
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+	Function    string         `json:"-"`
+}
+
+// MaxToolNameLength is the maximum length of a tool name accepted by the
+// major LLM APIs.
+const MaxToolNameLength = 64
+
+// toolNameHashLength is the length of the hash suffix of shortened tool names.
+const toolNameHashLength = 8
+
+// Tools describes every function of the catalog as an LLM tool, sorted by name.
+// Tool names are derived from function names with ToolName.
+func (s Schemas) Tools() []Tool {
+	tools := make([]Tool, 0, len(s))
+	for function, schema := range s {
+		tools = append(tools, newTool("", function, schema))
+	}
+
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+
+	return tools
+}
+
+// ToolName derives an LLM tool name from a function name. Tool names accepted
+// by the major LLM APIs only contain letters, digits, '_' and '-', so other
+// characters such as dots are replaced by underscores, and are at most
+// MaxToolNameLength characters long: longer names are truncated and suffixed
+// with a hash of the function name, keeping them distinct.
+func ToolName(function string) string {
+	return toolName("", function)
+}
+
+// toolName derives the name of the tool of a function exposed with prefix.
+// The prefix is sanitized like the function name.
+func toolName(prefix, function string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, prefix+function)
+	if len(name) <= MaxToolNameLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(prefix + function))
+	return name[:MaxToolNameLength-toolNameHashLength-1] + "_" + hex.EncodeToString(sum[:])[:toolNameHashLength]
+}
+
+// newTool describes a single function as an LLM tool.
+func newTool(prefix, function string, schema Schema) Tool {
+	description := schema.Description
+	if schema.Deprecation != "" {
+		description = strings.TrimSpace(description + "\n\nDeprecated: " + schema.Deprecation)
+	}
+
+	return Tool{
+		Name:        toolName(prefix, function),
+		Description: description,
+		InputSchema: schemaOrObject(schema.Input),
+		Function:    function,
+	}
+}
+
+// OpenAITool is a tool definition in the OpenAI function-calling format.
+type OpenAITool struct {
+	Type     string             `json:"type"`
+	Function OpenAIToolFunction `json:"function"`
+}
+
+// OpenAIToolFunction is the function part of an OpenAITool.
+type OpenAIToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+// OpenAITools renders tools in the OpenAI function-calling format.
+func OpenAITools(tools []Tool) []OpenAITool {
+	out := make([]OpenAITool, 0, len(tools))
+	for _, tool := range tools {
+		out = append(out, OpenAITool{
+			Type: "function",
+			Function: OpenAIToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	return out
+}
+
+// AnthropicTool is a tool definition in the Anthropic Messages API format.
+type AnthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// AnthropicTools renders tools in the Anthropic Messages API format.
+func AnthropicTools(tools []Tool) []AnthropicTool {
+	out := make([]AnthropicTool, 0, len(tools))
+	for _, tool := range tools {
+		out = append(out, AnthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.InputSchema,
+		})
+	}
+	return out
+}
+
+// GeminiFunctionDeclaration is a tool definition in the Gemini API format.
+type GeminiFunctionDeclaration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+// GeminiFunctionDeclarations renders tools in the Gemini API format. Schema
+// keywords not supported by Gemini, such as additionalProperties, are removed.
+func GeminiFunctionDeclarations(tools []Tool) []GeminiFunctionDeclaration {
+	out := make([]GeminiFunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		parameters, _ := geminiSchema(tool.InputSchema).(map[string]any)
+		out = append(out, GeminiFunctionDeclaration{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  parameters,
+		})
+	}
+	return out
+}
+
+// geminiSchema returns a copy of a JSON schema without the keywords rejected by Gemini.
+func geminiSchema(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			switch key {
+			case "additionalProperties", "$schema", "$id", "$defs", "$ref":
+				continue
+			}
+			out[key] = geminiSchema(value)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, value := range v {
+			out[i] = geminiSchema(value)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// ToolCall is a tool invocation requested by an LLM. Arguments may be either a
+// JSON object or, as in the OpenAI format, a JSON string containing an object.
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolError describes a failed tool call in a way an LLM can act upon.
+type ToolError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// ToolResult is the outcome of a dispatched tool call. Content holds the JSON
+// output of the function when the call succeeded, Error otherwise.
+type ToolResult struct {
+	CallID  string          `json:"call_id"`
+	Name    string          `json:"name"`
+	Content json.RawMessage `json:"content,omitempty"`
+	Error   *ToolError      `json:"error,omitempty"`
+}
+
+// IsError reports whether the tool call failed.
+func (r ToolResult) IsError() bool {
+	return r.Error != nil
+}
+
+// Text returns the result as text: the function output, or a JSON object
+// describing the error.
+func (r ToolResult) Text() string {
+	if r.Error != nil {
+		b, _ := json.Marshal(map[string]*ToolError{"error": r.Error})
+		return string(b)
+	}
+	return string(r.Content)
+}
+
+// OpenAIToolMessage is a tool result message in the OpenAI format.
+type OpenAIToolMessage struct {
+	Role       string `json:"role"`
+	ToolCallID string `json:"tool_call_id"`
+	Content    string `json:"content"`
+}
+
+// OpenAIMessage renders the result as an OpenAI tool message.
+func (r ToolResult) OpenAIMessage() OpenAIToolMessage {
+	return OpenAIToolMessage{
+		Role:       "tool",
+		ToolCallID: r.CallID,
+		Content:    r.Text(),
+	}
+}
+
+// AnthropicToolResult is a tool result content block in the Anthropic format.
+type AnthropicToolResult struct {
+	Type      string `json:"type"`
+	ToolUseID string `json:"tool_use_id"`
+	Content   string `json:"content"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+// AnthropicContent renders the result as an Anthropic tool_result content block.
+func (r ToolResult) AnthropicContent() AnthropicToolResult {
+	return AnthropicToolResult{
+		Type:      "tool_result",
+		ToolUseID: r.CallID,
+		Content:   r.Text(),
+		IsError:   r.IsError(),
+	}
+}
+
+// GeminiFunctionResponse is a function response part in the Gemini format.
+type GeminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// GeminiResponse renders the result as a Gemini function response.
+func (r ToolResult) GeminiResponse() GeminiFunctionResponse {
+	response := map[string]any{}
+	if r.Error != nil {
+		response["error"] = r.Error
+	} else {
+		response["output"] = r.Content
+	}
+
+	return GeminiFunctionResponse{Name: r.Name, Response: response}
+}
+
+// toolRoute is the plugin function a tool is dispatched to.
+type toolRoute struct {
+	client *Client
+	tool   Tool
+}
+
+// toolOptions holds the optional configuration of ToolDispatcher.AddClient.
+type toolOptions struct {
+	prefix string
+}
+
+// ToolOption is a function that configures how a client's functions are exposed as tools.
+type ToolOption func(*toolOptions)
+
+// WithToolPrefix prepends prefix to the names of the client's tools, to avoid
+// collisions between plugins exposing functions with the same name.
+func WithToolPrefix(prefix string) ToolOption {
+	return func(o *toolOptions) {
+		o.prefix = prefix
+	}
+}
+
+// ToolDispatcher routes LLM tool calls to the plugin functions they describe.
+// Tools are dispatched by name to the function they were derived from, so
+// shortened tool names reach the function with the full name.
+type ToolDispatcher struct {
+	mu     sync.RWMutex
+	routes map[string]toolRoute
+}
+
+// NewToolDispatcher creates an empty tool dispatcher.
+func NewToolDispatcher() *ToolDispatcher {
+	return &ToolDispatcher{
+		routes: make(map[string]toolRoute),
+	}
+}
+
+// AddClient exposes every function of an open client as a tool.
+// It fails if a tool name is already used by another function.
+func (d *ToolDispatcher) AddClient(client *Client, opts ...ToolOption) error {
+	var options toolOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	schemas, err := client.Schemas()
+	if err != nil {
+		return &FunctionListError{Err: err}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	routes := make(map[string]toolRoute, len(schemas))
+	for function, schema := range schemas {
+		tool := newTool(options.prefix, function, schema)
+		if _, ok := d.routes[tool.Name]; ok {
+			return fmt.Errorf("tool %q is already registered", tool.Name)
+		}
+		if _, ok := routes[tool.Name]; ok {
+			return fmt.Errorf("tool %q is ambiguous in plugin %s", tool.Name, client.Name())
+		}
+		routes[tool.Name] = toolRoute{client: client, tool: tool}
+	}
+
+	for name, route := range routes {
+		d.routes[name] = route
+	}
+
+	return nil
+}
+
+// RemoveClient removes every tool dispatched to client.
+func (d *ToolDispatcher) RemoveClient(client *Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name, route := range d.routes {
+		if route.client == client {
+			delete(d.routes, name)
+		}
+	}
+}
+
+// Tools returns the tools known to the dispatcher, sorted by name.
+func (d *ToolDispatcher) Tools() []Tool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tools := make([]Tool, 0, len(d.routes))
+	for _, route := range d.routes {
+		tools = append(tools, route.tool)
+	}
+
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+
+	return tools
+}
+
+// Dispatch executes a tool call and returns its result. Failures are reported
+// in the result rather than as an error, so they can be sent back to the LLM.
+func (d *ToolDispatcher) Dispatch(ctx context.Context, call ToolCall) ToolResult {
+	result := ToolResult{CallID: call.ID, Name: call.Name}
+
+	d.mu.RLock()
+	route, ok := d.routes[call.Name]
+	d.mu.RUnlock()
+
+	if !ok {
+		result.Error = &ToolError{Type: ToolErrorNotFound, Message: fmt.Sprintf("unknown tool %q", call.Name)}
+		return result
+	}
+
+	arguments, err := toolArguments(call.Arguments)
+	if err != nil {
+		result.Error = &ToolError{Type: ToolErrorInvalidArguments, Message: err.Error()}
+		return result
+	}
+
+	output, err := route.client.Invoke(ctx, route.tool.Function, arguments)
+	if err != nil {
+		result.Error = toolError(err)
+		return result
+	}
+
+	result.Content = output
+	return result
+}
+
+// toolArguments normalizes tool call arguments to a JSON object.
+func toolArguments(arguments json.RawMessage) (json.RawMessage, error) {
+	arguments = json.RawMessage(strings.TrimSpace(string(arguments)))
+	if len(arguments) == 0 || string(arguments) == "null" {
+		return json.RawMessage("{}"), nil
+	}
+
+	if arguments[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(arguments, &encoded); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		arguments = json.RawMessage(encoded)
+	}
+
+	if !json.Valid(arguments) {
+		return nil, errors.New("arguments are not valid JSON")
+	}
+
+	return arguments, nil
+}
+
+// toolError maps a function call error to a ToolError.
+func toolError(err error) *ToolError {
+	var (
+		notFound   *FunctionNotFoundError
+		validation *ValidationError
+		panicked   *PluginPanicError
+	)
+
+	switch {
+	case errors.As(err, &notFound):
+		return &ToolError{Type: ToolErrorNotFound, Message: err.Error()}
+	case errors.As(err, &validation):
+		return &ToolError{Type: ToolErrorInvalidArguments, Message: validation.Message}
+	case errors.As(err, &panicked):
+		return &ToolError{Type: ToolErrorPanic, Message: err.Error()}
+	default:
+		return &ToolError{Type: ToolErrorExecution, Message: err.Error()}
+	}
+}
@@ -0,0 +1,205 @@
+package pluggo
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestToolName(t *testing.T) {
+	if got := ToolName("math.add"); got != "math_add" {
+		t.Errorf("ToolName = %q, want math_add", got)
+	}
+	if got := toolName("my.plugin ", "math.add"); got != "my_plugin_math_add" {
+		t.Errorf("prefixed tool name = %q, want my_plugin_math_add", got)
+	}
+
+	long := ToolName(longFunctionName)
+	other := ToolName(strings.Replace(longFunctionName, "greet", "greeter", 1))
+	if len(long) != MaxToolNameLength || len(other) != MaxToolNameLength {
+		t.Errorf("long tool names = %q, %q, want %d characters", long, other, MaxToolNameLength)
+	}
+	if long == other || !strings.HasPrefix(long, "nested_nested_") {
+		t.Errorf("long tool names = %q, %q, want distinct truncated names", long, other)
+	}
+	if long != ToolName(longFunctionName) {
+		t.Error("tool names are not stable")
+	}
+
+	if got := toolName(strings.Repeat("p", 60)+"_", "greet"); len(got) != MaxToolNameLength {
+		t.Errorf("prefixed tool name = %q, want %d characters", got, MaxToolNameLength)
+	}
+}
+
+func TestToolArguments(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		want      string
+		wantErr   bool
+	}{
+		{name: "object", arguments: `{"name":"ada"}`, want: `{"name":"ada"}`},
+		{name: "encoded object", arguments: `"{\"name\":\"ada\"}"`, want: `{"name":"ada"}`},
+		{name: "missing", arguments: ``, want: `{}`},
+		{name: "null", arguments: ` null `, want: `{}`},
+		{name: "invalid", arguments: `{"name":`, wantErr: true},
+		{name: "invalid encoded object", arguments: `"{\"name\":"`, wantErr: true},
+		{name: "invalid string", arguments: `"unterminated`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toolArguments(json.RawMessage(tt.arguments))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("toolArguments = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("toolArguments = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolRenderers(t *testing.T) {
+	tools := []Tool{{
+		Name:        "greet",
+		Description: "Greets someone.",
+		InputSchema: map[string]any{
+			"type":                 "object",
+			"$schema":              "https://json-schema.org/draft/2020-12/schema",
+			"additionalProperties": false,
+			"properties": map[string]any{
+				"name": map[string]any{"type": "string", "additionalProperties": false},
+			},
+		},
+	}}
+
+	for _, tt := range []struct {
+		name   string
+		render any
+		want   string
+	}{
+		{
+			name:   "openai",
+			render: OpenAITools(tools),
+			want:   `[{"type":"function","function":{"name":"greet","description":"Greets someone.","parameters":{"$schema":"https://json-schema.org/draft/2020-12/schema","additionalProperties":false,"properties":{"name":{"additionalProperties":false,"type":"string"}},"type":"object"}}}]`,
+		},
+		{
+			name:   "anthropic",
+			render: AnthropicTools(tools),
+			want:   `[{"name":"greet","description":"Greets someone.","input_schema":{"$schema":"https://json-schema.org/draft/2020-12/schema","additionalProperties":false,"properties":{"name":{"additionalProperties":false,"type":"string"}},"type":"object"}}]`,
+		},
+		{
+			name:   "gemini",
+			render: GeminiFunctionDeclarations(tools),
+			want:   `[{"name":"greet","description":"Greets someone.","parameters":{"properties":{"name":{"type":"string"}},"type":"object"}}]`,
+		},
+	} {
+		got, err := json.Marshal(tt.render)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s tools = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, ok := tools[0].InputSchema["additionalProperties"]; !ok {
+		t.Error("rendering Gemini declarations modified the tool schema")
+	}
+}
+
+func TestToolResultRenderers(t *testing.T) {
+	ok := ToolResult{CallID: "call-1", Name: "greet", Content: json.RawMessage(`{"greeting":"hello"}`)}
+	failed := ToolResult{CallID: "call-2", Name: "greet", Error: &ToolError{Type: ToolErrorNotFound, Message: "unknown"}}
+
+	for _, tt := range []struct {
+		name   string
+		render any
+		want   string
+	}{
+		{name: "openai", render: ok.OpenAIMessage(), want: `{"role":"tool","tool_call_id":"call-1","content":"{\"greeting\":\"hello\"}"}`},
+		{name: "anthropic", render: ok.AnthropicContent(), want: `{"type":"tool_result","tool_use_id":"call-1","content":"{\"greeting\":\"hello\"}"}`},
+		{name: "gemini", render: ok.GeminiResponse(), want: `{"name":"greet","response":{"output":{"greeting":"hello"}}}`},
+		{name: "openai error", render: failed.OpenAIMessage(), want: `{"role":"tool","tool_call_id":"call-2","content":"{\"error\":{\"type\":\"not_found\",\"message\":\"unknown\"}}"}`},
+		{name: "anthropic error", render: failed.AnthropicContent(), want: `{"type":"tool_result","tool_use_id":"call-2","content":"{\"error\":{\"type\":\"not_found\",\"message\":\"unknown\"}}","is_error":true}`},
+		{name: "gemini error", render: failed.GeminiResponse(), want: `{"name":"greet","response":{"error":{"type":"not_found","message":"unknown"}}}`},
+	} {
+		got, err := json.Marshal(tt.render)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s result = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestToolDispatcher(t *testing.T) {
+	client := openProcessPlugin(t)
+	dispatcher := NewToolDispatcher()
+	if err := dispatcher.AddClient(client, WithToolPrefix("proc_")); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+
+	tools := dispatcher.Tools()
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	longTool := toolName("proc_", longFunctionName)
+	if want := []string{"proc_crash", "proc_greet", longTool, "proc_pid", "proc_register", "proc_sleep"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("tools = %q, want %q", names, want)
+	}
+	if tools[1].Description != "Greets someone." || tools[1].Function != "greet" || tools[1].InputSchema["type"] != "object" {
+		t.Errorf("greet tool = %+v", tools[1])
+	}
+
+	tests := []struct {
+		name      string
+		tool      string
+		arguments string
+		want      string
+		wantError string
+	}{
+		{name: "object arguments", tool: "proc_greet", arguments: `{"name":"ada"}`, want: `{"greeting":"hello ada"}`},
+		{name: "encoded arguments", tool: "proc_greet", arguments: `"{\"name\":\"bob\"}"`, want: `{"greeting":"hello bob"}`},
+		{name: "shortened name", tool: longTool, arguments: `{"name":"eve"}`, want: `{"greeting":"hello eve"}`},
+		{name: "unknown tool", tool: "proc_unknown", wantError: ToolErrorNotFound},
+		{name: "malformed arguments", tool: "proc_greet", arguments: `{"name":`, wantError: ToolErrorInvalidArguments},
+		{name: "invalid arguments", tool: "proc_greet", arguments: `{"name":""}`, wantError: ToolErrorInvalidArguments},
+		{name: "panic", tool: "proc_crash", wantError: ToolErrorPanic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := dispatcher.Dispatch(context.Background(), ToolCall{ID: "call", Name: tt.tool, Arguments: json.RawMessage(tt.arguments)})
+			if result.CallID != "call" || result.Name != tt.tool {
+				t.Errorf("result of call %s = %+v", tt.tool, result)
+			}
+
+			if tt.wantError != "" {
+				if !result.IsError() || result.Error.Type != tt.wantError {
+					t.Errorf("result = %+v, want a %s error", result, tt.wantError)
+				}
+				return
+			}
+			if result.IsError() || !jsonEqual(result.Content, tt.want) {
+				t.Errorf("result = %s %+v, want %s", result.Content, result.Error, tt.want)
+			}
+		})
+	}
+
+	if err := dispatcher.AddClient(client, WithToolPrefix("proc_")); err == nil {
+		t.Error("tools with the names of registered tools were added")
+	}
+
+	dispatcher.RemoveClient(client)
+	if tools := dispatcher.Tools(); len(tools) != 0 {
+		t.Errorf("tools after RemoveClient = %+v", tools)
+	}
+}
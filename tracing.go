@@ -0,0 +1,138 @@
+package pluggo
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	traceParentHeader = "traceparent"
+	traceStateHeader  = "tracestate"
+	baggageHeader     = "baggage"
+)
+
+// TraceContext holds the W3C trace context values that cross the plugin boundary.
+// See https://www.w3.org/TR/trace-context/ and https://www.w3.org/TR/baggage/.
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+	Baggage     string
+}
+
+// IsValid reports whether the trace context carries a trace parent.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceParent != ""
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a copy of ctx carrying the given trace context.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context carried by ctx, if any.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok && tc.IsValid()
+}
+
+// Propagator injects trace context into outgoing request headers and extracts
+// it from incoming ones. OpenTelemetry's TextMapPropagator can be adapted to it
+// by wrapping the header in a propagation.HeaderCarrier.
+type Propagator interface {
+	Inject(ctx context.Context, header http.Header)
+	Extract(ctx context.Context, header http.Header) context.Context
+}
+
+// W3CPropagator propagates the traceparent, tracestate and baggage headers
+// using the TraceContext stored in the context. It is the default propagator.
+type W3CPropagator struct{}
+
+// Inject writes the trace context carried by ctx into header.
+func (W3CPropagator) Inject(ctx context.Context, header http.Header) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	header.Set(traceParentHeader, tc.TraceParent)
+	if tc.TraceState != "" {
+		header.Set(traceStateHeader, tc.TraceState)
+	}
+	if tc.Baggage != "" {
+		header.Set(baggageHeader, tc.Baggage)
+	}
+}
+
+// Extract returns a copy of ctx carrying the trace context found in header.
+func (W3CPropagator) Extract(ctx context.Context, header http.Header) context.Context {
+	tc := TraceContext{
+		TraceParent: header.Get(traceParentHeader),
+		TraceState:  header.Get(traceStateHeader),
+		Baggage:     header.Get(baggageHeader),
+	}
+	if !tc.IsValid() {
+		return ctx
+	}
+
+	return ContextWithTraceContext(ctx, tc)
+}
+
+// SpanKind describes the side of the plugin boundary a span belongs to.
+type SpanKind int
+
+const (
+	// SpanKindClient is used for spans created by the host around function calls.
+	SpanKindClient SpanKind = iota + 1
+	// SpanKindServer is used for spans created by the plugin around function handlers.
+	SpanKindServer
+)
+
+// String returns the name of the span kind.
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindClient:
+		return "client"
+	case SpanKindServer:
+		return "server"
+	default:
+		return "unspecified"
+	}
+}
+
+// Tracer is the hook used to create spans around plugin calls, on both sides
+// of the boundary. Implementations typically adapt an OpenTelemetry tracer so
+// that pluggo does not depend on it. The returned context must carry the new
+// span, so that the Propagator can inject it into the outgoing request.
+type Tracer interface {
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+// Span is a single traced operation created by a Tracer.
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+}
+
+// startSpan starts a span with tracer, or returns ctx and a nil span if tracer is nil.
+func startSpan(ctx context.Context, tracer Tracer, name string, kind SpanKind) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	return tracer.Start(ctx, name, kind)
+}
+
+// endSpan records err, if any, and ends span. It is a no-op for a nil span.
+func endSpan(span Span, err error) {
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -1,29 +1,282 @@
 package pluggo
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/kaptinlin/jsonschema"
 )
 
 // Validator provides JSON schema validation for input data.
 // It uses a compiled JSON schema to validate incoming data against
-// the expected structure before deserialization.
+// the expected structure before deserialization, then runs the validation
+// hooks added with AddHook on the decoded input.
 type Validator[T any] struct {
-	schema *jsonschema.Schema
+	schema   *jsonschema.Schema
+	document map[string]any
+	options  validatorOptions
+	hooks    []func(context.Context, *T) error
+}
+
+// validatorOptions holds the optional configuration of a Validator.
+type validatorOptions struct {
+	defaults      bool
+	coercion      bool
+	assertFormats bool
+	formats       map[string]func(any) bool
+}
+
+// ValidatorOption is a function that configures a Validator during creation.
+type ValidatorOption func(*validatorOptions)
+
+// WithFormat registers a custom format, used with the `jsonschema:"format=name"`
+// tag: values of fields with that format are valid when validate returns true.
+// Custom formats are always enforced, unlike the built-in ones: see
+// WithFormatAssertion.
+func WithFormat(name string, validate func(any) bool) ValidatorOption {
+	return func(o *validatorOptions) {
+		if o.formats == nil {
+			o.formats = make(map[string]func(any) bool)
+		}
+		o.formats[name] = validate
+	}
+}
+
+// WithFormatAssertion enforces the built-in formats, such as email or
+// date-time, which are otherwise only annotations, and rejects values of
+// unknown formats.
+func WithFormatAssertion() ValidatorOption {
+	return func(o *validatorOptions) {
+		o.assertFormats = true
+	}
 }
 
 // NewValidator creates a new validator for type T by generating a JSON schema
 // from the provided struct. The validator can then be used to validate
-// JSON input before deserialization.
-func NewValidator[T any](v *T) (*Validator[T], error) {
+// JSON input before deserialization. Options can be provided to normalize
+// inputs before validating them or to register custom formats.
+func NewValidator[T any](v *T, opts ...ValidatorOption) (*Validator[T], error) {
+	return newValidator[T](v, opts)
+}
+
+// newValidator creates a validator for the struct pointed to by v.
+func newValidator[T any](v any, opts []ValidatorOption) (*Validator[T], error) {
+	var options validatorOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	schema, err := structAsJSONSchema(v)
 	if err != nil {
 		return nil, fmt.Errorf("error generating input schema: %w", err)
 	}
 
+	schemaValidator, err := compileJSONSchema(schema, options.formats, options.assertFormats)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator[T]{schema: schemaValidator, document: schema, options: options}, nil
+}
+
+// AddHook adds a validation hook, run on the decoded input after the schema
+// validation and before the function, e.g. to check constraints spanning
+// several fields or needing lookups. Errors returned by hooks reject the
+// input with a ValidationError when they are a FieldError, a ValidationError
+// or several of them joined with errors.Join, pointing at the offending
+// fields. Any other error fails the call as if returned by the function.
+// Hooks must be added before the validator is used.
+func (v *Validator[T]) AddHook(hook func(ctx context.Context, input *T) error) *Validator[T] {
+	v.hooks = append(v.hooks, hook)
+	return v
+}
+
+// Validate checks the provided data against the compiled JSON schema.
+// It returns an evaluation result that contains validation status and
+// any errors found during validation.
+func (v *Validator[T]) Validate(data any) *jsonschema.EvaluationResult {
+	return v.schema.Validate(data)
+}
+
+// validate checks raw JSON input against the compiled JSON schema and
+// returns a ValidationError describing every violation.
+func (v *Validator[T]) validate(data []byte) error {
+	result := v.Validate(data)
+	if result.IsValid() {
+		return nil
+	}
+	return newValidationError(schemaViolations(result, data))
+}
+
+// runHooks runs the validation hooks on a decoded input, returning a
+// ValidationError merging their field errors, or the first other error.
+func (v *Validator[T]) runHooks(ctx context.Context, input any) error {
+	in, ok := input.(*T)
+	if !ok {
+		return nil
+	}
+
+	var fields []FieldError
+	for _, hook := range v.hooks {
+		if err := hook(ctx, in); err != nil {
+			violations, ok := hookViolations(err)
+			if !ok {
+				return err
+			}
+			fields = append(fields, violations...)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return newValidationError(fields)
+}
+
+// inputValidator is the untyped form of Validator used by function handlers.
+type inputValidator interface {
+	check(data []byte) ([]byte, *NormalizationReport, error)
+	runHooks(ctx context.Context, input any) error
+}
+
+// applicatorKeywords are the keywords whose violations are reported in
+// detail by the evaluation of their subschemas.
+var applicatorKeywords = map[string]bool{
+	"properties": true, "patternProperties": true, "additionalProperties": true,
+	"items": true, "prefixItems": true, "contains": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+	"if": true, "then": true, "else": true, "$ref": true, "$dynamicRef": true,
+	"dependentSchemas": true, "unevaluatedProperties": true, "unevaluatedItems": true,
+}
+
+// schemaViolations lists the field errors of an evaluation result of data.
+func schemaViolations(result *jsonschema.EvaluationResult, data []byte) []FieldError {
+	var document any
+	_ = json.Unmarshal(data, &document)
+
+	var fields []FieldError
+	var collect func(result *jsonschema.EvaluationResult, base string)
+	collect = func(result *jsonschema.EvaluationResult, base string) {
+		path := base + result.InstanceLocation
+
+		// Missing required properties are evaluated as null values, and are
+		// reported by the required keyword of their parent instead
+		if !pointerExists(document, path) {
+			return
+		}
+
+		var detailed bool
+		for _, detail := range result.Details {
+			if !detail.IsValid() {
+				detailed = true
+				collect(detail, path)
+			}
+		}
+
+		for keyword, err := range result.Errors {
+			if detailed && applicatorKeywords[keyword] {
+				continue
+			}
+			fields = append(fields, FieldError{Field: path, Message: err.Error()})
+		}
+	}
+	collect(result, "")
+
+	return fields
+}
+
+// hookViolations lists the field errors of an error returned by a validation
+// hook, reporting false when it is not made of field or validation errors.
+func hookViolations(err error) ([]FieldError, bool) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) && len(validationErr.Fields) > 0 {
+		return validationErr.Fields, true
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var fields []FieldError
+		for _, err := range joined.Unwrap() {
+			violations, ok := hookViolations(err)
+			if !ok {
+				return nil, false
+			}
+			fields = append(fields, violations...)
+		}
+		return fields, true
+	}
+
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		return []FieldError{*fieldErr}, true
+	}
+	if validationErr != nil {
+		return []FieldError{{Message: validationErr.Message}}, true
+	}
+	return nil, false
+}
+
+// newValidationError builds the ValidationError reporting field errors,
+// sorted by field.
+func newValidationError(fields []FieldError) *ValidationError {
+	slices.SortStableFunc(fields, func(a, b FieldError) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field.Error())
+	}
+
+	return &ValidationError{Message: strings.Join(messages, "; "), Fields: fields}
+}
+
+// pointerExists reports whether a JSON pointer designates a value of document.
+func pointerExists(document any, pointer string) bool {
+	if pointer == "" {
+		return true
+	}
+
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+
+		switch value := document.(type) {
+		case map[string]any:
+			var ok bool
+			if document, ok = value[token]; !ok {
+				return false
+			}
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(value) {
+				return false
+			}
+			document = value[i]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// compileJSONSchema compiles a JSON schema for validation, with custom formats.
+// The compiler only enforces formats all together, so when just the custom
+// ones are asserted the other formats of the schema accept any value.
+func compileJSONSchema(schema map[string]any, formats map[string]func(any) bool, assertFormats bool) (*jsonschema.Schema, error) {
 	compiler := jsonschema.NewCompiler()
+	if !assertFormats && len(formats) > 0 {
+		for _, name := range schemaFormats(schema) {
+			compiler.RegisterFormat(name, func(any) bool { return true })
+		}
+	}
+	for name, validate := range formats {
+		compiler.RegisterFormat(name, validate)
+	}
+	compiler.SetAssertFormat(assertFormats || len(formats) > 0)
 
 	// Marshal schema to JSON
 	schemaBytes, err := json.Marshal(schema)
@@ -36,12 +289,24 @@ func NewValidator[T any](v *T) (*Validator[T], error) {
 		return nil, fmt.Errorf("failed to compile schema: %w", err)
 	}
 
-	return &Validator[T]{schema: schemaValidator}, nil
+	return schemaValidator, nil
 }
 
-// Validate checks the provided data against the compiled JSON schema.
-// It returns an evaluation result that contains validation status and
-// any errors found during validation.
-func (v *Validator[T]) Validate(data any) *jsonschema.EvaluationResult {
-	return v.schema.Validate(data)
+// schemaFormats lists the formats named anywhere in a JSON schema.
+func schemaFormats(schema any) []string {
+	var names []string
+	switch value := schema.(type) {
+	case map[string]any:
+		if name, ok := value["format"].(string); ok {
+			names = append(names, name)
+		}
+		for _, child := range value {
+			names = append(names, schemaFormats(child)...)
+		}
+	case []any:
+		for _, child := range value {
+			names = append(names, schemaFormats(child)...)
+		}
+	}
+	return names
 }
@@ -0,0 +1,203 @@
+package pluggo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type rangeInput struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type rangeOutput struct {
+	Length int `json:"length"`
+}
+
+func length(_ context.Context, in *rangeInput) (*rangeOutput, error) {
+	return &rangeOutput{Length: in.End - in.Start}, nil
+}
+
+// checkRange is a validation hook rejecting ranges ending before they start
+// and ranges starting below zero.
+func checkRange(_ context.Context, in *rangeInput) error {
+	var errs []error
+	if in.End < in.Start {
+		errs = append(errs, &FieldError{Field: "/end", Message: "must not be before start"})
+	}
+	if in.Start < 0 {
+		errs = append(errs, &FieldError{Field: "/start", Message: "must not be negative"})
+	}
+	return errors.Join(errs...)
+}
+
+// fieldsOf returns the fields of the FieldErrors of a ValidationError.
+func fieldsOf(t *testing.T, err error) []string {
+	t.Helper()
+
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("error = %v, want a ValidationError", err)
+	}
+
+	fields := make([]string, 0, len(validation.Fields))
+	for _, field := range validation.Fields {
+		fields = append(fields, field.Field)
+	}
+	return fields
+}
+
+func TestValidationHooks(t *testing.T) {
+	validator, err := NewValidator(&rangeInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator.AddHook(checkRange).AddHook(func(_ context.Context, in *rangeInput) error {
+		if in.End-in.Start > 100 {
+			return errors.New("range too long")
+		}
+		return nil
+	})
+
+	p := newTestPlugin(t)
+	if err := p.AddFunction("length", NewFunctionHandler(length, validator).Handler()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		input      rangeInput
+		wantFields []string
+	}{
+		{name: "valid", input: rangeInput{Start: 1, End: 3}},
+		{name: "field errors", input: rangeInput{Start: -1, End: -2}, wantFields: []string{"/end", "/start"}},
+		{name: "plain error", input: rangeInput{Start: 0, End: 200}},
+	}
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			f := newTestFunction[rangeInput, rangeOutput](t, "length", serve(t, p, protocol))
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					out, err := f.Call(&tt.input)
+					if tt.name == "plain error" {
+						var executionErr *FunctionExecutionError
+						if !errors.As(err, &executionErr) {
+							t.Fatalf("error = %v, want a FunctionExecutionError", err)
+						}
+						return
+					}
+					if tt.wantFields == nil {
+						if err != nil || out.Length != tt.input.End-tt.input.Start {
+							t.Fatalf("Call = %v, %v", out, err)
+						}
+						return
+					}
+
+					if fields := fieldsOf(t, err); !slices.Equal(fields, tt.wantFields) {
+						t.Errorf("fields = %q, want %q", fields, tt.wantFields)
+					}
+				})
+			}
+		})
+	}
+}
+
+type productInput struct {
+	SKU   string `json:"sku" jsonschema:"format=sku"`
+	Email string `json:"email,omitempty" jsonschema:"format=email"`
+}
+
+func TestValidatorFormats(t *testing.T) {
+	sku := func(v any) bool {
+		s, ok := v.(string)
+		return !ok || strings.HasPrefix(s, "SKU-")
+	}
+
+	tests := []struct {
+		name       string
+		input      string
+		opts       []ValidatorOption
+		wantFields []string
+	}{
+		{name: "custom format", input: `{"sku":"SKU-1"}`, opts: []ValidatorOption{WithFormat("sku", sku)}},
+		{name: "invalid custom format", input: `{"sku":"1"}`, opts: []ValidatorOption{WithFormat("sku", sku)}, wantFields: []string{"/sku"}},
+		{name: "built-in formats stay annotations", input: `{"sku":"SKU-1","email":"nobody"}`, opts: []ValidatorOption{WithFormat("sku", sku)}},
+		{name: "built-in formats asserted", input: `{"sku":"SKU-1","email":"nobody"}`, opts: []ValidatorOption{WithFormat("sku", sku), WithFormatAssertion()}, wantFields: []string{"/email"}},
+		{name: "valid built-in format asserted", input: `{"sku":"SKU-1","email":"nobody@example.com"}`, opts: []ValidatorOption{WithFormat("sku", sku), WithFormatAssertion()}},
+		{name: "formats are annotations by default", input: `{"sku":"1","email":"nobody"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := NewValidator(&productInput{}, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = validator.check([]byte(tt.input))
+			if tt.wantFields == nil {
+				if err != nil {
+					t.Fatalf("check: %v", err)
+				}
+				return
+			}
+			if fields := fieldsOf(t, err); !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("fields = %q, want %q", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+// rangeService is a service whose methods take different inputs.
+type rangeService struct{}
+
+func (rangeService) Length(ctx context.Context, in *rangeInput) (*rangeOutput, error) {
+	return length(ctx, in)
+}
+
+func (rangeService) Greet(ctx context.Context, in *greetInput) (*greetOutput, error) {
+	return greet(ctx, in)
+}
+
+func TestServiceHooks(t *testing.T) {
+	p := NewPlugin(WithLogger(slog.New(slog.DiscardHandler)))
+	err := p.RegisterService(rangeService{},
+		WithServicePrefix("range."),
+		WithServiceHook(checkRange),
+		WithServiceHook(func(_ context.Context, in *rangeInput) error {
+			if in.End > 1000 {
+				return &FieldError{Field: "/end", Message: "must not exceed 1000"}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("RegisterService: %v", err)
+	}
+
+	for _, protocol := range protocols {
+		t.Run(string(protocol), func(t *testing.T) {
+			connection := serve(t, p, protocol)
+			f := newTestFunction[rangeInput, rangeOutput](t, "range.length", connection)
+
+			if out, err := f.Call(&rangeInput{Start: 1, End: 3}); err != nil || out.Length != 2 {
+				t.Fatalf("valid input = %v, %v", out, err)
+			}
+			_, err := f.Call(&rangeInput{Start: -1, End: 2000})
+			if fields := fieldsOf(t, err); !slices.Equal(fields, []string{"/end", "/start"}) {
+				t.Errorf("fields = %q, want the errors of both hooks", fields)
+			}
+
+			out, err := newTestFunction[greetInput, greetOutput](t, "range.greet", connection).Call(&greetInput{Name: "ada"})
+			if err != nil || out.Greeting != "hello ada" {
+				t.Errorf("method with another input = %v, %v", out, err)
+			}
+		})
+	}
+}